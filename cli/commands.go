@@ -1,378 +1,307 @@
 package run
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"os"
-	"strconv"
+	"os/signal"
+	"regexp"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/docker/docker/api/types/container"
-	dockerClient "github.com/docker/docker/client"
+	"k3d-go/pkg/k3d"
+
+	"github.com/moby/term"
+	"github.com/olekukonko/tablewriter"
+	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
-const (
-	defaultRegistry    = "docker.io"
-	defaultServerCount = 1
-)
+// commandContext returns a context that is canceled when k3d receives SIGINT (Ctrl-C) or SIGTERM,
+// so that long running operations (pulls, container creation, waits) can be aborted cleanly.
+// The returned function must be called to release the signal handler.
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
 
 // CheckTools checks if the installed tools work correctly
 // command: docker version
 func CheckTools(c *cli.Context) error {
-	log.Print("Checking docker...")
-	ctx := context.Background()
+	log.Info("Checking docker...")
+	ctx, stop := commandContext()
+	defer stop()
 
-	docker, err := dockerClient.NewClientWithOpts(dockerClient.FromEnv)
+	client, err := k3d.NewDockerClient()
 	if err != nil {
 		return err
 	}
-	// Ping pings the server and returns the value of the "Docker-Experimental", "Builder-Version", "OS-Type" & "API-Version" headers.
-	ping, err := docker.Ping(ctx)
+	// Ping pings the server and returns the value of the "API-Version" header.
+	apiVersion, err := client.Ping(ctx)
 	if err != nil {
-		return fmt.Errorf("ERROR: checking docker failed\n%+v", err)
+		return fmt.Errorf("checking docker failed\n%+v", err)
 	}
-	log.Printf("SUCCESS: Checking docker succeeded (API: v%s)\n", ping.APIVersion)
+	log.Infof("Checking docker succeeded (API: v%s)", apiVersion)
 	return nil
 }
 
 // CreateCluster creates a new single-node cluster container and initializes the cluster directory
 func CreateCluster(c *cli.Context) error {
 
-	//handle cluster name
-	if err := CheckClusterName(c.String("name")); err != nil {
-		return err
-	}
-
-	// Check for cluster existence before using a name to create a new cluster
-	if cluster, err := getClusters(false, c.String("name")); err != nil {
-		return err
-	} else if len(cluster) != 0 {
-		// A cluster exists with the same name. Return with an error.
-		return fmt.Errorf("ERROR: Cluster %s already exists", c.String("name"))
-	}
-
-	// On Error delete the cluster.  If there createCluster() encounter any error,
-	// call this function to remove all resources allocated for the cluster so far
-	// so that they don't linger around.
-	deleteCluster := func() {
-		if err := DeleteCluster(c); err != nil {
-			log.Printf("Error: Failed to delete cluster %s", c.String("name"))
-		}
-	}
-
 	// define image
 	image := c.String("image") //for now: docker.io/rancher/k3s:latest
 	if c.IsSet("version") {
 		// TODO: --version to be deprecated
-		log.Println("[WARNING] The `--version` flag will be deprecated soon, please use `--image rancher/k3s:<version>` instead")
+		log.Warn("The `--version` flag will be deprecated soon, please use `--image rancher/k3s:<version>` instead")
 		if c.IsSet("image") {
 			// version specified, custom image = error (to push deprecation of version flag)
-			log.Fatalln("[ERROR] Please use `--image <image>:<version>` instead of --image and --version")
+			return errors.New("Please use `--image <image>:<version>` instead of --image and --version")
 		} else {
 			// version specified, default image = ok (until deprecation of version flag)
 			// docker.io/rancher/k3s:
 			image = fmt.Sprintf("%s:%s", strings.Split(image, ":")[0], c.String("version"))
 		}
 	}
-	if len(strings.Split(image, "/")) <= 2 {
-		// fallback to default registry
-		image = fmt.Sprintf("%s/%s", defaultRegistry, image)
+
+	if c.IsSet("port") {
+		// log.Warn("As of v2.0.0 --port will be used for arbitrary port-mappings. It's original functionality can then be used via --api-port.")
+		log.Info("As of v2.0.0 --port will be used for arbitrary port mapping. Please use --api-port/-a instead for configuring the Api Port")
 	}
 
-	// create cluster network
-	networkID, err := createClusterNetwork(c.String("name"))
+	pullPolicy, err := k3d.ParsePullPolicy(c.String("pull"))
 	if err != nil {
 		return err
 	}
-	log.Printf("Created cluster network with ID %s", networkID)
-
-	// environment variables
-	env := []string{"K3S_KUBECONFIG_OUTPUT=/output/kubeconfig.yaml"}
-	env = append(env, c.StringSlice("env")...)
-
-	// clusterSecret and token is a must. otherwise we can't join the server with workers
-	k3sClusterSecret := ""
-	k3sToken := ""
 
-	//The cluster secret and token to the environment variables
-	k3sClusterSecret = fmt.Sprintf("K3S_CLUSTER_SECRET=%s", GenerateRandomString(20))
-	k3sToken = fmt.Sprintf("K3S_TOKEN=%s", GenerateRandomString(20))
-	env = append(env, k3sClusterSecret, k3sToken)
+	if c.IsSet("from") {
+		spec, err := clusterSpecFromDefinition(c, image)
+		if err != nil {
+			return err
+		}
+		spec.Wait = c.IsSet("wait")
+		spec.Timeout = time.Duration(c.Int("wait")) * time.Second
+		spec.Pull = pullPolicy
+		spec.Verbose = c.GlobalBool("verbose")
+		return createCluster(c, spec)
+	}
 
-	if c.IsSet("port") {
-		// log.Println("WARNING: As of v2.0.0 --port will be used for arbitrary port-mappings. It's original functionality can then be used via --api-port.")
-		log.Println("INFO: As of v2.0.0 --port will be used for arbitrary port mapping. Please use --api-port/-a instead for configuring the Api Port")
+	memory, err := memoryLimits(c)
+	if err != nil {
+		return err
 	}
+	spec := &k3d.ClusterSpec{
+		Name:           c.String("name"),
+		Image:          image,
+		Workers:        c.Int("workers"),
+		APIPort:        c.String("api-port"),
+		Ports:          c.StringSlice("publish"),
+		PortAutoOffset: c.Int("port-auto-offset"),
+		Volumes:        c.StringSlice("volume"),
+		Env:            c.StringSlice("env"),
+		ServerArgs:     c.StringSlice("server-arg"),
+		AgentArgs:      c.StringSlice("agent-arg"),
+		NodeLabels:     c.StringSlice("node-label"),
+		NodeTaints:     c.StringSlice("node-taint"),
+		Memory:         memory,
+		CPUs:           c.StringSlice("cpus"),
+		PidsLimit:      c.StringSlice("pids-limit"),
+		Manifests:      c.StringSlice("manifest"),
+		HelmCharts:     c.StringSlice("helm-chart"),
+		Disable:        c.StringSlice("disable"),
+		Ingress:        c.String("ingress"),
+		Token:          c.String("token"),
+		AutoRestart:    c.Bool("auto-restart"),
+		DataVolumes:    c.Bool("data-volumes"),
+		IPv6:           c.Bool("ipv6"),
+		DualStack:      c.Bool("dual-stack"),
+		NoHostEntry:    c.Bool("no-host-entry"),
+		Wait:           c.IsSet("wait"),
+		Timeout:        time.Duration(c.Int("wait")) * time.Second, //timeout time calc
+		Pull:           pullPolicy,
+		Verbose:        c.GlobalBool("verbose"),
+	}
+
+	return createCluster(c, spec)
+}
 
-	apiPort, err := parseAPIPort(c.String("api-port"))
+// createCluster creates a cluster from a spec and shows how to use it
+func createCluster(c *cli.Context, spec *k3d.ClusterSpec) error {
+	client, err := k3d.NewDockerClient()
 	if err != nil {
 		return err
 	}
+	ctx, stop := commandContext()
+	defer stop()
+	if _, err := client.CreateCluster(ctx, spec); err != nil {
+		return err
+	}
 
-	k3sServerArgs := []string{"--https-listen-port", apiPort.Port}
+	// after server and worker node creation showing this message
+	log.Infof(`You can now use the cluster with:
 
-	// see why docker client doesn't pay attention to DOCKER_MACHINE_NAME..
-	// 	It turns out that docker client only pays attention to the following
-	// 	environment variables:
-	// 	DOCKER_HOST to set the url to the docker server.
-	// 	DOCKER_API_VERSION to set the version of the API to reach, leave empty for latest.
-	// 	DOCKER_CERT_PATH to load the TLS certificates from.
-	// 	DOCKER_TLS_VERIFY to enable or disable TLS verification, off by default.
-	// 	A miss configured DOCKER_MACHINE_NAME won't affect docker client, so k3d
-	// 	should just ignore the error.
+export KUBECONFIG="$(%s get-kubeconfig --name='%s')"
+kubectl cluster-info`, os.Args[0], spec.Name)
 
-	if apiPort.Host == "" {
-		apiPort.Host, err = getDockerMachineIp()
-		// IP address is the same as the host
-		apiPort.HostIP = apiPort.Host
-		if err != nil {
-			log.Printf("WARNING: Failed to get docker machine IP address, ignoring the DOCKER_MACHINE_NAME environment variable setting.\n")
-		}
-	}
+	return nil
+}
 
-	if apiPort.Host != "" {
-		// Add TLS SAN for non default host name
-		log.Printf("Add TLS SAN for %s", apiPort.Host)
-		k3sServerArgs = append(k3sServerArgs, "--tls-san", apiPort.Host)
+// clusterSpecFromDefinition reads the cluster definition file given by --from (see ExportCluster).
+// Flags that are set explicitly override the values of the file, list flags (e.g. --publish) are appended.
+func clusterSpecFromDefinition(c *cli.Context, image string) (*k3d.ClusterSpec, error) {
+	definition, err := k3d.ReadClusterDefinition(c.String("from"))
+	if err != nil {
+		return nil, err
 	}
-
-	if c.IsSet("server-arg") || c.IsSet("x") {
-		k3sServerArgs = append(k3sServerArgs, c.StringSlice("server-arg")...)
+	if c.IsSet("name") {
+		definition.Rename(c.String("name"))
 	}
+	spec := definition.ClusterSpec()
 
-	portmap, err := mapNodesToPortSpecs(c.StringSlice("publish"), GetAllContainerNames(c.String("name"), defaultServerCount, c.Int("workers")))
+	if c.IsSet("image") || c.IsSet("version") {
+		spec.Image = image
+	}
+	if c.IsSet("workers") {
+		spec.Workers = c.Int("workers")
+	}
+	if c.IsSet("api-port") {
+		spec.APIPort = c.String("api-port")
+	}
+	if c.IsSet("port-auto-offset") {
+		spec.PortAutoOffset = c.Int("port-auto-offset")
+	}
+	spec.Ports = append(spec.Ports, c.StringSlice("publish")...)
+	spec.Volumes = append(spec.Volumes, c.StringSlice("volume")...)
+	spec.Env = append(spec.Env, c.StringSlice("env")...)
+	spec.ServerArgs = append(spec.ServerArgs, c.StringSlice("server-arg")...)
+	spec.AgentArgs = append(spec.AgentArgs, c.StringSlice("agent-arg")...)
+	spec.NodeLabels = append(spec.NodeLabels, c.StringSlice("node-label")...)
+	spec.NodeTaints = append(spec.NodeTaints, c.StringSlice("node-taint")...)
+	memory, err := memoryLimits(c)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-
-	clusterSpec := &ClusterSpec{
-		AgentArgs:         []string{},
-		APIPort:           *apiPort,
-		AutoRestart:       c.Bool("auto-restart"),
-		ClusterName:       c.String("name"),
-		Env:               env,
-		Image:             image,
-		NodeToPortSpecMap: portmap,
-		PortAutoOffset:    c.Int("port-auto-offset"),
-		ServerArgs:        k3sServerArgs,
-		Verbose:           c.GlobalBool("verbose"),
-		Volumes:           c.StringSlice("volume"),
+	spec.Memory = append(spec.Memory, memory...)
+	spec.CPUs = append(spec.CPUs, c.StringSlice("cpus")...)
+	spec.PidsLimit = append(spec.PidsLimit, c.StringSlice("pids-limit")...)
+	spec.Manifests = append(spec.Manifests, c.StringSlice("manifest")...)
+	spec.HelmCharts = append(spec.HelmCharts, c.StringSlice("helm-chart")...)
+	spec.Disable = append(spec.Disable, c.StringSlice("disable")...)
+	if c.IsSet("ingress") {
+		spec.Ingress = c.String("ingress")
 	}
+	spec.Token = c.String("token")
+	spec.AutoRestart = spec.AutoRestart || c.Bool("auto-restart")
+	spec.DataVolumes = spec.DataVolumes || c.Bool("data-volumes")
+	spec.IPv6 = spec.IPv6 || c.Bool("ipv6")
+	spec.DualStack = spec.DualStack || c.Bool("dual-stack")
+	spec.NoHostEntry = spec.NoHostEntry || c.Bool("no-host-entry")
+	return spec, nil
+}
 
-	// let's go
-	log.Printf("Creating cluster [%s]", c.String("name"))
-
-	// create a k3s server container by passing the arguments
-	// createServer creates a new server container
-	// dockerID is the ID of the container
-	// container.go -> createServer()
-
-	// create the directory where we will put the kubeconfig file by default (when running `k3d get-config`)
-	createClusterDir(c.String("name"))
-	dockerID, err := createServer(clusterSpec)
+// UpgradeCluster replaces the nodes of a cluster one by one with nodes running another k3s image
+func UpgradeCluster(c *cli.Context) error {
+	if !c.IsSet("image") {
+		return errors.New("please specify the new k3s image with --image")
+	}
+	pullPolicy, err := k3d.ParsePullPolicy(c.String("pull"))
 	if err != nil {
-		deleteCluster()
 		return err
 	}
-	ctx := context.Background()
-	// dockerClient provides a client library for interacting with the Docker Engine API
-	// FromEnv is a function that returns a client.Client that is configured from the environment.
-	docker, err := dockerClient.NewClientWithOpts(dockerClient.FromEnv)
+	client, err := k3d.NewDockerClient()
 	if err != nil {
 		return err
 	}
+	ctx, stop := commandContext()
+	defer stop()
+	return client.UpgradeCluster(ctx, &k3d.UpgradeSpec{
+		Name:    c.String("name"),
+		Image:   c.String("image"),
+		Timeout: time.Duration(c.Int("timeout")) * time.Second,
+		Pull:    pullPolicy,
+		Verbose: c.GlobalBool("verbose"),
+	})
+}
 
-	// wait for k3s to be up and running if we want it
-	start := time.Now()
-	timeout := time.Duration(c.Int("wait")) * time.Second //timeout time calc
-
-	// infinite loop until wait is false
-	for c.IsSet("wait") {
-		// if timeout is set and time is up, delete the cluster and return an error
-		if timeout != 0 && !time.Now().After(start.Add(timeout)) {
-			deleteCluster() //literal function
-			return errors.New("cluster creation exceeded specified timeout")
-		}
-		// get the docker logs of the created container
-		// ContainerLogs returns the logs generated by a container in an io.ReadCloser. It's up to the caller to close the stream.
-		// The options parameter allows to specify the options of the logs.
-		out, err := docker.ContainerLogs(ctx, dockerID, container.LogsOptions{
-			ShowStdout: true,
-			ShowStderr: true,
-		})
-		if err != nil {
-			out.Close() //closes the buffer
-			return fmt.Errorf("ERROR: couldn't get docker logs for %s\n%+v", c.String("name"), err)
-		}
-		// represents a buffer for bytes data.
-		// The new keyword used to allocate memory for a new value of a specified type. It
-		// allocates memory for a new bytes.Buffer value and initializes it with its zero value.
-		//The buf variable is declared to hold a pointer to a bytes.Buffer object.
-		buf := new(bytes.Buffer)
-		// ReadFrom reads data from r until EOF or error. The return value n is the number of bytes read. The data is read into buf.
-		nRead, _ := buf.ReadFrom(out)
-		// Close closes the buffer.
-		out.Close()
-		// output is the string representation of the buffer
-		output := buf.String()
-		// the loop continuously checks the Docker logs of the created container for the message "Running kubelet"
-		// if the message is found, the loop is broken
-		if nRead > 0 && strings.Contains(string(output), "Running kubelet") {
-			break
-		}
-		//delay for one second and try again
-		time.Sleep(1 * time.Second)
-	}
-
-	// creating the specified worker nodes
-	if c.Int("workers") > 0 {
-		// k3sWorkerArgs := []string{}
-		// // appending the k3sClusterSecret and k3sToke to env variable
-		// env := []string{k3sClusterSecret, k3sToken}
-		// // passing the environment variables to the workers
-		// env = append(env, c.StringSlice("env")...)
-		log.Printf("Booting %s workers for cluster %s", strconv.Itoa(c.Int("workers")), c.String("name"))
-		for i := 0; i < c.Int("workers"); i++ {
-			workerID, err := createWorker(clusterSpec, i)
-			if err != nil {
-				// if worker creation fails, delete the cluster and exit. Atomic creation
-				deleteCluster() // literal function
-				return err
-			}
-			log.Printf("Created worker with ID %s\n", workerID)
-		}
+// selectClusters returns the names of all clusters if --all is set or the one given by --name
+func selectClusters(ctx context.Context, client *k3d.Client, c *cli.Context) ([]string, error) {
+	if !c.Bool("all") {
+		return []string{c.String("name")}, nil
 	}
-	// after server and worker node creation showing this message
-	log.Printf("SUCCESS: created cluster [%s]", c.String("name"))
-	log.Printf(`You can now use the cluster with:
-
-export KUBECONFIG="$(%s get-kubeconfig --name='%s')"
-kubectl cluster-info`, os.Args[0], c.String("name"))
-
-	return nil
+	clusters, err := client.ListClusters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, cluster := range clusters {
+		names = append(names, cluster.Name)
+	}
+	return names, nil
 }
 
 // DeleteCluster removes the cluster container and its cluster directory
 func DeleteCluster(c *cli.Context) error {
-
-	clusters, err := getClusters(c.Bool("all"), c.String("name"))
+	ctx, stop := commandContext()
+	defer stop()
+	client, err := k3d.NewDockerClient()
+	if err != nil {
+		return err
+	}
+	names, err := selectClusters(ctx, client, c)
 	if err != nil {
 		return err
 	}
 
 	// remove cluster one by one
-	for _, cluster := range clusters {
-		log.Printf("Removing cluster [%s]", cluster.name)
-		// first delete workder node
-		if len(cluster.workers) > 0 {
-			log.Printf("...Removing %d workers\n", len(cluster.workers))
-			// iterate over all the worker node and delete each one
-			for _, worker := range cluster.workers {
-				//removeContainer defined in container.go used to deleteContianer
-				if err := removeContainer(worker.ID); err != nil {
-					log.Println(err)
-					continue
-				}
-			}
-		}
-		//now remove the k3d server
-		log.Println("...Removing server")
-		//directory
-		deleteClusterDir(cluster.name)
-		if err := removeContainer(cluster.server.ID); err != nil {
-			return fmt.Errorf("ERROR: Couldn't remove server for cluster %s\n%+v", cluster.name, err)
-		}
-
-		// deleting the cluster network
-		log.Println("...Removing cluster network")
-		if err := deleteClusterNetwork(cluster.name); err != nil {
-			log.Printf("WARNING: couldn't delete cluster network for cluster %s\n%+v", cluster.name, err)
+	for _, name := range names {
+		if err := client.DeleteClusterWithOptions(ctx, name, k3d.DeleteOptions{KeepVolumes: c.Bool("keep-volumes")}); err != nil {
+			return err
 		}
-
-		log.Printf("SUCCESS: removed cluster [%s]", cluster.name)
 	}
 	return nil
 }
 
 // StopCluster stops a running cluster container (restartable)
 func StopCluster(c *cli.Context) error {
-
-	clusters, err := getClusters(c.Bool("all"), c.String("name"))
+	ctx, stop := commandContext()
+	defer stop()
+	client, err := k3d.NewDockerClient()
 	if err != nil {
 		return err
 	}
-
-	ctx := context.Background()
-	docker, err := dockerClient.NewClientWithOpts(dockerClient.FromEnv)
+	names, err := selectClusters(ctx, client, c)
 	if err != nil {
-		return fmt.Errorf("ERROR: couldn't create docker client\n%+v", err)
+		return err
 	}
 
-	// remove clusters one by one instead of appending all names to the docker command
+	// stop clusters one by one instead of appending all names to the docker command
 	// this allows for more granular error handling and logging
-	for _, cluster := range clusters {
-		log.Printf("Stopping cluster [%s]", cluster.name)
-		// handle workers
-		if len(cluster.workers) > 0 {
-			log.Printf("...Stopping %d workers\n", len(cluster.workers))
-			for _, worker := range cluster.workers {
-				if err := docker.ContainerStop(ctx, worker.ID, container.StopOptions{}); err != nil {
-					log.Println(err)
-					continue
-				}
-			}
-		}
-		log.Println("...Stopping server")
-		//now stop the server
-		if err := docker.ContainerStop(ctx, cluster.server.ID, container.StopOptions{}); err != nil {
-			return fmt.Errorf("ERROR: Couldn't stop server for cluster %s\n%+v", cluster.name, err)
+	for _, name := range names {
+		if err := client.StopCluster(ctx, name); err != nil {
+			return err
 		}
-
-		log.Printf("SUCCESS: Stopped cluster [%s]", cluster.name)
 	}
-
 	return nil
 }
 
 // StartCluster starts a stopped cluster container
 func StartCluster(c *cli.Context) error {
-	clusters, err := getClusters(c.Bool("all"), c.String("name"))
-
+	ctx, stop := commandContext()
+	defer stop()
+	client, err := k3d.NewDockerClient()
 	if err != nil {
 		return err
 	}
-
-	ctx := context.Background()
-	docker, err := dockerClient.NewClientWithOpts(dockerClient.FromEnv)
+	names, err := selectClusters(ctx, client, c)
 	if err != nil {
-		return fmt.Errorf("ERROR: couldn't create docker client\n%+v", err)
+		return err
 	}
 
-	for _, cluster := range clusters {
-		log.Printf("Starting cluster [%s]", cluster.name)
-
-		log.Println("...Starting server")
-		// first start the server container
-		if err := docker.ContainerStart(ctx, cluster.server.ID, container.StartOptions{}); err != nil {
-			return fmt.Errorf("ERROR: Couldn't start server for cluster %s\n%+v", cluster.name, err)
+	for _, name := range names {
+		if err := client.StartCluster(ctx, name); err != nil {
+			return err
 		}
-
-		//if any worker node start them
-		if len(cluster.workers) > 0 {
-			log.Printf("...Starting %d workers\n", len(cluster.workers))
-			for _, worker := range cluster.workers {
-				if err := docker.ContainerStart(ctx, worker.ID, container.StartOptions{}); err != nil {
-					log.Println(err)
-					continue
-				}
-			}
-		}
-		log.Printf("SUCCESS: Started cluster [%s]", cluster.name)
 	}
 	return nil
 }
@@ -380,34 +309,302 @@ func StartCluster(c *cli.Context) error {
 // ListClusters prints a list of created clusters
 func ListClusters(c *cli.Context) error {
 	if c.IsSet("all") {
-		log.Println("INFO: --all is on by default, thus no longer required. This option will be removed in v2.0.0")
+		log.Info("--all is on by default, thus no longer required. This option will be removed in v2.0.0")
+	}
+	client, err := k3d.NewDockerClient()
+	if err != nil {
+		return err
+	}
+	ctx, stop := commandContext()
+	defer stop()
+	clusters, err := client.ListClusters(ctx)
+	if err != nil {
+		return fmt.Errorf("Couldn't list clusters\n%+v", err)
 	}
-	printClusters()
+	printClusters(clusters)
 	return nil
 }
 
+// printClusters prints the names of existing clusters
+func printClusters(clusters []k3d.Cluster) {
+	if len(clusters) == 0 {
+		log.Infof("No clusters found!")
+		return
+	}
+
+	//creating a table output with header name, image, status
+	table := tablewriter.NewWriter(os.Stdout)
+	// align the output table into the center
+	table.SetAlignment(tablewriter.ALIGN_CENTER)
+	table.SetHeader([]string{"NAME", "IMAGE", "STATUS", "WORKERS", "NODE LABELS/TAINTS"})
+	// one line per node in the labels column
+	table.SetAutoWrapText(false)
+
+	for _, cluster := range clusters {
+		workersRunning := 0
+		for _, worker := range cluster.Workers {
+			if worker.State == "running" {
+				workersRunning++
+			}
+		}
+		workerData := fmt.Sprintf("%d/%d", workersRunning, len(cluster.Workers))
+		clusterData := []string{cluster.Name, cluster.Image, cluster.Status, workerData, nodeLabelsAndTaints(cluster)}
+
+		// list all the clusters whether they are running or not or all flag is specified
+		table.Append(clusterData)
+	}
+	table.Render()
+}
+
+// nodeLabelsAndTaints lists the Kubernetes labels and taints of the nodes of a cluster, one line per node,
+// e.g. `worker-0: tier=db, dedicated=gpu:NoSchedule`
+func nodeLabelsAndTaints(cluster k3d.Cluster) string {
+	lines := []string{}
+	for _, node := range append([]k3d.Node{cluster.Server}, cluster.Workers...) {
+		values := append(node.NodeLabels(), node.NodeTaints()...)
+		if len(values) > 0 {
+			shortName := strings.TrimPrefix(node.Name, fmt.Sprintf("k3d-%s-", cluster.Name))
+			lines = append(lines, fmt.Sprintf("%s: %s", shortName, strings.Join(values, ", ")))
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
 // GetKubeConfig grabs the kubeconfig from the running cluster and prints the path to stdout
 func GetKubeConfig(c *cli.Context) error {
-
-	cluster := c.String("name")
-	// create destination kubeconfig file
-	// destPath = getClusterDir/kubeconfig.yaml
-	// clusterDir = $HOME/.config/k3d/<cluster_name>
-	kubeConfigPath, err := getKubeConfig(cluster)
+	ctx, stop := commandContext()
+	defer stop()
+	client, err := k3d.NewDockerClient()
 	if err != nil {
 		return err
 	}
-	// output kubeconfig file path to stdout
-	fmt.Println(kubeConfigPath)
+	names, err := selectClusters(ctx, client, c)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		// create destination kubeconfig file
+		// destPath = getClusterDir/kubeconfig.yaml
+		// clusterDir = $HOME/.config/k3d/<cluster_name>
+		kubeConfigPath, err := client.GetKubeconfig(ctx, name)
+		if err != nil {
+			return err
+		}
+		// output kubeconfig file path to stdout
+		fmt.Println(kubeConfigPath)
+	}
 	return nil
 }
 
 // Bash function
 func Shell(c *cli.Context) error {
-	return subShell(c.String("name"), c.String("shell"), c.String("command"))
+	client, err := k3d.NewDockerClient()
+	if err != nil {
+		return err
+	}
+	ctx, stop := commandContext()
+	defer stop()
+	return subShell(ctx, client, subShellOptions{
+		Cluster: c.String("name"),
+		Shell:   c.String("shell"),
+		Command: c.String("command"),
+		Prompt:  c.String("prompt"),
+		WithRC:  c.Bool("with-rc"),
+	})
 }
 
 // ImportImage saves an image locally and imports it into the k3d containers
 func ImportImage(c *cli.Context) error {
-	return importImage(c.String("name"), c.String("image"))
+	client, err := k3d.NewDockerClient()
+	if err != nil {
+		return err
+	}
+	ctx, stop := commandContext()
+	defer stop()
+	return client.ImportImage(ctx, c.String("name"), c.String("image"))
+}
+
+// Prune removes leftover k3d containers, volumes, networks and cluster directories that don't belong to any cluster
+func Prune(c *cli.Context) error {
+	client, err := k3d.NewDockerClient()
+	if err != nil {
+		return err
+	}
+	ctx, stop := commandContext()
+	defer stop()
+	pruned, err := client.Prune(ctx, k3d.PruneOptions{
+		DryRun:  c.Bool("dry-run"),
+		Volumes: c.Bool("volumes"),
+	})
+	if err != nil {
+		return err
+	}
+	if len(pruned) == 0 {
+		log.Info("Nothing to prune")
+		return nil
+	}
+
+	action := "Removed"
+	if c.Bool("dry-run") {
+		action = "Would remove"
+	}
+	// the pruned resources are the result of the command, so they go to stdout
+	for _, resource := range pruned {
+		fmt.Printf("%s %s [%s] of cluster [%s]\n", action, resource.Kind, resource.Name, resource.Cluster)
+	}
+	return nil
+}
+
+// SaveSnapshot saves a snapshot of a cluster and prints the path of the snapshot archive to stdout
+func SaveSnapshot(c *cli.Context) error {
+	client, err := k3d.NewDockerClient()
+	if err != nil {
+		return err
+	}
+	ctx, stop := commandContext()
+	defer stop()
+	snapshot, err := client.SaveSnapshot(ctx, c.String("name"), c.String("snapshot"), c.String("output"))
+	if err != nil {
+		return err
+	}
+	fmt.Println(snapshot.Path)
+	return nil
+}
+
+// RestoreSnapshot creates a cluster from a snapshot
+func RestoreSnapshot(c *cli.Context) error {
+	if !c.IsSet("from") {
+		return errors.New("please specify the snapshot with --from (a file or <cluster>/<snapshot>)")
+	}
+	client, err := k3d.NewDockerClient()
+	if err != nil {
+		return err
+	}
+	ctx, stop := commandContext()
+	defer stop()
+	cluster, err := client.RestoreSnapshot(ctx, k3d.RestoreOptions{
+		Snapshot: c.String("from"),
+		Name:     c.String("name"),
+		APIPort:  c.String("api-port"),
+		Replace:  c.Bool("replace"),
+		Wait:     c.IsSet("wait"),
+		Timeout:  time.Duration(c.Int("wait")) * time.Second,
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Infof(`You can now use the cluster with:
+
+export KUBECONFIG="$(%s get-kubeconfig --name='%s')"
+kubectl cluster-info`, os.Args[0], cluster.Name)
+	return nil
+}
+
+// ListSnapshots prints the snapshots of a cluster
+func ListSnapshots(c *cli.Context) error {
+	snapshots, err := k3d.ListSnapshots(c.String("name"))
+	if err != nil {
+		return fmt.Errorf("Couldn't list snapshots\n%+v", err)
+	}
+	if len(snapshots) == 0 {
+		log.Infof("No snapshots of cluster [%s] found!", c.String("name"))
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAlignment(tablewriter.ALIGN_CENTER)
+	table.SetHeader([]string{"NAME", "CREATED", "SIZE", "PATH"})
+	for _, snapshot := range snapshots {
+		table.Append([]string{
+			snapshot.Name,
+			snapshot.Created.Format("2006-01-02 15:04:05"),
+			fmt.Sprintf("%.1f MB", float64(snapshot.Size)/(1024*1024)),
+			snapshot.Path,
+		})
+	}
+	table.Render()
+	return nil
+}
+
+// memoryLimitSpecifiers are the node specifiers accepted by --servers-memory and --workers-memory:
+// each flag only limits the nodes of its role (e.g. `2g@workers` belongs to --workers-memory)
+var memoryLimitSpecifiers = map[string]*regexp.Regexp{
+	"servers-memory": regexp.MustCompile(`^(master|(.+-)?server)$`),
+	"workers-memory": regexp.MustCompile(`^(workers|(.+-)?worker-\d+)$`),
+}
+
+// memoryLimits merges --servers-memory and --workers-memory into memory limits with node specifiers,
+// e.g. `--workers-memory 1g` -> `1g@workers`. Node specifiers outside the role of the flag (e.g. all) are rejected.
+func memoryLimits(c *cli.Context) ([]string, error) {
+	limits := []string{}
+	for _, flag := range []struct{ name, defaultNode string }{{"servers-memory", "server"}, {"workers-memory", "workers"}} {
+		for _, limit := range c.StringSlice(flag.name) {
+			size, specifiers, found := strings.Cut(limit, "@")
+			if !found {
+				limits = append(limits, limit+"@"+flag.defaultNode)
+				continue
+			}
+			for _, specifier := range strings.Split(specifiers, "@") {
+				if !memoryLimitSpecifiers[flag.name].MatchString(specifier) {
+					return nil, fmt.Errorf("Invalid node-specifier [%s] in --%s [%s], it only limits the %s", specifier, flag.name, limit, strings.TrimSuffix(flag.name, "-memory"))
+				}
+			}
+			limits = append(limits, size+"@"+specifiers)
+		}
+	}
+	return limits, nil
+}
+
+// ExportCluster prints the definition of a cluster as YAML to stdout, which can be used with `create --from`
+func ExportCluster(c *cli.Context) error {
+	client, err := k3d.NewDockerClient()
+	if err != nil {
+		return err
+	}
+	ctx, stop := commandContext()
+	defer stop()
+	definition, err := client.ExportCluster(ctx, c.String("name"))
+	if err != nil {
+		return err
+	}
+	return k3d.WriteClusterDefinition(os.Stdout, definition)
+}
+
+// GetToken prints the token of a cluster
+func GetToken(c *cli.Context) error {
+	client, err := k3d.NewDockerClient()
+	if err != nil {
+		return err
+	}
+	ctx, stop := commandContext()
+	defer stop()
+	token, err := client.GetClusterToken(ctx, c.String("name"))
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
+
+// Logs prints the logs of the nodes of a cluster, prefixed with the node names (colored on a terminal)
+func Logs(c *cli.Context) error {
+	client, err := k3d.NewDockerClient()
+	if err != nil {
+		return err
+	}
+	ctx, stop := commandContext()
+	defer stop()
+	_, isTerminal := term.GetFdInfo(os.Stdout)
+	return client.ClusterLogs(ctx, k3d.LogsOptions{
+		Name:       c.String("name"),
+		Nodes:      c.StringSlice("node"),
+		Follow:     c.Bool("follow"),
+		Since:      c.String("since"),
+		Tail:       c.String("tail"),
+		Color:      isTerminal,
+		Timestamps: c.Bool("timestamps"),
+	}, os.Stdout)
 }
@@ -0,0 +1,45 @@
+package run
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+// memoryContext returns a context with the given --servers-memory and --workers-memory flags
+func memoryContext(serversMemory, workersMemory []string) *cli.Context {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	servers := cli.StringSlice(serversMemory)
+	workers := cli.StringSlice(workersMemory)
+	set.Var(&servers, "servers-memory", "")
+	set.Var(&workers, "workers-memory", "")
+	return cli.NewContext(nil, set, nil)
+}
+
+func TestMemoryLimits(t *testing.T) {
+	limits, err := memoryLimits(memoryContext([]string{"2g"}, []string{"1g", "3g@worker-1", "4g@k3d-test-worker-2"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"2g@server", "1g@workers", "3g@worker-1", "4g@k3d-test-worker-2"}
+	if !reflect.DeepEqual(limits, want) {
+		t.Errorf("limits = %v, want %v", limits, want)
+	}
+}
+
+func TestMemoryLimitsRejectSpecifiersOfOtherRoles(t *testing.T) {
+	for _, test := range []struct{ serversMemory, workersMemory []string }{
+		{serversMemory: []string{"2g@workers"}},
+		{serversMemory: []string{"2g@all"}},
+		{serversMemory: []string{"2g@worker-0"}},
+		{workersMemory: []string{"1g@server"}},
+		{workersMemory: []string{"1g@all"}},
+		{workersMemory: []string{"1g@worker-0@server"}},
+	} {
+		if limits, err := memoryLimits(memoryContext(test.serversMemory, test.workersMemory)); err == nil {
+			t.Errorf("%+v was accepted: %v", test, limits)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"k3d-go/pkg/k3d"
+
+	"github.com/urfave/cli"
+)
+
+// envFormats print the commands setting an environment variable in a shell, for use with eval
+var envFormats = map[string]func(key, value string) string{
+	"bash": posixEnv,
+	"zsh":  posixEnv,
+	"sh":   posixEnv,
+	"fish": func(key, value string) string {
+		return fmt.Sprintf("set -gx %s %s;", key, fishQuote(value))
+	},
+	"powershell": func(key, value string) string {
+		return fmt.Sprintf("$Env:%s = '%s'", key, strings.ReplaceAll(value, "'", "''"))
+	},
+}
+
+// posixEnv exports an environment variable in POSIX shells (sh, bash, zsh)
+func posixEnv(key, value string) string {
+	return fmt.Sprintf("export %s=%s", key, posixQuote(value))
+}
+
+// posixQuote quotes a value for POSIX shells: single quotes, with embedded single quotes closed, escaped and reopened
+func posixQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// fishQuote quotes a value for fish: single quotes, in which fish unescapes backslashes and single quotes
+func fishQuote(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value) + "'"
+}
+
+// Env prints the commands that point a shell to the kubeconfig of a cluster
+// command: eval "$(k3d env --name X [--shell bash|zsh|sh|fish|powershell])"
+func Env(c *cli.Context) error {
+	shell := c.String("shell")
+	if shell == "auto" {
+		shell = path.Base(os.Getenv("SHELL"))
+		// e.g. $SHELL isn't set on Windows or the shell is unknown
+		if _, ok := envFormats[shell]; !ok {
+			shell = "sh"
+		}
+	}
+	format, ok := envFormats[shell]
+	if !ok {
+		return fmt.Errorf("selected shell [%s] is not supported, must be one of [auto, bash, zsh, sh, fish, powershell]", shell)
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+	client, err := k3d.NewDockerClient()
+	if err != nil {
+		return err
+	}
+	name := c.String("name")
+	kubeConfigPath, err := client.GetKubeconfig(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(format("KUBECONFIG", kubeConfigPath))
+	if shell == "powershell" {
+		fmt.Printf("# Run this command to configure your shell:\n# & %s env --name '%s' --shell powershell | Invoke-Expression\n", os.Args[0], name)
+	} else {
+		fmt.Printf("# Run this command to configure your shell:\n# eval \"$(%s env --name '%s' --shell %s)\"\n", os.Args[0], name, shell)
+	}
+	return nil
+}
+
+// Kubectl runs the kubectl of the host with the kubeconfig of a cluster and exits with its exit code
+// command: k3d kubectl --name X -- get pods
+func Kubectl(c *cli.Context) error {
+	kubectlPath, err := exec.LookPath("kubectl")
+	if err != nil {
+		return fmt.Errorf("couldn't find kubectl, make sure it's installed and in your PATH\n%+v", err)
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+	client, err := k3d.NewDockerClient()
+	if err != nil {
+		return err
+	}
+	kubeConfigPath, err := client.GetKubeconfig(ctx, c.String("name"))
+	if err != nil {
+		return err
+	}
+
+	// kubectl gets the signals of the terminal (e.g. Ctrl-C) itself, k3d just waits for it to exit
+	cmd := exec.Command(kubectlPath, c.Args()...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("KUBECONFIG=%s", kubeConfigPath))
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return cli.NewExitError("", exitErr.ExitCode())
+		}
+		return fmt.Errorf("couldn't run kubectl\n%+v", err)
+	}
+	return nil
+}
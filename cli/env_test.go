@@ -0,0 +1,55 @@
+package run
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestEnvFormats(t *testing.T) {
+	tests := []struct {
+		shell string
+		value string
+		want  string
+	}{
+		{"bash", "/home/user/.config/k3d/test/kubeconfig.yaml", `export KUBECONFIG='/home/user/.config/k3d/test/kubeconfig.yaml'`},
+		{"bash", "/home/my user/kubeconfig.yaml", `export KUBECONFIG='/home/my user/kubeconfig.yaml'`},
+		{"bash", "/home/o'neil/kubeconfig.yaml", `export KUBECONFIG='/home/o'\''neil/kubeconfig.yaml'`},
+		{"bash", `/home/$USER/"k3d"/kubeconfig.yaml`, `export KUBECONFIG='/home/$USER/"k3d"/kubeconfig.yaml'`},
+		{"zsh", "/home/o'neil/$HOME/kubeconfig.yaml", `export KUBECONFIG='/home/o'\''neil/$HOME/kubeconfig.yaml'`},
+		{"sh", `/home/my user/\k3d`, `export KUBECONFIG='/home/my user/\k3d'`},
+		{"fish", "/home/my user/kubeconfig.yaml", `set -gx KUBECONFIG '/home/my user/kubeconfig.yaml';`},
+		{"fish", "/home/o'neil/$HOME/kubeconfig.yaml", `set -gx KUBECONFIG '/home/o\'neil/$HOME/kubeconfig.yaml';`},
+		{"fish", `C:\Users\k3d\`, `set -gx KUBECONFIG 'C:\\Users\\k3d\\';`},
+		{"powershell", `C:\Users\my user\kubeconfig.yaml`, `$Env:KUBECONFIG = 'C:\Users\my user\kubeconfig.yaml'`},
+		{"powershell", `C:\Users\o'neil\$HOME\"k3d"`, `$Env:KUBECONFIG = 'C:\Users\o''neil\$HOME\"k3d"'`},
+	}
+	for _, test := range tests {
+		if got := envFormats[test.shell]("KUBECONFIG", test.value); got != test.want {
+			t.Errorf("envFormats[%q](%q) = %s, want %s", test.shell, test.value, got, test.want)
+		}
+	}
+}
+
+func TestEnvFormatsEvaluatedByShell(t *testing.T) {
+	values := []string{
+		"/home/my user/kubeconfig.yaml",
+		"/home/o'neil/kubeconfig.yaml",
+		`/home/$USER/"k3d"/$(echo x)/\n/kubeconfig.yaml`,
+		"'",
+	}
+	for _, shell := range []string{"bash", "zsh", "sh", "fish"} {
+		shellPath, err := exec.LookPath(shell)
+		if err != nil {
+			continue
+		}
+		for _, value := range values {
+			script := envFormats[shell]("KUBECONFIG", value) + "\nprintf '%s' \"$KUBECONFIG\""
+			out, err := exec.Command(shellPath, "-c", script).Output()
+			if err != nil {
+				t.Errorf("%s -c %q failed: %v", shell, script, err)
+			} else if got := string(out); got != value {
+				t.Errorf("%s evaluated KUBECONFIG to %q, want %q", shell, got, value)
+			}
+		}
+	}
+}
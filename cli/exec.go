@@ -0,0 +1,98 @@
+package run
+
+import (
+	"os"
+	"os/signal"
+
+	"k3d-go/pkg/k3d"
+
+	"github.com/moby/term"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// Exec runs a command in the nodes of a cluster and exits with its exit code
+// command: k3d exec --name X --node server [-i] [-t] -- <command>
+func Exec(c *cli.Context) error {
+	client, err := k3d.NewDockerClient()
+	if err != nil {
+		return err
+	}
+	ctx, stop := commandContext()
+	defer stop()
+
+	_, stdoutIsTerminal := term.GetFdInfo(os.Stdout)
+	options := k3d.ExecOptions{
+		Name:   c.String("name"),
+		Nodes:  c.StringSlice("node"),
+		Cmd:    c.Args(),
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		Tty:    c.Bool("tty"),
+		Color:  stdoutIsTerminal,
+	}
+	if c.Bool("interactive") {
+		options.Stdin = os.Stdin
+	}
+
+	if options.Tty {
+		fd, isTerminal := term.GetFdInfo(os.Stdin)
+		if !isTerminal {
+			return cli.NewExitError("the input device is not a TTY, use --tty only in a terminal", 1)
+		}
+		// the terminal is put into raw mode, so that keys (e.g. Ctrl-C) are passed on to the command
+		state, err := term.SetRawTerminal(fd)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := term.RestoreTerminal(fd, state); err != nil {
+				log.Warnf("couldn't restore the terminal: %+v", err)
+			}
+		}()
+		if size, err := term.GetWinsize(fd); err == nil {
+			options.ConsoleSize = &[2]uint{uint(size.Height), uint(size.Width)}
+		}
+		resize := make(chan [2]uint, 1)
+		options.Resize = resize
+		stopResize := notifyResize(fd, resize)
+		defer stopResize()
+	}
+
+	exitCode, err := client.Exec(ctx, options)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return cli.NewExitError("", exitCode)
+	}
+	return nil
+}
+
+// notifyResize sends the new size of the terminal whenever it changes and returns a function that stops the notifications
+func notifyResize(fd uintptr, resize chan<- [2]uint) func() {
+	signals := make(chan os.Signal, 1)
+	if len(resizeSignals) > 0 {
+		signal.Notify(signals, resizeSignals...)
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-signals:
+				if size, err := term.GetWinsize(fd); err == nil {
+					select {
+					case resize <- [2]uint{uint(size.Height), uint(size.Width)}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,41 @@
+package run
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// SetupLogging configures the logger from the global flags --log-level, --log-format, --quiet and --verbose.
+// Logs always go to stderr, so that stdout only contains command results (e.g. the kubeconfig path).
+func SetupLogging(c *cli.Context) error {
+	log.SetOutput(os.Stderr)
+
+	level := log.InfoLevel
+	if c.GlobalIsSet("log-level") {
+		var err error
+		level, err = log.ParseLevel(c.GlobalString("log-level"))
+		if err != nil {
+			return fmt.Errorf("invalid log level [%s], must be one of [trace, debug, info, warn, error]", c.GlobalString("log-level"))
+		}
+	} else if c.GlobalBool("verbose") {
+		level = log.DebugLevel
+	}
+	// --quiet only keeps errors, regardless of the log level
+	if c.GlobalBool("quiet") {
+		level = log.ErrorLevel
+	}
+	log.SetLevel(level)
+
+	switch c.GlobalString("log-format") {
+	case "text":
+		log.SetFormatter(&log.TextFormatter{})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("invalid log format [%s], must be one of [text, json]", c.GlobalString("log-format"))
+	}
+	return nil
+}
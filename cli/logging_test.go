@@ -0,0 +1,73 @@
+package run
+
+import (
+	"flag"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// loggingContext returns a context with the global logging flags, parsed from args
+func loggingContext(t *testing.T, args ...string) *cli.Context {
+	t.Helper()
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.Bool("verbose", false, "")
+	set.String("log-level", "info", "")
+	set.String("log-format", "text", "")
+	set.Bool("quiet", false, "")
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(nil, set, nil)
+}
+
+func TestSetupLogging(t *testing.T) {
+	logger := log.StandardLogger()
+	level, formatter, out := logger.GetLevel(), logger.Formatter, logger.Out
+	t.Cleanup(func() {
+		logger.SetLevel(level)
+		logger.SetFormatter(formatter)
+		logger.SetOutput(out)
+	})
+
+	tests := []struct {
+		args   []string
+		level  log.Level
+		isJSON bool
+	}{
+		{args: nil, level: log.InfoLevel},
+		{args: []string{"--verbose"}, level: log.DebugLevel},
+		{args: []string{"--log-level", "trace"}, level: log.TraceLevel},
+		{args: []string{"--log-level", "WARN"}, level: log.WarnLevel},
+		// an explicit log level wins over --verbose, --quiet wins over both
+		{args: []string{"--verbose", "--log-level", "error"}, level: log.ErrorLevel},
+		{args: []string{"--verbose", "--quiet"}, level: log.ErrorLevel},
+		{args: []string{"--log-level", "trace", "--quiet"}, level: log.ErrorLevel},
+		{args: []string{"--log-format", "json"}, level: log.InfoLevel, isJSON: true},
+		{args: []string{"--log-format", "text", "--log-level", "debug"}, level: log.DebugLevel},
+	}
+	for _, test := range tests {
+		if err := SetupLogging(loggingContext(t, test.args...)); err != nil {
+			t.Errorf("SetupLogging(%q) returned %v", test.args, err)
+			continue
+		}
+		if got := log.GetLevel(); got != test.level {
+			t.Errorf("SetupLogging(%q) set level %s, want %s", test.args, got, test.level)
+		}
+		if _, isJSON := logger.Formatter.(*log.JSONFormatter); isJSON != test.isJSON {
+			t.Errorf("SetupLogging(%q) set formatter %T", test.args, logger.Formatter)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--log-level", "verbose"},
+		{"--log-level", ""},
+		{"--log-format", "yaml"},
+		{"--log-format", ""},
+	} {
+		if err := SetupLogging(loggingContext(t, args...)); err == nil {
+			t.Errorf("SetupLogging(%q) succeeded, want an error", args)
+		}
+	}
+}
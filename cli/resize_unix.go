@@ -0,0 +1,11 @@
+//go:build !windows
+
+package run
+
+import (
+	"os"
+	"syscall"
+)
+
+// resizeSignals are sent when the size of the terminal changes
+var resizeSignals = []os.Signal{syscall.SIGWINCH}
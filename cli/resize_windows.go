@@ -0,0 +1,8 @@
+//go:build windows
+
+package run
+
+import "os"
+
+// resizeSignals are sent when the size of the terminal changes (there's no such signal on Windows)
+var resizeSignals = []os.Signal{}
@@ -1,92 +1,133 @@
 package run
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path"
+	"strings"
+	"text/template"
+
+	"k3d-go/pkg/k3d"
+
+	"github.com/moby/term"
+	log "github.com/sirupsen/logrus"
 )
+
+// DefaultShellPrompt is the default template of the prefix that's added to the prompt of a subshell
+const DefaultShellPrompt = "[{{.Cluster}}] "
+
+// subShellEnv is set in subshells to the name of their cluster, to detect nested subshells
+const subShellEnv = "__K3D_CLUSTER__"
+
 type shell struct {
-	Name    string
-	Options []string
-	Prompt  string
-	Env     map[string]string
+	Name string
+	// Init prepares the shell to prefix its prompt with prompt: it writes init files into dir
+	// and returns the command line flags and environment variables that make the shell use them.
+	// With withRC, the rc files of the user are loaded as usual, before the prompt is set.
+	Init func(dir, prompt string, withRC bool) (options []string, env []string, err error)
 }
 
 var shells = map[string]shell{
-	"bash" : {
+	"bash": {
 		Name: "bash",
-		Options: []string{
-			"--noprofile", // don't load .profile/.bash_profile
-			"--norc",      // don't load .bashrc
-		},
-		Prompt: "PS1",
+		Init: initBash,
 	},
-	"zsh" : {
+	"zsh": {
 		Name: "zsh",
-		Options: []string{
-			"--no-rcs", // don't load .zshrc
-		},
-		Prompt: "PROMPT",
+		Init: initZsh,
+	},
+	"fish": {
+		Name: "fish",
+		Init: initFish,
 	},
+	"sh": {
+		Name: "sh",
+		Init: initSh,
+	},
+}
+
+// subShellOptions describe a subshell started by subShell
+type subShellOptions struct {
+	Cluster string
+	// Shell is one of shells or auto (the shell in $SHELL)
+	Shell string
+	// Command is run instead of an interactive shell, if set
+	Command string
+	// Prompt is a template (text/template) of the prefix of the prompt, e.g. "[{{.Cluster}}] "
+	Prompt string
+	// WithRC loads the rc files of the user (e.g. ~/.bashrc)
+	WithRC bool
 }
 
-func subShell(cluster string, shell string, command string) error {
+// promptData are the values available in prompt templates
+type promptData struct {
+	Cluster string
+}
 
+func subShell(ctx context.Context, client *k3d.Client, options subShellOptions) error {
+	shellName := options.Shell
 	// check if the selected shell is supported
-	if shell == "auto" {
+	if shellName == "auto" {
 		// get the shell from the SHELL environment variable
-		// if the SHELL environment variable is not set, use the default shell
 		// Base returns the last element of path.
 		// Trailing path separators are removed before extracting the last element.
-		shell = path.Base(os.Getenv("SHELL"))
-	}
-
-	supported := false
-	// check if the selected shell is supported	
-	for supportedShell := range shells {
-		if supportedShell == shell {
-			supported = true
+		// any other shell (or none, e.g. on Windows) falls back to the POSIX shell
+		shellName = "sh"
+		if userShell := os.Getenv("SHELL"); userShell != "" {
+			if _, ok := shells[path.Base(userShell)]; ok {
+				shellName = path.Base(userShell)
+			} else {
+				log.Warnf("Shell [%s] is not supported, using sh", userShell)
+			}
 		}
 	}
-	if !supported {
-		return fmt.Errorf("ERROR: selected shell [%s] is not supported", shell)
+	shell, ok := shells[shellName]
+	if !ok {
+		return fmt.Errorf("selected shell [%s] is not supported, must be one of [auto, bash, zsh, fish, sh]", shellName)
 	}
 
-	kubeConfigPath, err := getKubeConfig(cluster)
+	prompt, err := renderPrompt(options.Prompt, options.Cluster)
 	if err != nil {
 		return err
 	}
 
-	// ExpandEnv replaces ${var} or $var in the string according to the values of the current environment variables. References to undefined variables are replaced by the empty string.
-	//this code prevents the execution of further actions that would start a new subshell of a k3d cluster if the current shell session is already in a subshell of a k3d cluster, ensuring that the user does not unintentionally create nested subshells.
-	subShell := os.ExpandEnv("$__K3D_CLUSTER__")
-	if len(subShell) > 0 {
-		return fmt.Errorf("[ERROR]: Already in subshell of cluster %s", subShell)
+	if err := checkSubShell(options.Cluster, confirmSwitch); err != nil {
+		return err
 	}
 
-	// find out the bash path
-	// LookPath searches for an executable named file in the directories named by the $PATH environment variable. LookPath also uses $PATHEXT environment variable to match a suitable candidate.
-	// If a match is found, LookPath returns the absolute pathname of the executable file.
-	// If no match is found, LookPath returns the string "", and err is set to os.ErrNotExist.
-	shellPath, err := exec.LookPath(shell)
+	kubeConfigPath, err := client.GetKubeconfig(ctx, options.Cluster)
 	if err != nil {
 		return err
 	}
 
-	//"/bin/bash": Path to the Bash executable. Specifies that the command to be executed is Bash.
-	// "--noprofile": an argument passed to Bash. It instructs Bash not to read the system-wide profile file for login shells. Useful when you want to start Bash quickly without loading any additional configurations.
-	// "--norc": It instructs Bash not to read the user's ~/.bashrc file. Similar to --noprofile, it helps start Bash more quickly without loading additional configurations.
-	// Command returns the Cmd struct to execute the named program with the given arguments. It sets only the Path and Args in the returned structure.
+	// find out the shell path
+	// LookPath searches for an executable named file in the directories named by the $PATH environment variable.
+	shellPath, err := exec.LookPath(shell.Name)
+	if err != nil {
+		return err
+	}
 
-	// set shell specific options (command line flags)
-	// if shell == "bash" then shellOptions = --noprofile --norc
-	shellOptions := shells[shell].Options
+	// the init files only live as long as the shell
+	initDir, err := os.MkdirTemp("", "k3d-shell-")
+	if err != nil {
+		return fmt.Errorf("couldn't create directory for the init files of the shell\n%+v", err)
+	}
+	defer os.RemoveAll(initDir)
+
+	// set shell specific options (command line flags) and environment variables
+	shellOptions, shellEnv, err := shell.Init(initDir, prompt, options.WithRC)
+	if err != nil {
+		return fmt.Errorf("couldn't prepare the shell %s\n%+v", shell.Name, err)
+	}
 	cmd := exec.Command(shellPath, shellOptions...)
 
-	if len(command) > 0 {
-		//  k3d bash -c 'kubectl cluster-info'
-		cmd.Args = append(cmd.Args, "-c", command)
+	if len(options.Command) > 0 {
+		//  k3d shell -c 'kubectl cluster-info'
+		cmd.Args = append(cmd.Args, "-c", options.Command)
 	}
 
 	// Set up stdio
@@ -94,24 +135,139 @@ func subShell(cluster string, shell string, command string) error {
 	cmd.Stdin = os.Stdin
 	cmd.Stderr = os.Stderr
 
-	// Set up Prompt
-	//Getenv retrieves the value of the environment variable named by the key.
-	// In Bash, PS1 is an environment variable that defines the format of the primary prompt displayed to the user. Includes information such as the username, hostname, current directory, and other relevant details.
-	// "PS1=\[%s}%s": Format of the string. Sets PS1 to a custom value. The \[ and \] are escape sequences in Bash that denote non-printing characters, which is often used for colorizing the prompt.
-	// The resulting prompt will display the cluster name alongside the existing prompt string.
-	// see more: https://linuxsimply.com/bash-scripting-tutorial/variables/types/ps1/
-	setPrompt := fmt.Sprintf("%s=[%s} %s", shells[shell].Prompt, cluster, os.Getenv(shells[shell].Prompt))
-
-	// Set up KUBECONFIG
-	setKube := fmt.Sprintf("KUBECONFIG=%s", kubeConfigPath)
-	// creating an environment variable __K3D_CLUSTER__=cluster
-	subShell = fmt.Sprintf("__K3D_CLUSTER__=%s", cluster)
+	// Set up KUBECONFIG and mark the shell as subshell of the cluster
 	// Environ returns a copy of strings representing the environment, in the form "key=value".
-	// adding the environment variables to the newEnv
-	newEnv := append(os.Environ(), setPrompt, setKube, subShell)
-	// Set up environment of the cmd
-	cmd.Env = newEnv
+	cmd.Env = append(os.Environ(), shellEnv...)
+	cmd.Env = append(cmd.Env, fmt.Sprintf("KUBECONFIG=%s", kubeConfigPath), fmt.Sprintf("%s=%s", subShellEnv, options.Cluster))
 
 	return cmd.Run()
 }
 
+// renderPrompt renders the prompt template for a cluster
+func renderPrompt(prompt, cluster string) (string, error) {
+	tmpl, err := template.New("prompt").Parse(prompt)
+	if err != nil {
+		return "", fmt.Errorf("invalid prompt template [%s]\n%+v", prompt, err)
+	}
+	rendered := &strings.Builder{}
+	if err := tmpl.Execute(rendered, promptData{Cluster: cluster}); err != nil {
+		return "", fmt.Errorf("invalid prompt template [%s]\n%+v", prompt, err)
+	}
+	return rendered.String(), nil
+}
+
+// checkSubShell checks for a subshell of a cluster started in a subshell. A subshell of the same cluster is pointless,
+// switching to another cluster has to be confirmed, since the subshell of the other cluster is left only by exiting both subshells.
+func checkSubShell(cluster string, confirm func(current, cluster string) bool) error {
+	current := os.Getenv(subShellEnv)
+	if current == "" {
+		return nil
+	}
+	if current == cluster {
+		return fmt.Errorf("Already in subshell of cluster %s", current)
+	}
+	if !confirm(current, cluster) {
+		return fmt.Errorf("Already in subshell of cluster %s, exit it first to start a subshell of cluster %s", current, cluster)
+	}
+	return nil
+}
+
+// confirmSwitch asks the user whether to start a subshell of another cluster in the current subshell.
+// Without a terminal, nobody can be asked.
+func confirmSwitch(current, cluster string) bool {
+	if _, isTerminal := term.GetFdInfo(os.Stdin); !isTerminal {
+		return false
+	}
+	return askSwitch(os.Stdin, os.Stderr, current, cluster)
+}
+
+// askSwitch asks whether to start a subshell of another cluster and reads the answer, only yes switches
+func askSwitch(in io.Reader, out io.Writer, current, cluster string) bool {
+	fmt.Fprintf(out, "Already in subshell of cluster %s. Start a subshell of cluster %s in it? [y/N] ", current, cluster)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// writeInitFile writes an init file of a shell, one command per line
+func writeInitFile(dir, name string, lines ...string) (string, error) {
+	filePath := path.Join(dir, name)
+	if err := os.WriteFile(filePath, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return filePath, nil
+}
+
+// initBash replaces ~/.bashrc with an rc file, which sources ~/.bashrc with withRC and prefixes PS1
+func initBash(dir, prompt string, withRC bool) ([]string, []string, error) {
+	lines := []string{}
+	if withRC {
+		lines = append(lines, `if [ -f ~/.bashrc ]; then . ~/.bashrc; fi`)
+	}
+	lines = append(lines, fmt.Sprintf(`PS1=%s"$PS1"`, posixQuote(prompt)))
+	rcFile, err := writeInitFile(dir, "bashrc", lines...)
+	if err != nil {
+		return nil, nil, err
+	}
+	// don't load .profile/.bash_profile, but the rc file instead of .bashrc
+	return []string{"--noprofile", "--rcfile", rcFile}, nil, nil
+}
+
+// initZsh points ZDOTDIR to the init directory, whose .zshenv and .zshrc source the ones of the user with withRC.
+// The .zshrc restores the ZDOTDIR of the user and prefixes PROMPT.
+func initZsh(dir, prompt string, withRC bool) ([]string, []string, error) {
+	userDir := `"${__K3D_ZDOTDIR__:-$HOME}"`
+	if withRC {
+		if _, err := writeInitFile(dir, ".zshenv", fmt.Sprintf(`if [ -f %s/.zshenv ]; then . %s/.zshenv; fi`, userDir, userDir)); err != nil {
+			return nil, nil, err
+		}
+	}
+	lines := []string{
+		`if [ -n "$__K3D_ZDOTDIR__" ]; then ZDOTDIR="$__K3D_ZDOTDIR__"; else unset ZDOTDIR; fi`,
+		`unset __K3D_ZDOTDIR__`,
+	}
+	if withRC {
+		lines = append(lines, `if [ -f "${ZDOTDIR:-$HOME}/.zshrc" ]; then . "${ZDOTDIR:-$HOME}/.zshrc"; fi`)
+	}
+	lines = append(lines, fmt.Sprintf(`PROMPT=%s"$PROMPT"`, posixQuote(prompt)))
+	if _, err := writeInitFile(dir, ".zshrc", lines...); err != nil {
+		return nil, nil, err
+	}
+	options := []string{}
+	if !withRC {
+		// don't load /etc/zprofile, /etc/zshrc, ... (/etc/zshenv is always loaded)
+		options = append(options, "--no-globalrcs")
+	}
+	return options, []string{"ZDOTDIR=" + dir, "__K3D_ZDOTDIR__=" + os.Getenv("ZDOTDIR")}, nil
+}
+
+// initFish wraps the prompt function of fish. The init command runs after the configuration of the user (withRC).
+func initFish(dir, prompt string, withRC bool) ([]string, []string, error) {
+	initCommand := strings.Join([]string{
+		`functions -q fish_prompt; and functions -c fish_prompt __k3d_fish_prompt`,
+		fmt.Sprintf(`function fish_prompt; printf '%%s' %s; functions -q __k3d_fish_prompt; and __k3d_fish_prompt; end`, fishQuote(prompt)),
+	}, "; ")
+	options := []string{}
+	if !withRC {
+		// don't load config.fish and conf.d
+		options = append(options, "--no-config")
+	}
+	return append(options, "--init-command", initCommand), nil, nil
+}
+
+// initSh points ENV (read by interactive POSIX shells) to an rc file, which sources the ENV of the user with withRC and prefixes PS1
+func initSh(dir, prompt string, withRC bool) ([]string, []string, error) {
+	lines := []string{}
+	if withRC {
+		lines = append(lines, `if [ -n "$__K3D_ENV__" ] && [ -f "$__K3D_ENV__" ]; then . "$__K3D_ENV__"; fi`)
+	}
+	lines = append(lines, `unset __K3D_ENV__`, fmt.Sprintf(`PS1=%s"$PS1"`, posixQuote(prompt)))
+	rcFile, err := writeInitFile(dir, "shrc", lines...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, []string{"ENV=" + rcFile, "__K3D_ENV__=" + os.Getenv("ENV")}, nil
+}
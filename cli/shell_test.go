@@ -0,0 +1,214 @@
+package run
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenderPrompt(t *testing.T) {
+	tests := []struct {
+		prompt  string
+		want    string
+		wantErr bool
+	}{
+		{prompt: DefaultShellPrompt, want: "[test] "},
+		{prompt: "k3d:{{.Cluster}} $ ", want: "k3d:test $ "},
+		{prompt: "no cluster ", want: "no cluster "},
+		{prompt: "{{.Cluster", wantErr: true},
+		{prompt: "{{.Unknown}}", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := renderPrompt(test.prompt, "test")
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("renderPrompt(%q) = %q, %v, want %q (error: %v)", test.prompt, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+// readInitFile returns the content of an init file written by the init function of a shell
+func readInitFile(t *testing.T, filePath string) string {
+	t.Helper()
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestInitBash(t *testing.T) {
+	for _, withRC := range []bool{false, true} {
+		dir := t.TempDir()
+		options, env, err := initBash(dir, "[test] ", withRC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rcFile := path.Join(dir, "bashrc")
+		if want := []string{"--noprofile", "--rcfile", rcFile}; !reflect.DeepEqual(options, want) || env != nil {
+			t.Errorf("initBash(withRC=%v) = %q, %q, want %q, no env", withRC, options, env, want)
+		}
+		if content := readInitFile(t, rcFile); strings.Contains(content, "~/.bashrc") != withRC {
+			t.Errorf("rc file with withRC=%v:\n%s", withRC, content)
+		}
+	}
+}
+
+func TestInitZsh(t *testing.T) {
+	t.Setenv("ZDOTDIR", "/home/user/zsh")
+	dir := t.TempDir()
+	options, env, err := initZsh(dir, "[test] ", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"--no-globalrcs"}; !reflect.DeepEqual(options, want) {
+		t.Errorf("options = %q, want %q", options, want)
+	}
+	if want := []string{"ZDOTDIR=" + dir, "__K3D_ZDOTDIR__=/home/user/zsh"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("env = %q, want %q", env, want)
+	}
+	if content := readInitFile(t, path.Join(dir, ".zshrc")); !strings.Contains(content, `PROMPT='[test] '"$PROMPT"`) || strings.Contains(content, ".zshrc") {
+		t.Errorf(".zshrc without rc files of the user:\n%s", content)
+	}
+	if _, err := os.Stat(path.Join(dir, ".zshenv")); err == nil {
+		t.Error(".zshenv was written without withRC")
+	}
+
+	dir = t.TempDir()
+	options, _, err = initZsh(dir, "[test] ", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(options) != 0 {
+		t.Errorf("options = %q, want none", options)
+	}
+	if content := readInitFile(t, path.Join(dir, ".zshenv")); !strings.Contains(content, "/.zshenv") {
+		t.Errorf(".zshenv doesn't source the one of the user:\n%s", content)
+	}
+	if content := readInitFile(t, path.Join(dir, ".zshrc")); !strings.Contains(content, `"${ZDOTDIR:-$HOME}/.zshrc"`) {
+		t.Errorf(".zshrc doesn't source the one of the user:\n%s", content)
+	}
+}
+
+func TestInitFish(t *testing.T) {
+	tests := []struct {
+		withRC bool
+		want   []string
+	}{
+		{withRC: false, want: []string{"--no-config", "--init-command"}},
+		{withRC: true, want: []string{"--init-command"}},
+	}
+	for _, test := range tests {
+		options, env, err := initFish(t.TempDir(), `it's C:\ `, test.withRC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(options) != len(test.want)+1 || !reflect.DeepEqual(options[:len(test.want)], test.want) || env != nil {
+			t.Fatalf("initFish(withRC=%v) = %q, %q, want %q and the init command", test.withRC, options, env, test.want)
+		}
+		if initCommand := options[len(options)-1]; !strings.Contains(initCommand, `printf '%s' 'it\'s C:\\ '`) {
+			t.Errorf("init command doesn't print the quoted prompt: %s", initCommand)
+		}
+	}
+}
+
+func TestInitSh(t *testing.T) {
+	t.Setenv("ENV", "/home/user/.shrc")
+	dir := t.TempDir()
+	options, env, err := initSh(dir, "[test] ", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rcFile := path.Join(dir, "shrc")
+	if want := []string{"ENV=" + rcFile, "__K3D_ENV__=/home/user/.shrc"}; options != nil || !reflect.DeepEqual(env, want) {
+		t.Errorf("initSh() = %q, %q, want no options, %q", options, env, want)
+	}
+	if content := readInitFile(t, rcFile); !strings.Contains(content, `. "$__K3D_ENV__"`) {
+		t.Errorf("rc file doesn't source the ENV of the user:\n%s", content)
+	}
+}
+
+func TestInitFilesSetPrompt(t *testing.T) {
+	prompt := `[it's $HOME "test"] `
+	for _, test := range []struct {
+		shell string
+		init  func(dir, prompt string, withRC bool) ([]string, []string, error)
+		file  string
+	}{
+		{"bash", initBash, "bashrc"},
+		{"sh", initSh, "shrc"},
+	} {
+		shellPath, err := exec.LookPath(test.shell)
+		if err != nil {
+			continue
+		}
+		dir := t.TempDir()
+		if _, _, err := test.init(dir, prompt, false); err != nil {
+			t.Fatal(err)
+		}
+		script := `PS1='$ '; . "$1"; printf '%s' "$PS1"`
+		out, err := exec.Command(shellPath, "-c", script, test.shell, path.Join(dir, test.file)).Output()
+		if err != nil {
+			t.Fatalf("%s couldn't source its rc file: %v", test.shell, err)
+		}
+		if want := prompt + "$ "; string(out) != want {
+			t.Errorf("%s prompt = %q, want %q", test.shell, out, want)
+		}
+	}
+}
+
+func TestSubShellInSubShell(t *testing.T) {
+	// a subshell of the same cluster is refused before talking to docker
+	t.Setenv(subShellEnv, "test")
+	err := subShell(context.Background(), nil, subShellOptions{Cluster: "test", Shell: "sh", Prompt: DefaultShellPrompt})
+	if err == nil || !strings.Contains(err.Error(), "Already in subshell of cluster test") {
+		t.Errorf("subshell in subshell of the same cluster: error = %v", err)
+	}
+
+	// switching to another cluster has to be confirmed with yes
+	t.Setenv(subShellEnv, "other")
+	tests := []struct {
+		answer string
+		want   bool
+	}{
+		{"y\n", true},
+		{"Yes\n", true},
+		{"n\n", false},
+		{"\n", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		confirm := func(current, cluster string) bool {
+			return askSwitch(strings.NewReader(test.answer), io.Discard, current, cluster)
+		}
+		if err := checkSubShell("test", confirm); (err == nil) != test.want {
+			t.Errorf("switching from cluster other with answer %q: error = %v", test.answer, err)
+		}
+	}
+
+	// without a terminal, nobody can confirm the switch, even if stdin says yes
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if _, err := writer.WriteString("y\n"); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+	stdin := os.Stdin
+	os.Stdin = reader
+	defer func() { os.Stdin = stdin }()
+	if err := checkSubShell("test", confirmSwitch); err == nil || !strings.Contains(err.Error(), "exit it first") {
+		t.Errorf("switch with stdin from a pipe: error = %v", err)
+	}
+
+	t.Setenv(subShellEnv, "")
+	if err := checkSubShell("test", nil); err != nil {
+		t.Errorf("checkSubShell() outside a subshell = %v", err)
+	}
+}
@@ -2,17 +2,16 @@ package main
 
 import (
 	"fmt"
-	"k3d-go/version"
-	"log"
 	"os"
 
 	run "k3d-go/cli"
+	"k3d-go/pkg/k3d"
+	"k3d-go/version"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
-// defaultK3sImage specifies the default image being used for server and workers
-const defaultK3sImage string = "docker.io/rancher/k3s"
 const defaultK3sClusterName string = "k3s-default"
 
 func main() {
@@ -51,11 +50,53 @@ func main() {
 				cli.StringFlag{
 					Name:  "shell, s",
 					Value: "auto",
-					Usage: "which shell to use. One of [auto, bash, zsh]",
+					Usage: "which shell to use. One of [auto, bash, zsh, fish, sh]",
+				},
+				// e.g. --prompt '({{.Cluster}}) '
+				cli.StringFlag{
+					Name:  "prompt",
+					Value: run.DefaultShellPrompt,
+					Usage: "Template of the prefix of the prompt, {{.Cluster}} is the name of the cluster",
+				},
+				cli.BoolFlag{
+					Name:  "with-rc",
+					Usage: "Load your rc files (e.g. ~/.bashrc, ~/.zshrc, config.fish) in the shell",
 				},
 			},
 			Action: run.Shell,
 		},
+		{
+			// env prints the KUBECONFIG of a cluster for eval, e.g. in scripts and CI
+			Name:  "env",
+			Usage: "Print the commands pointing a shell to the kubeconfig of a cluster (e.g. eval \"$(k3d env)\")",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "name, n",
+					Value: defaultK3sClusterName,
+					Usage: "Name of the cluster",
+				},
+				cli.StringFlag{
+					Name:  "shell, s",
+					Value: "auto",
+					Usage: "Shell to print the commands for. One of [auto, bash, zsh, sh, fish, powershell]",
+				},
+			},
+			Action: run.Env,
+		},
+		{
+			// kubectl runs the kubectl of the host against a cluster, without changing the current kubeconfig
+			Name:      "kubectl",
+			Usage:     "Run kubectl with the kubeconfig of a cluster (e.g. k3d kubectl -- get pods)",
+			ArgsUsage: "-- <kubectl args...>",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "name, n",
+					Value: defaultK3sClusterName,
+					Usage: "Name of the cluster",
+				},
+			},
+			Action: run.Kubectl,
+		},
 		{
 			Name:    "create",
 			Aliases: []string{"c"},
@@ -69,7 +110,7 @@ func main() {
 				// Most k3d arguments are using in "stringSlice" style, allowing the argument to supplied multiple times. Previously used string separated by ","
 				cli.StringSliceFlag{
 					Name:  "volume, v",
-					Usage: "Mount one or more volumes into every node of the cluster (Docker notation: `source:destination`)",
+					Usage: "Mount one or more volumes into the nodes of the cluster (Docker notation: `source:destination[@<node-specifier>]`, default: all nodes)",
 				},
 				// node specifier flags
 				// usage: --publish 80:8080/tcp@worker-1
@@ -92,7 +133,7 @@ func main() {
 					// TODO: only --api-port, -a soon since we want to use --port, -p for the --publish/--add-port functionality
 					Name:  "api-port, a, port, p",
 					Value: "6443",
-					Usage: "Specify the Kubernetes cluster API server port (Format: `[host:]port` (Note: --port/-p will be used for arbitrary port mapping as of v2.0.0, use --api-port/-a instead for setting the api port)",
+					Usage: "Specify the Kubernetes cluster API server port (Format: `[host:]port`, IPv6 hosts in brackets, e.g. `[::1]:6443` (Note: --port/-p will be used for arbitrary port mapping as of v2.0.0, use --api-port/-a instead for setting the api port)",
 				},
 				//specify timeout time
 				cli.IntFlag{
@@ -103,18 +144,29 @@ func main() {
 				cli.StringFlag{
 					Name:  "image, i",
 					Usage: "Specify a k3s image (Format: <repo>/<image>:<tag>)",
-					Value: fmt.Sprintf("%s:%s", defaultK3sImage, version.GetK3sVersion()),
+					Value: fmt.Sprintf("%s:%s", k3d.DefaultK3sImageRepo, version.GetK3sVersion()),
+				},
+				// the image is pulled once for all nodes
+				cli.StringFlag{
+					Name:  "pull",
+					Value: string(k3d.PullMissing),
+					Usage: "When to pull the k3s image. One of [always, missing, never]",
 				},
 				//accept multiple string values. can be passed multiple values for a single flag.
 				cli.StringSliceFlag{
 					//name of the flag. can be used as either "--server-arg" or "-x"
 					Name:  "server-arg, x",
-					Usage: "Pass an additional argument to k3s server (new flag per argument)",
+					Usage: "Pass an additional argument to k3s server (new flag per argument, Format: `<arg>[@<node-specifier>]`)",
+				},
+				// e.g. --agent-arg --node-label=disk=ssd@worker-0
+				cli.StringSliceFlag{
+					Name:  "agent-arg",
+					Usage: "Pass an additional argument to k3s agent on the workers (new flag per argument, Format: `<arg>[@<node-specifier>]`, e.g. --kubelet-arg=max-pods=50@worker-1)",
 				},
 				// environment variable
 				cli.StringSliceFlag{
 					Name:  "env, e",
-					Usage: "Pass an additional environment variable (new flag per variable)",
+					Usage: "Pass an additional environment variable (new flag per variable, Format: `KEY=value[@<node-specifier>]`, default: all nodes)",
 				},
 				//workder node
 				cli.IntFlag{
@@ -122,15 +174,115 @@ func main() {
 					Value: 0,
 					Usage: "Specify how many worker nodes you want to spawn",
 				},
+				// Kubernetes node labels and taints, optionally per node
+				cli.StringSliceFlag{
+					Name:  "node-label",
+					Usage: "Add a label to the Kubernetes nodes (Format: `key=value[@<node-specifier>]`, default: all nodes, e.g. tier=db@worker-0)",
+				},
+				cli.StringSliceFlag{
+					Name:  "node-taint",
+					Usage: "Add a taint to the Kubernetes nodes (Format: `key=value:effect[@<node-specifier>]`, default: all nodes, e.g. dedicated=gpu:NoSchedule@workers)",
+				},
+				// resource limits, optionally per node: <value>@<node-specifier>
+				cli.StringSliceFlag{
+					Name:  "servers-memory",
+					Usage: "Memory limit of the server, in docker notation (Format: `<size>[@<node-specifier>]`, e.g. 2g)",
+				},
+				cli.StringSliceFlag{
+					Name:  "workers-memory",
+					Usage: "Memory limit of the workers (Format: `<size>[@<node-specifier>]`, e.g. 1g, or 2g@k3d-<cluster>-worker-0 for a single worker)",
+				},
+				cli.StringSliceFlag{
+					Name:  "cpus",
+					Usage: "CPU limit of the nodes (Format: `<cpus>[@<node-specifier>]`, e.g. 1.5 for all nodes or 2@server). The other CPUs of the docker host are reserved for the system, so that the nodes report the limit as allocatable",
+				},
+				cli.StringSliceFlag{
+					Name:  "pids-limit",
+					Usage: "Maximum number of processes of the nodes (Format: `<limit>[@<node-specifier>]`)",
+				},
+				// packaged components of k3s
+				cli.StringSliceFlag{
+					Name:  "disable",
+					Usage: "Don't deploy k3s components (Format: `<component>[,<component>...]`, components: coredns, servicelb, traefik, local-storage, metrics-server)",
+				},
+				cli.StringFlag{
+					Name:  "ingress",
+					Usage: "Ingress controller of the cluster (Format: `none|traefik|nginx`, default: traefik)",
+				},
+				// manifests applied by k3s when the server starts (auto-deploying manifests)
+				cli.StringSliceFlag{
+					Name:  "manifest",
+					Usage: "Deploy the manifests of a file or directory (.yaml, .yml, .json) with the cluster (Format: `<path>`)",
+				},
+				cli.StringSliceFlag{
+					Name:  "helm-chart",
+					Usage: "Deploy a helm chart into the namespace <name> with the cluster, repo is a URL or the name of a helm repository (Format: `<name>=<repo>/<chart>[@<version>][,<values.yaml>]`)",
+				},
+				// the nodes join the cluster with this secret, which can be read back with `k3d token get`
+				cli.StringFlag{
+					Name:  "token",
+					Usage: "Token the nodes use to join the cluster (default: randomly generated)",
+				},
 				//When creating clusters with the --auto-restart flag, any running cluster
 				//will remain "running" up on docker daemon restart.
 				cli.BoolFlag{
 					Name:  "auto-restart",
 					Usage: "Set docker's --restart=unless-stopped flag on the containers",
 				},
+				// named volumes survive the recreation of the node containers (e.g. by `k3d upgrade`)
+				cli.BoolFlag{
+					Name:  "data-volumes",
+					Usage: "Keep the k3s state and node password of every node in named volumes (k3d-<cluster>-<node>-data and -node), reusing existing ones along with their token",
+				},
+				// IPv6 networking
+				cli.BoolFlag{
+					Name:  "ipv6",
+					Usage: "Create an IPv6 enabled cluster network and use IPv6 pod and service networks in k3s",
+				},
+				cli.BoolFlag{
+					Name:  "dual-stack",
+					Usage: "Create an IPv6 enabled cluster network and use dual-stack (IPv4 and IPv6) pod and service networks in k3s",
+				},
+				// host.k3d.internal is added to /etc/hosts of every node and to CoreDNS by default
+				cli.BoolFlag{
+					Name:  "no-host-entry",
+					Usage: "Disable the automatic injection of the Host IP as 'host.k3d.internal' into the containers' /etc/hosts and the CoreDNS ConfigMap",
+				},
+				// a cluster definition written by `k3d cluster export`
+				cli.StringFlag{
+					Name:  "from, f",
+					Usage: "Create the cluster from a cluster definition file (see `k3d cluster export`). Flags that are set explicitly override its values",
+				},
 			},
 			Action: run.CreateCluster,
 		},
+		{
+			// upgrade replaces the nodes one by one, keeping their data
+			Name:  "upgrade",
+			Usage: "Upgrade the k3s version of a cluster in place",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "name, n",
+					Value: defaultK3sClusterName,
+					Usage: "Name of the cluster",
+				},
+				cli.StringFlag{
+					Name:  "image, i",
+					Usage: "The new k3s image (Format: <repo>/<image>:<tag>)",
+				},
+				cli.StringFlag{
+					Name:  "pull",
+					Value: string(k3d.PullMissing),
+					Usage: "When to pull the k3s image. One of [always, missing, never]",
+				},
+				cli.IntFlag{
+					Name:  "timeout, t",
+					Value: 300,
+					Usage: "Time (in seconds) a node may take to become ready, before the upgrade is rolled back",
+				},
+			},
+			Action: run.UpgradeCluster,
+		},
 		{
 			Name:    "delete",
 			Aliases: []string{"d", "del"},
@@ -145,6 +297,10 @@ func main() {
 					Name:  "all, a",
 					Usage: "Delete all existing clusters (this ignores the --name/-n flag)",
 				},
+				cli.BoolFlag{
+					Name:  "keep-volumes",
+					Usage: "Keep the data volumes of the nodes, so that they can be reused by a new cluster with the same name",
+				},
 			},
 			Action: run.DeleteCluster,
 		},
@@ -224,13 +380,231 @@ func main() {
 			},
 			Action: run.ImportImage,
 		},
+		{
+			// cluster bundles commands dealing with cluster definitions
+			Name:  "cluster",
+			Usage: "Manage cluster definitions",
+			Subcommands: []cli.Command{
+				{
+					Name:  "export",
+					Usage: "Print the definition of a cluster as YAML (use it with `k3d create --from`)",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "name, n",
+							Value: defaultK3sClusterName,
+							Usage: "Name of the cluster",
+						},
+					},
+					Action: run.ExportCluster,
+				},
+			},
+		},
+		{
+			// logs merges the docker logs of the nodes of a cluster
+			Name:  "logs",
+			Usage: "Print the logs of the nodes of a cluster",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "name, n",
+					Value: defaultK3sClusterName,
+					Usage: "Name of the cluster",
+				},
+				cli.StringSliceFlag{
+					Name:  "node",
+					Usage: "Only print the logs of these nodes (Format: `<node-specifier>`, e.g. server, workers or worker-1, default: all nodes)",
+				},
+				cli.BoolFlag{
+					Name:  "follow, f",
+					Usage: "Follow the logs",
+				},
+				cli.StringFlag{
+					Name:  "since",
+					Usage: "Only print logs newer than a duration or a timestamp (e.g. 5m or 2024-01-02T13:23:37Z)",
+				},
+				cli.StringFlag{
+					Name:  "tail",
+					Value: "all",
+					Usage: "Number of lines to print from the end of the logs of every node",
+				},
+				cli.BoolFlag{
+					Name:  "timestamps, t",
+					Usage: "Show the timestamps of the lines",
+				},
+			},
+			Action: run.Logs,
+		},
+		{
+			// exec runs a command in the nodes of a cluster, e.g. crictl or kubectl of k3s
+			Name:                   "exec",
+			Usage:                  "Run a command in the nodes of a cluster (e.g. k3d exec -it -- sh)",
+			ArgsUsage:              "-- <command> [args...]",
+			UseShortOptionHandling: true,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "name, n",
+					Value: defaultK3sClusterName,
+					Usage: "Name of the cluster",
+				},
+				cli.StringSliceFlag{
+					Name:  "node",
+					Usage: "Run the command in these nodes, in parallel (Format: `<node-specifier>`, e.g. server, all or worker-1, default: server)",
+				},
+				cli.BoolFlag{
+					Name:  "interactive, i",
+					Usage: "Forward stdin to the command (single node only)",
+				},
+				cli.BoolFlag{
+					Name:  "tty, t",
+					Usage: "Allocate a pseudo-TTY (single node only)",
+				},
+			},
+			Action: run.Exec,
+		},
+		{
+			// token reads the secret of a cluster, e.g. for joining nodes that aren't managed by k3d
+			Name:  "token",
+			Usage: "Manage the token of a cluster",
+			Subcommands: []cli.Command{
+				{
+					Name:  "get",
+					Usage: "Print the token the nodes use to join the cluster",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "name, n",
+							Value: defaultK3sClusterName,
+							Usage: "Name of the cluster",
+						},
+					},
+					Action: run.GetToken,
+				},
+			},
+		},
+		{
+			// snapshot saves the state of a cluster to an archive and restores clusters from it
+			Name:  "snapshot",
+			Usage: "Save, restore and list snapshots of clusters",
+			Subcommands: []cli.Command{
+				{
+					Name:  "save",
+					Usage: "Stop a cluster and save its state (datastore, local-path volumes, kubeconfig, spec and token) to a snapshot",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "name, n",
+							Value: defaultK3sClusterName,
+							Usage: "Name of the cluster",
+						},
+						cli.StringFlag{
+							Name:  "snapshot, s",
+							Usage: "Name of the snapshot (default: the current time)",
+						},
+						cli.StringFlag{
+							Name:  "output, o",
+							Usage: "Path of the snapshot archive (default: $HOME/.config/k3d/.snapshots/<cluster>/<snapshot>.tar.gz)",
+						},
+					},
+					Action: run.SaveSnapshot,
+				},
+				{
+					Name:  "restore",
+					Usage: "Create a cluster from a snapshot",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "from, f",
+							Usage: "The snapshot to restore, either a file or `<cluster>/<snapshot>`",
+						},
+						cli.StringFlag{
+							Name:  "name, n",
+							Usage: "Name of the restored cluster (default: the name of the cluster the snapshot was taken of)",
+						},
+						cli.StringFlag{
+							Name:  "api-port, a",
+							Usage: "API port of the restored cluster (Format: `[host:]port`, default: the API port of the snapshot)",
+						},
+						cli.BoolFlag{
+							Name:  "replace",
+							Usage: "Delete an existing cluster with the same name first",
+						},
+						cli.IntFlag{
+							Name:  "wait, t",
+							Value: 0,
+							Usage: "Wait for the restored cluster to come up before returning until timeout (in seconds). Use --wait 0 to wait forever",
+						},
+					},
+					Action: run.RestoreSnapshot,
+				},
+				{
+					Name:  "list",
+					Usage: "List the snapshots of a cluster",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "name, n",
+							Value: defaultK3sClusterName,
+							Usage: "Name of the cluster",
+						},
+					},
+					Action: run.ListSnapshots,
+				},
+			},
+		},
+		{
+			// prune removes what's left of clusters that couldn't be created or deleted completely
+			Name:  "prune",
+			Usage: "Remove leftover k3d resources that don't belong to any cluster",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Only list the leftover resources",
+				},
+				cli.BoolFlag{
+					Name:  "volumes",
+					Usage: "Prune data volumes as well (e.g. the ones kept by `k3d delete --keep-volumes`)",
+				},
+			},
+			Action: run.Prune,
+		},
 	}
 	// global flags. Used in commands.go getKubeconfig function
 	app.Flags = []cli.Flag{
 		cli.BoolFlag{
 			Name:  "verbose",
-			Usage: "Enable verbose output",
+			Usage: "Enable verbose output (debug logs and every update of the image pull progress)",
+		},
+		// logs are written to stderr, stdout is reserved for command results
+		cli.StringFlag{
+			Name:  "log-level",
+			Value: "info",
+			Usage: "Log level. One of [trace, debug, info, warn, error]",
+		},
+		cli.StringFlag{
+			Name:  "log-format",
+			Value: "text",
+			Usage: "Log format. One of [text, json]",
+		},
+		cli.BoolFlag{
+			Name:  "quiet, q",
+			Usage: "Only log errors",
 		},
+		// secrets (e.g. cluster tokens) are masked in logs by default
+		cli.BoolFlag{
+			Name:  "show-secrets",
+			Usage: "Don't mask secrets (e.g. cluster tokens) in the logs",
+		},
+		// docker context to use instead of the current one (see `docker context ls`)
+		cli.StringFlag{
+			Name:  "context",
+			Usage: "Name of the docker context to use (overrides DOCKER_HOST, DOCKER_CONTEXT and the current context of the docker CLI)",
+		},
+	}
+	// set up logging and select the docker context before any command creates a docker client
+	app.Before = func(c *cli.Context) error {
+		if err := run.SetupLogging(c); err != nil {
+			return err
+		}
+		k3d.SetDockerContext(c.GlobalString("context"))
+		// tokens must not show up in the logs, e.g. in the debug logs of the k3s args
+		log.AddHook(k3d.SecretMaskingHook())
+		k3d.SetShowSecrets(c.GlobalBool("show-secrets"))
+		return nil
 	}
 	err := app.Run(os.Args) //run the cli application
 	if err != nil {
@@ -0,0 +1,159 @@
+package k3d
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+// k3sComponents are the packaged components of k3s which can be disabled
+var k3sComponents = []string{"coredns", "servicelb", "traefik", "local-storage", "metrics-server"}
+
+// k3sComponentSince are the k3s versions (major, minor) which added components later than the first releases
+var k3sComponentSince = map[string][2]int{
+	"local-storage":  {0, 9},
+	"metrics-server": {1, 17},
+}
+
+// ingress controllers of a cluster (see ClusterSpec.Ingress)
+const (
+	IngressNone    = "none"
+	IngressTraefik = "traefik"
+	IngressNginx   = "nginx"
+)
+
+// ingressNginxChart is deployed instead of traefik for IngressNginx (see helmChartManifest)
+const ingressNginxChart = "ingress-nginx=https://kubernetes.github.io/ingress-nginx/ingress-nginx"
+
+// container labels keeping the disabled components and the ingress controller chosen for the cluster (see ExportCluster)
+const (
+	disabledComponentsLabel = "disabledComponents"
+	ingressLabel            = "ingress"
+)
+
+// k3sMinorVersionTagRegexp matches the major and minor version of k3s image tags, e.g. v1.16.3-k3s.2 or v0.10.2
+var k3sMinorVersionTagRegexp = regexp.MustCompile(`^v(\d+)\.(\d+)\.`)
+
+// clusterAddons are the resolved components of a cluster
+type clusterAddons struct {
+	// Disabled are the components disabled by the user, without the ones implied by Ingress
+	Disabled []string
+	Ingress  string
+	// ServerArgs disable the components in k3s
+	ServerArgs []string
+	// HelmCharts are deployed in addition to the components of k3s (see ClusterSpec.HelmCharts)
+	HelmCharts []string
+}
+
+// resolveAddons validates the components to be disabled (comma separated lists are allowed) and the ingress controller
+// and translates them into k3s args for the image. Ingress controllers other than traefik disable traefik.
+func resolveAddons(disable []string, ingress, image string) (*clusterAddons, error) {
+	addons := &clusterAddons{Ingress: ingress}
+	isDisabled := map[string]bool{}
+	for _, value := range disable {
+		for _, component := range strings.Split(value, ",") {
+			component = strings.TrimSpace(component)
+			if component == "" || isDisabled[component] {
+				continue
+			}
+			if !isK3sComponent(component) {
+				return nil, fmt.Errorf("Invalid component [%s] to disable, must be one of %v", component, k3sComponents)
+			}
+			if since, ok := k3sComponentSince[component]; ok {
+				if major, minor, ok := k3sVersion(image); ok && (major < since[0] || (major == since[0] && minor < since[1])) {
+					return nil, fmt.Errorf("Invalid component [%s] to disable, k3s v%d.%d of image %s doesn't have it (added in v%d.%d)",
+						component, major, minor, image, since[0], since[1])
+				}
+			}
+			isDisabled[component] = true
+			addons.Disabled = append(addons.Disabled, component)
+		}
+	}
+
+	disabled := append([]string{}, addons.Disabled...)
+	switch ingress {
+	case "", IngressTraefik:
+		if ingress == IngressTraefik && isDisabled["traefik"] {
+			return nil, fmt.Errorf("Ingress controller traefik can't be used when traefik is disabled")
+		}
+	case IngressNone, IngressNginx:
+		if !isDisabled["traefik"] {
+			disabled = append(disabled, "traefik")
+		}
+		if ingress == IngressNginx {
+			addons.HelmCharts = append(addons.HelmCharts, ingressNginxChart)
+		}
+	default:
+		return nil, fmt.Errorf("Invalid ingress controller [%s], must be one of %s, %s or %s", ingress, IngressNone, IngressTraefik, IngressNginx)
+	}
+
+	flag := disableFlag(image)
+	for _, component := range disabled {
+		addons.ServerArgs = append(addons.ServerArgs, fmt.Sprintf("%s=%s", flag, component))
+	}
+	return addons, nil
+}
+
+func isK3sComponent(component string) bool {
+	for _, known := range k3sComponents {
+		if component == known {
+			return true
+		}
+	}
+	return false
+}
+
+// disableFlag returns the k3s flag disabling components: k3s before v1.17 only knows --no-deploy,
+// which was later removed in favour of --disable. Images without version tag (e.g. latest) are assumed to be recent.
+func disableFlag(image string) string {
+	major, minor, ok := k3sVersion(image)
+	if ok && (major < 1 || (major == 1 && minor < 17)) {
+		return "--no-deploy"
+	}
+	return "--disable"
+}
+
+// k3sVersion returns the major and minor version of k3s from the tag of an image, if it has a version tag
+func k3sVersion(image string) (major, minor int, ok bool) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return 0, 0, false
+	}
+	tagged, ok := named.(reference.Tagged)
+	if !ok {
+		return 0, 0, false
+	}
+	match := k3sMinorVersionTagRegexp.FindStringSubmatch(tagged.Tag())
+	if match == nil {
+		return 0, 0, false
+	}
+	major, _ = strconv.Atoi(match[1])
+	minor, _ = strconv.Atoi(match[2])
+	return major, minor, true
+}
+
+// isDisabled checks if the user disabled a component
+func (a *clusterAddons) isDisabled(component string) bool {
+	for _, disabled := range a.Disabled {
+		if disabled == component {
+			return true
+		}
+	}
+	return false
+}
+
+// setLabels records the disabled components and the ingress controller in the container labels of the server
+func (a *clusterAddons) setLabels(containerLabels map[string]string) {
+	if a == nil {
+		return
+	}
+	if len(a.Disabled) > 0 {
+		containerLabels[disabledComponentsLabel] = strings.Join(a.Disabled, ",")
+	}
+	if a.Ingress != "" {
+		containerLabels[ingressLabel] = a.Ingress
+	}
+}
@@ -0,0 +1,134 @@
+package k3d
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveAddons(t *testing.T) {
+	for _, test := range []struct {
+		disable    []string
+		ingress    string
+		image      string
+		disabled   []string
+		serverArgs []string
+		helmCharts []string
+	}{
+		{image: "rancher/k3s:latest"},
+		{disable: []string{"traefik,servicelb", " traefik"}, image: "rancher/k3s:latest", disabled: []string{"traefik", "servicelb"}, serverArgs: []string{"--disable=traefik", "--disable=servicelb"}},
+		{disable: []string{"coredns"}, image: "rancher/k3s:v1.29.1-k3s1", disabled: []string{"coredns"}, serverArgs: []string{"--disable=coredns"}},
+		{disable: []string{"traefik"}, image: "rancher/k3s:v0.10.2", disabled: []string{"traefik"}, serverArgs: []string{"--no-deploy=traefik"}},
+		{disable: []string{"local-storage"}, image: "rancher/k3s:v0.10.2", disabled: []string{"local-storage"}, serverArgs: []string{"--no-deploy=local-storage"}},
+		{disable: []string{"metrics-server"}, image: "rancher/k3s:v1.17.0-k3s.1", disabled: []string{"metrics-server"}, serverArgs: []string{"--disable=metrics-server"}},
+		{ingress: IngressTraefik, image: "rancher/k3s:latest"},
+		// ingress controllers other than traefik disable traefik without recording it as disabled by the user
+		{ingress: IngressNone, image: "rancher/k3s:latest", serverArgs: []string{"--disable=traefik"}},
+		{ingress: IngressNginx, disable: []string{"traefik"}, image: "rancher/k3s:latest", disabled: []string{"traefik"}, serverArgs: []string{"--disable=traefik"}, helmCharts: []string{ingressNginxChart}},
+	} {
+		addons, err := resolveAddons(test.disable, test.ingress, test.image)
+		if err != nil {
+			t.Errorf("resolveAddons(%v, %q, %q) failed: %+v", test.disable, test.ingress, test.image, err)
+			continue
+		}
+		if !reflect.DeepEqual(addons.Disabled, test.disabled) || !reflect.DeepEqual(addons.ServerArgs, test.serverArgs) || !reflect.DeepEqual(addons.HelmCharts, test.helmCharts) {
+			t.Errorf("resolveAddons(%v, %q, %q) = %+v, want disabled %v, server args %v, helm charts %v",
+				test.disable, test.ingress, test.image, addons, test.disabled, test.serverArgs, test.helmCharts)
+		}
+	}
+}
+
+func TestResolveAddonsRejectsInvalidComponents(t *testing.T) {
+	for _, test := range []struct {
+		disable []string
+		ingress string
+	}{
+		{disable: []string{"nonexistent"}},
+		{disable: []string{"traefik,kube-proxy"}},
+		{disable: []string{"traefik"}, ingress: IngressTraefik},
+		{ingress: "haproxy"},
+	} {
+		if addons, err := resolveAddons(test.disable, test.ingress, "rancher/k3s:latest"); err == nil {
+			t.Errorf("resolveAddons(%v, %q) = %+v, want an error", test.disable, test.ingress, addons)
+		}
+	}
+}
+
+func TestResolveAddonsRejectsComponentsMissingInVersion(t *testing.T) {
+	for _, test := range []struct {
+		disable string
+		image   string
+	}{
+		{disable: "metrics-server", image: "rancher/k3s:v1.16.3-k3s.2"},
+		{disable: "traefik,metrics-server", image: "rancher/k3s:v0.10.2"},
+		{disable: "local-storage", image: "rancher/k3s:v0.8.1"},
+	} {
+		if addons, err := resolveAddons([]string{test.disable}, "", test.image); err == nil || !strings.Contains(err.Error(), "doesn't have it") {
+			t.Errorf("resolveAddons(%q, %q) = %+v, %v, want an error about the version", test.disable, test.image, addons, err)
+		}
+	}
+}
+
+func TestCreateClusterRejectsComponentsMissingInVersionWithoutContainers(t *testing.T) {
+	k, rt := newTestClient(t)
+	_, err := k.CreateCluster(context.Background(), &ClusterSpec{Name: "test", Image: "rancher/k3s:v1.16.3-k3s.2", Disable: []string{"metrics-server"}})
+	if err == nil {
+		t.Fatal("CreateCluster() disabled metrics-server in k3s v1.16")
+	}
+	if len(rt.containers) != 0 || len(rt.networks) != 0 || len(rt.volumes) != 0 || len(rt.PulledImages) != 0 {
+		t.Errorf("containers, networks, volumes or images were created before the error: %v", rt.PulledImages)
+	}
+}
+
+func TestDisableFlag(t *testing.T) {
+	for _, test := range []struct {
+		image string
+		flag  string
+	}{
+		{image: "rancher/k3s:v0.10.2", flag: "--no-deploy"},
+		{image: "rancher/k3s:v1.16.3-k3s.2", flag: "--no-deploy"},
+		{image: "rancher/k3s:v1.17.0-k3s.1", flag: "--disable"},
+		{image: "rancher/k3s:v1.29.1-k3s1", flag: "--disable"},
+		{image: "rancher/k3s:v2.0.0-k3s1", flag: "--disable"},
+		{image: "rancher/k3s:latest", flag: "--disable"},
+		{image: "rancher/k3s", flag: "--disable"},
+		{image: "registry.example.com:5000/k3s:v1.16.0", flag: "--no-deploy"},
+		{image: "not a valid reference", flag: "--disable"},
+	} {
+		if flag := disableFlag(test.image); flag != test.flag {
+			t.Errorf("disableFlag(%q) = %q, want %q", test.image, flag, test.flag)
+		}
+	}
+}
+
+// patchesCoreDNS checks if any exec command patched the CoreDNS config map
+func patchesCoreDNS(execs [][]string) bool {
+	for _, cmd := range execs {
+		if strings.Contains(strings.Join(cmd, " "), "configmap coredns") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCoreDNSHostEntryIsSkippedWithoutCoreDNS(t *testing.T) {
+	ctx := context.Background()
+	for _, wait := range []bool{true, false} {
+		k, rt := newTestClient(t)
+		if _, err := k.CreateCluster(ctx, &ClusterSpec{Name: "test", Wait: wait}); err != nil {
+			t.Fatal(err)
+		}
+		if !patchesCoreDNS(rt.Execs) {
+			t.Errorf("CoreDNS wasn't patched (wait: %t)", wait)
+		}
+
+		k, rt = newTestClient(t)
+		if _, err := k.CreateCluster(ctx, &ClusterSpec{Name: "test", Wait: wait, Disable: []string{"coredns"}}); err != nil {
+			t.Fatal(err)
+		}
+		if patchesCoreDNS(rt.Execs) {
+			t.Errorf("CoreDNS was patched although it's disabled (wait: %t)", wait)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package k3d
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+	"github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultContainerNamePrefix = "k3d"
+)
+
+// kubeconfigServerHostRegexp matches the host part of the server URL in a kubeconfig file,
+// e.g. `server: https://127.0.0.1:6443` or `server: https://[::1]:6443`
+var kubeconfigServerHostRegexp = regexp.MustCompile(`(server: https://)(?:\[[^\]]*\]|[^:/\s]+)(:\d+)`)
+
+// Cluster describes an existing k3d cluster
+type Cluster struct {
+	Name   string
+	Image  string
+	Status string
+	// ServerPorts are the host ports published by the server
+	ServerPorts []string
+	Server      Node
+	Workers     []Node
+}
+
+// Node is a k3s node of a cluster, running in a container
+type Node struct {
+	ID string
+	// Name is the container name, e.g. k3d-<cluster>-worker-0
+	Name string
+	// Role is either server or worker
+	Role  string
+	Image string
+	// State is the container state, e.g. running or exited
+	State  string
+	Labels map[string]string
+}
+
+// nodeFromContainer converts a docker container to a Node
+func nodeFromContainer(container types.Container) Node {
+	name := ""
+	//container.Names is a slice of string.
+	// Each string in the format: /<container_name>
+	//[1:] removes the leading '/' character
+	if len(container.Names) > 0 {
+		name = container.Names[0][1:]
+	}
+	return Node{
+		ID:     container.ID,
+		Name:   name,
+		Role:   container.Labels["component"],
+		Image:  container.Image,
+		State:  container.State,
+		Labels: container.Labels,
+	}
+}
+
+// GetContainerName generates the container names
+func GetContainerName(role, clusterName string, postfix int) string {
+	if postfix >= 0 {
+		return fmt.Sprintf("%s-%s-%s-%d", defaultContainerNamePrefix, clusterName, role, postfix)
+	}
+	return fmt.Sprintf("%s-%s-%s", defaultContainerNamePrefix, clusterName, role)
+}
+
+// GetAllContainerNames returns a list of all containernames that will be created
+func GetAllContainerNames(clusterName string, serverCount, workerCount int) []string {
+	names := []string{}
+	// a single server has no postfix (see createServer)
+	if serverCount == 1 {
+		names = append(names, GetContainerName("server", clusterName, -1))
+	} else {
+		for postfix := 0; postfix < serverCount; postfix++ {
+			names = append(names, GetContainerName("server", clusterName, postfix))
+		}
+	}
+	for postfix := 0; postfix < workerCount; postfix++ {
+		names = append(names, GetContainerName("worker", clusterName, postfix))
+	}
+	return names
+}
+
+// createDirIfNotExists checks for the existence of a directory and creates it along with all required parents if not.
+// It returns an error if the directory (or parents) couldn't be created and nil if it worked fine or if the path already exists.
+func createDirIfNotExists(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return os.MkdirAll(path, os.ModePerm)
+	}
+	return nil
+}
+
+// createClusterDir creates a directory with the cluster name under $HOME/.config/k3d/<cluster_name>.
+// The cluster directory will be used e.g. to store the kubeconfig file.
+// It returns the path of the directory and whether it was created (false if it existed already).
+func createClusterDir(name string) (string, bool, error) {
+	clusterPath, err := getClusterDir(name)
+	if err != nil {
+		return "", false, err
+	}
+	_, err = os.Stat(clusterPath)
+	created := os.IsNotExist(err)
+	if err := createDirIfNotExists(clusterPath); err != nil {
+		return "", false, fmt.Errorf("couldn't create cluster directory [%s] -> %+v", clusterPath, err)
+	}
+	// create subdir for sharing container images
+	if err := createDirIfNotExists(clusterPath + "/images"); err != nil {
+		return clusterPath, created, fmt.Errorf("couldn't create cluster sub-directory [%s] -> %+v", clusterPath+"/images", err)
+	}
+	return clusterPath, created, nil
+}
+
+// deleteClusterDir contrary to createClusterDir, this deletes the cluster directory under $HOME/.config/k3d/<cluster_name>
+func deleteClusterDir(name string) {
+	clusterPath, _ := getClusterDir(name)
+	if err := os.RemoveAll(clusterPath); err != nil {
+		log.Warnf("couldn't delete cluster directory [%s]. You might want to delete it manually.", clusterPath)
+	}
+}
+
+// clusterSpecFile is the file in the cluster directory that holds the (resolved) spec the cluster was created with
+const clusterSpecFile = "spec.json"
+
+// clusterKubeconfigFile is the file in the cluster directory that holds the kubeconfig of the cluster (see getKubeConfig)
+const clusterKubeconfigFile = "kubeconfig.yaml"
+
+// writeClusterSpec stores the spec in the cluster directory. The file is only readable by the user, since it contains the token.
+func writeClusterSpec(spec *ClusterSpec) error {
+	clusterDir, err := getClusterDir(spec.Name)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path.Join(clusterDir, clusterSpecFile), content, 0600); err != nil {
+		return fmt.Errorf("couldn't write spec of cluster %s\n%+v", spec.Name, err)
+	}
+	return nil
+}
+
+// readClusterSpec reads the spec stored in the cluster directory
+func readClusterSpec(name string) (*ClusterSpec, error) {
+	clusterDir, err := getClusterDir(name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path.Join(clusterDir, clusterSpecFile))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read spec of cluster %s (clusters created by older k3d versions don't have one)\n%+v", name, err)
+	}
+	spec := &ClusterSpec{}
+	if err := json.Unmarshal(content, spec); err != nil {
+		return nil, fmt.Errorf("couldn't parse spec of cluster %s\n%+v", name, err)
+	}
+	return spec, nil
+}
+
+// getConfigDir returns the path to the k3d config directory which is $HOME/.config/k3d
+func getConfigDir() (string, error) {
+	homeDir, err := homedir.Dir()
+	if err != nil {
+		log.Errorf("Couldn't get user's home directory")
+		return "", err
+	}
+	// Join joins any number of path elements into a single path, separating them with slashes.
+	// It also cleans up any redundant slashes and any trailing slashes.
+	return path.Join(homeDir, ".config", "k3d"), nil
+}
+
+// getClusterDir returns the path to the cluster directory which is $HOME/.config/k3d/<cluster_name>
+func getClusterDir(name string) (string, error) {
+	configDir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(configDir, name), nil
+}
+
+func getClusterKubeConfigPath(cluster string) (string, error) {
+	// clusterDir = $HOME/.config/k3d/<cluster_name>
+	clusterDir, err := getClusterDir(cluster)
+	// Join joins any number of path elements into a single path, separating them with slashes.
+	return path.Join(clusterDir, clusterKubeconfigFile), err
+}
+
+func createKubeConfigFile(ctx context.Context, rt Runtime, cluster string) error {
+	// labels for listing containers
+	server, err := rt.ListContainers(ctx, false, map[string]string{
+		"app":       "k3d",
+		"cluster":   cluster,
+		"component": "server",
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to get server container for cluster %s\n%+v", cluster, err)
+	}
+
+	if len(server) == 0 {
+		return fmt.Errorf("no server container for cluster %s", cluster)
+	}
+
+	// get kubeconfig file from container and read contents
+	// CopyFromContainer gets the content from the container and returns it as a Reader for a TAR archive to manipulate it in the host.
+	reader, err := rt.CopyFromContainer(ctx, server[0].ID, "/output/kubeconfig.yaml")
+	if err != nil {
+		return fmt.Errorf("couldn't copy kubeconfig.yaml from server container %s\n%+v", server[0].ID, err)
+	}
+	// It's up to the caller to close the reader.
+	defer reader.Close()
+
+	readBytes, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("couldn't read kubeconfig from container\n%+v", err)
+	}
+
+	// create destination kubeconfig file
+	destPath, err := getClusterKubeConfigPath(cluster)
+	// checking the dest path
+	log.Debugf("Writing kubeconfig to %s", destPath)
+	if err != nil {
+		return err
+	}
+
+	kubeconfigfile, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("couldn't create kubeconfig file %s\n%+v", destPath, err)
+	}
+	// defer: Execute this line just before leaving the function.
+	defer kubeconfigfile.Close()
+
+	// write to file, skipping the first 512 bytes which contain file metadata and trimming any NULL characters
+
+	trimBytes := bytes.Trim(readBytes[512:], "\x00")
+	log.Tracef("Read kubeconfig of cluster %s (%d bytes)", cluster, len(trimBytes))
+
+	// If running on a docker machine, replace localhost with
+	// Fix up kubeconfig.yaml file.
+	//
+	// K3s generates the default kubeconfig.yaml with host name as 'localhost'.
+	// Change the host name to the name user specified via the --api-port argument.
+	//
+	// When user did not specify the host name and when we are running against a remote docker,
+	// set the host name to remote docker machine's IP address.
+	//
+	// Otherwise, the hostname remains as 'localhost'
+	apiHost := server[0].Labels["apihost"]
+
+	if apiHost != "" {
+		// replace the host part of the server URL (k3s writes either localhost or 127.0.0.1 there)
+		// IPv6 addresses have to be put in brackets, e.g. https://[::1]:6443
+		if isIPv6(apiHost) {
+			apiHost = fmt.Sprintf("[%s]", apiHost)
+		}
+		trimBytes = kubeconfigServerHostRegexp.ReplaceAll(trimBytes, []byte("${1}"+apiHost+"${2}"))
+	}
+	_, err = kubeconfigfile.Write(trimBytes)
+	if err != nil {
+		return fmt.Errorf("couldn't write to kubeconfig.yaml\n%+v", err)
+	}
+
+	return nil
+}
+
+func getKubeConfig(ctx context.Context, rt Runtime, cluster string) (string, error) {
+	kubeConfigPath, err := getClusterKubeConfigPath(cluster)
+	if err != nil {
+		return "", err
+	}
+
+	if clusters, err := getClusters(ctx, rt, false, cluster); err != nil || len(clusters) != 1 {
+		if err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("Cluster %s does not exist: %w", cluster, ErrClusterNotFound)
+	}
+	// If kubeconfi.yaml has not been created, generate it now
+	if _, err := os.Stat(kubeConfigPath); err != nil {
+		// IsNotExist returns a boolean indicating whether the error is known to report that a file or directory does not exist.
+		if os.IsNotExist(err) {
+			if err = createKubeConfigFile(ctx, rt, cluster); err != nil {
+				return "", err
+			}
+		} else {
+			return "", err
+		}
+	}
+
+	return kubeConfigPath, nil
+}
+
+// Classify cluster state: Running, Stopped or Abnormal
+func getClusterStatus(server Node, workers []Node) string {
+	// The cluster is in the abnromal state when server state and the worker
+	// states don't agree.
+	for _, w := range workers {
+		if w.State != server.State {
+			return "unhealthy"
+		}
+	}
+
+	switch server.State {
+	case "exited": // All containers in this state are most likely
+		// as the result of running the "k3d stop" command.
+		return "stopped"
+	}
+	return server.State
+}
+
+// When 'all' is true, 'cluster' contains all clusters found from the docker daemon
+// When 'all' is false, 'cluster' contains up to one cluster whose name matches 'name'. 'cluster' can
+// be empty if no matching cluster is found.
+func getClusters(ctx context.Context, rt Runtime, all bool, name string) (map[string]Cluster, error) {
+
+	//finding out the list of k3d-servers
+	k3dServers, err := rt.ListContainers(ctx, true, map[string]string{
+		"app":       "k3d",
+		"component": "server",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list server containers\n%+v", err)
+	}
+
+	clusters := make(map[string]Cluster)
+
+	for _, server := range k3dServers {
+		clusterName := server.Labels["cluster"]
+
+		// get all the clusters if all flag is set or if name is equal to the clusterName otherwise skip
+		if all || name == clusterName {
+			//getting the worker nodes of each k3d server
+			workers, err := rt.ListContainers(ctx, true, map[string]string{
+				"app":       "k3d",
+				"component": "worker",
+				"cluster":   clusterName,
+			})
+			if err != nil {
+				// return nil, fmt.Errorf("couldn't list worker containers for cluster %s\n%+v", server.Labels["cluster"], err)
+				log.Warnf("couldn't get worker containers for cluster %s: %+v", clusterName, err)
+			}
+			serverPorts := []string{}
+			for _, port := range server.Ports {
+				serverPorts = append(serverPorts, strconv.Itoa(int(port.PublicPort)))
+			}
+			workerNodes := []Node{}
+			for _, worker := range workers {
+				workerNodes = append(workerNodes, nodeFromContainer(worker))
+			}
+			serverNode := nodeFromContainer(server)
+			clusters[clusterName] = Cluster{
+				Name:        clusterName,
+				Image:       server.Image,
+				Status:      getClusterStatus(serverNode, workerNodes),
+				ServerPorts: serverPorts,
+				Server:      serverNode,
+				Workers:     workerNodes,
+			}
+		}
+	}
+	return clusters, nil
+}
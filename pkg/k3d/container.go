@@ -0,0 +1,356 @@
+package k3d
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	log "github.com/sirupsen/logrus"
+)
+
+// clusterConfig is the resolved configuration of a cluster, which is used for creating its nodes
+type clusterConfig struct {
+	Addons            *clusterAddons
+	APIPort           apiPort
+	AutoRestart       bool
+	ClusterName       string
+	DataVolumes       bool
+	Env               []string // set by k3d on all nodes, in front of the env of the user (NodeToEnv)
+	ExtraHosts        []string
+	Image             string
+	Manifests         []manifestFile    // copied into the manifests directory of the server before it's started
+	NodeData          map[string]string // container name -> tar archive (rooted at /) copied into the node before it's started
+	NodeToAgentArgs   map[string][]string
+	NodeToEnv         map[string][]string
+	NodeToLabels      map[string][]string
+	NodeToPortSpecMap map[string][]string
+	NodeToServerArgs  map[string][]string
+	NodeToTaints      map[string][]string
+	NodeToVolumes     map[string][]string
+	PortAutoOffset    int
+	Resources         *nodeResourceLimits
+	ServerArgs        []string // added by k3d, in front of the server args of the user (NodeToServerArgs)
+	Token             string   // recorded in the volumes of the nodes (DataVolumes), so that they can be reused with it
+}
+
+// startContainer creates and starts a container. The image has to be present already (see ensureImage).
+// If beforeStart isn't nil, it's called with the ID of the created container before the container is started.
+func startContainer(ctx context.Context, rt Runtime, j *journal, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, containerName string, beforeStart func(ID string) error) (string, error) {
+
+	// create the container
+	// the returned ID is the unique identifier of the newly created container
+	ID, err := rt.CreateContainer(ctx, containerName, config, hostConfig, networkingConfig)
+	if err != nil {
+		return "", fmt.Errorf("couldn't create container %s\n%+v", containerName, err)
+	}
+	// record the container right away, so that it's rolled back even if it fails to start
+	j.record(resourceContainer, ID, containerName)
+
+	if beforeStart != nil {
+		if err := beforeStart(ID); err != nil {
+			return "", err
+		}
+	}
+
+	// start the container
+	if err := rt.StartContainer(ctx, ID); err != nil {
+		return "", err
+	}
+	return ID, nil
+}
+
+func createServer(ctx context.Context, rt Runtime, j *journal, spec *clusterConfig) (string, error) {
+	log.Infof("Creating server using %s...", spec.Image)
+
+	containerLabels := make(map[string]string)
+	containerLabels["app"] = "k3d"
+	containerLabels["component"] = "server"
+	containerLabels["created"] = time.Now().Format("2006-01-02 15:04:05")
+	containerLabels["cluster"] = spec.ClusterName
+
+	//containerName := fmt.Sprintf("k3d-%s-server", name)
+	containerName := GetContainerName("server", spec.ClusterName, -1)
+
+	// ports to be assigned to the server belong to roles
+	// all, server or <server-container-name>
+	serverPorts, err := MergePortSpecs(spec.NodeToPortSpecMap, "server", containerName)
+	if err != nil {
+		return "", err
+	}
+
+	//problem
+	//apiPortSpec := fmt.Sprintf("0.0.0.0:%s:%s/tcp", spec.ApiPort.Port, spec.ApiPort.Port)
+	hostIP := "0.0.0.0"
+	containerLabels["apihost"] = "localhost"
+	if spec.APIPort.Host != "" {
+		hostIP = spec.APIPort.HostIP
+		containerLabels["apihost"] = spec.APIPort.Host
+	}
+	spec.Addons.setLabels(containerLabels)
+	// IPv6 host IPs have to be put in brackets, e.g. [::1]:6443:6443/tcp
+	if isIPv6(hostIP) {
+		hostIP = fmt.Sprintf("[%s]", hostIP)
+	}
+	apiPortSpec := fmt.Sprintf("%s:%s:%s/tcp", hostIP, spec.APIPort.Port, spec.APIPort.Port)
+
+	serverPorts = append(serverPorts, apiPortSpec)
+	serverPublishedPorts, err := CreatePublishedPorts(serverPorts)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse port specs %+v\n%+v", serverPorts, err)
+	}
+
+	//handle hostconfig
+	hostConfig := &container.HostConfig{
+		// Port mapping between the exposed port (container) and the host
+		// Key = containerPort. Represents the port inside the container
+		// Value = []nat.PortBinding. Represents the port on the host machine. Each nat.PortBinding struct specifies the mapping of a container port to a host port.
+		PortBindings: serverPublishedPorts.PortBindings,
+		Privileged:   true,
+		// additional /etc/hosts entries, e.g. host.k3d.internal
+		ExtraHosts: spec.ExtraHosts,
+		Resources:  spec.Resources.forNode("server", containerName),
+	}
+
+	// keep the container running even after the docker daemon restart. Stop when container.stop
+	if spec.AutoRestart {
+		hostConfig.RestartPolicy.Name = "unless-stopped"
+	}
+
+	//handle volume (a new slice, since the binds are appended to below)
+	hostConfig.Binds = argsForNode(spec.NodeToVolumes, "server", containerName)
+
+	// we need to mount the clusterDir subdirectory `clusterDir/images` to enable importing images without the need for `docker cp`
+	clusterDir, err := getClusterDir(spec.ClusterName)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get cluster dir for mounting\n%+v", err)
+	}
+	hostConfig.Binds = append(hostConfig.Binds, fmt.Sprintf("%s:/images", clusterDir+"/images"))
+
+	// the kubelet reads the capacity of the node from /proc/meminfo
+	if hostConfig.Memory > 0 {
+		meminfoBind, err := fakeMeminfoBind(spec.ClusterName, containerName, hostConfig.Memory)
+		if err != nil {
+			return "", err
+		}
+		hostConfig.Binds = append(hostConfig.Binds, meminfoBind)
+	}
+
+	// the k3s state lives in a named volume, which survives the recreation of the container
+	if spec.DataVolumes {
+		volumeBinds, err := ensureNodeVolumes(ctx, rt, j, spec.ClusterName, "server", containerName, spec.Token)
+		if err != nil {
+			return "", err
+		}
+		hostConfig.Binds = append(hostConfig.Binds, volumeBinds...)
+	}
+
+	//networkingConfig
+	networkingConfig := &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			k3dNetworkName(spec.ClusterName): {
+				Aliases: []string{containerName},
+			},
+		},
+	}
+
+	// built from a copy of the env of the spec, the node's own variables last
+	serverEnv := append([]string{}, spec.Env...)
+	serverEnv = append(serverEnv, argsForNode(spec.NodeToEnv, "server", containerName)...)
+
+	serverCmd := append([]string{"server"}, spec.ServerArgs...)
+	serverCmd = append(serverCmd, spec.Resources.kubeletArgs("server", containerName, containerLabels)...)
+	serverCmd = append(serverCmd, argsForNode(spec.NodeToServerArgs, "server", containerName)...)
+	serverCmd = append(serverCmd, nodeLabelArgs(spec, "server", containerName, containerLabels)...)
+
+	// Config contains the configuration data about a container. It should hold only portable information about the container. Here, "portable" means "independent from the host we are running on"
+	config := &container.Config{
+		Hostname:     containerName,
+		Image:        spec.Image,
+		Cmd:          serverCmd,
+		ExposedPorts: serverPublishedPorts.ExposedPorts,
+		Env:          serverEnv,
+		Labels:       containerLabels,
+	}
+	//contianer creattion response ie resp.ID
+	id, err := startContainer(ctx, rt, j, config, hostConfig, networkingConfig, containerName, spec.beforeServerStart(ctx, rt, containerName))
+	if err != nil {
+		return "", fmt.Errorf("couldn't create container %s\n%+v", containerName, err)
+	}
+
+	return id, nil
+}
+
+// creating worker node
+func createWorker(ctx context.Context, rt Runtime, j *journal, spec *clusterConfig, postfix int) (string, error) {
+
+	//create the container basic info
+	containerLabels := make(map[string]string)
+	containerLabels["app"] = "k3d"
+	containerLabels["component"] = "worker"
+	containerLabels["created"] = time.Now().Format("2006-01-02 15:04:05")
+	containerLabels["cluster"] = spec.ClusterName
+
+	//containerName := fmt.Sprintf("k3d-%s-worker-%d", name, postfix)
+	containerName := GetContainerName("worker", spec.ClusterName, postfix)
+
+	// copy the env, since workers are created concurrently from the same spec
+	env := append([]string{}, spec.Env...)
+	env = append(env, fmt.Sprintf("K3S_URL=https://k3d-%s-server:%s", spec.ClusterName, spec.APIPort.Port))
+	env = append(env, argsForNode(spec.NodeToEnv, "worker", containerName)...)
+
+	// k3d create --publish  80:80  --publish 90:90/udp --workers 1
+	// The exposed ports will be:
+	// host TCP port 80  -> k3s server TCP 80.
+	// host UDP port 91 -> k3s worker 0 UDP 90. UDP traffic
+
+	// ports to be assigned to the server belong to roles
+	// all, server or <server-container-name>
+	workerPorts, err := MergePortSpecs(spec.NodeToPortSpecMap, "worker", containerName)
+	log.Debugf("%s -> ports: %+v", containerName, workerPorts)
+	if err != nil {
+		return "", err
+	}
+	workerPublishedPorts, err := CreatePublishedPorts(workerPorts)
+	if err != nil {
+		return "", err
+	}
+	//workerPublishedPorts = workerPublishedPorts.Offset(postfix + 1)
+	if spec.PortAutoOffset > 0 {
+		// TODO: add some checks before to print a meaningful log message saying that we cannot map multiple container ports to the same host port without a offset
+		workerPublishedPorts = workerPublishedPorts.Offset(postfix + spec.PortAutoOffset)
+	}
+
+	hostConfig := &container.HostConfig{
+		//  Each entry represents a temporary filesystem (tmpfs) mount point within the container.
+		// Tmpfs is a filesystem that resides in memory and is mounted as a virtual filesystem.
+		//keys --> representing the mount points means directories
+		//values --> representing mount options. for this case empty
+		// By mounting them as tmpfs, any data written to these directories within the container will be stored in memory rather than on disk.
+		Tmpfs: map[string]string{
+			"/run":     "",
+			"/var/run": "",
+		},
+		//problem
+		PortBindings: workerPublishedPorts.PortBindings,
+		Privileged:   true,
+		ExtraHosts:   spec.ExtraHosts,
+		Resources:    spec.Resources.forNode("worker", containerName),
+	}
+
+	if spec.AutoRestart {
+		hostConfig.RestartPolicy.Name = "unless-stopped"
+	}
+
+	// argsForNode returns a new slice, so the workers (created concurrently) don't share it
+	hostConfig.Binds = argsForNode(spec.NodeToVolumes, "worker", containerName)
+
+	// we need to mount the clusterDir subdirectory `clusterDir/images` to enable importing images without the need for `docker cp`
+	clusterDir, err := getClusterDir(spec.ClusterName)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get cluster dir for mounting\n%+v", err)
+	}
+	hostConfig.Binds = append(hostConfig.Binds, fmt.Sprintf("%s:/images", clusterDir+"/images"))
+
+	// limited memory is reported to the kubelet as well (see createServer)
+	if hostConfig.Memory > 0 {
+		meminfoBind, err := fakeMeminfoBind(spec.ClusterName, containerName, hostConfig.Memory)
+		if err != nil {
+			return "", err
+		}
+		hostConfig.Binds = append(hostConfig.Binds, meminfoBind)
+	}
+
+	if spec.DataVolumes {
+		volumeBinds, err := ensureNodeVolumes(ctx, rt, j, spec.ClusterName, "worker", containerName, spec.Token)
+		if err != nil {
+			return "", err
+		}
+		hostConfig.Binds = append(hostConfig.Binds, volumeBinds...)
+	}
+
+	networkingConfig := &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			k3dNetworkName(spec.ClusterName): {
+				Aliases: []string{containerName},
+			},
+		},
+	}
+
+	agentCmd := append([]string{"agent"}, spec.Resources.kubeletArgs("worker", containerName, containerLabels)...)
+	agentCmd = append(agentCmd, argsForNode(spec.NodeToAgentArgs, "worker", containerName)...)
+	agentCmd = append(agentCmd, nodeLabelArgs(spec, "worker", containerName, containerLabels)...)
+
+	config := &container.Config{
+		Hostname:     containerName,
+		Image:        spec.Image,
+		Cmd:          agentCmd,
+		Env:          env,
+		Labels:       containerLabels,
+		ExposedPorts: workerPublishedPorts.ExposedPorts,
+	}
+
+	id, err := startContainer(ctx, rt, j, config, hostConfig, networkingConfig, containerName, spec.restoreNodeData(ctx, rt, containerName))
+	if err != nil {
+		return "", fmt.Errorf("couldn't start container %s\n%+v", containerName, err)
+	}
+
+	return id, nil
+}
+
+// maxParallelNodes limits the number of worker nodes that are created at the same time
+const maxParallelNodes = 5
+
+// createWorkers creates and starts the given number of workers concurrently (at most maxParallelNodes at a time).
+// If a worker fails, the creation of the remaining ones is canceled and the first error is returned.
+// The workers that were created are recorded in the journal either way.
+func createWorkers(ctx context.Context, rt Runtime, j *journal, spec *clusterConfig, count int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	// semaphore: a slot has to be taken before creating a worker
+	slots := make(chan struct{}, maxParallelNodes)
+
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(postfix int) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-slots }()
+
+			workerID, err := createWorker(ctx, rt, j, spec, postfix)
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			log.Debugf("Created worker with ID %s", workerID)
+		}(i)
+	}
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return firstErr
+}
+
+// deleting container
+func removeContainer(ctx context.Context, rt Runtime, ID string) error {
+	// Automatically reclaim k3s container volumes after a cluster is deleted
+	if err := rt.RemoveContainer(ctx, ID, true); err != nil {
+		return fmt.Errorf("couldn't delete container [%s] -> %+v", ID, err)
+	}
+	return nil
+}
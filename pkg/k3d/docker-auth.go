@@ -0,0 +1,160 @@
+package k3d
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/registry"
+)
+
+// dockerHubAuthKey is the key under which the docker CLI stores the credentials for docker hub
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// dockerConfigFile is the part of ~/.docker/config.json that holds the registry credentials
+type dockerConfigFile struct {
+	// Auths holds the credentials stored in the file itself (`auth` = base64 of `user:password`).
+	// If a credential store is used, the entries are empty.
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	// CredsStore is the default credential helper, e.g. `desktop` -> docker-credential-desktop
+	CredsStore string `json:"credsStore"`
+	// CredHelpers are the credential helpers per registry
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// credentialHelperResponse is the output of `docker-credential-<helper> get`
+type credentialHelperResponse struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// registryAuthKey returns the key of the registry of an image in the docker config file,
+// e.g. `registry.example.com:5000` for `registry.example.com:5000/k3s:v1` and dockerHubAuthKey for `rancher/k3s`
+func registryAuthKey(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	domain := reference.Domain(named)
+	if domain == "docker.io" {
+		return dockerHubAuthKey, nil
+	}
+	return domain, nil
+}
+
+// registryHostname strips the scheme and the path from a key of the auths section,
+// since the docker CLI used to store them as URLs (e.g. `https://registry.example.com/v1/`)
+func registryHostname(key string) string {
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	return strings.SplitN(key, "/", 2)[0]
+}
+
+// getRegistryAuth returns the credentials for the registry of an image from the docker config file,
+// encoded as expected by the image pull API, or "" if there are none (anonymous pull).
+// Credential helpers (credsStore, credHelpers) are asked first, then the auths section.
+func getRegistryAuth(image string) (string, error) {
+	key, err := registryAuthKey(image)
+	if err != nil {
+		return "", err
+	}
+
+	configDir, err := getDockerConfigDir()
+	if err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(path.Join(configDir, "config.json"))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	config := dockerConfigFile{}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return "", fmt.Errorf("couldn't parse docker config file\n%+v", err)
+	}
+
+	authConfig := registry.AuthConfig{ServerAddress: key}
+
+	helper := config.CredsStore
+	if registryHelper, ok := config.CredHelpers[key]; ok {
+		helper = registryHelper
+	}
+	found := false
+	if helper != "" {
+		found, err = getCredentialsFromHelper(helper, key, &authConfig)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if !found {
+		for authKey, auth := range config.Auths {
+			if registryHostname(authKey) != registryHostname(key) {
+				continue
+			}
+			if auth.Auth != "" {
+				decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+				if err != nil {
+					return "", fmt.Errorf("invalid credentials for registry [%s] in docker config file\n%+v", authKey, err)
+				}
+				// the password may contain colons, the username may not
+				userAndPassword := strings.SplitN(string(decoded), ":", 2)
+				if len(userAndPassword) != 2 {
+					return "", fmt.Errorf("invalid credentials for registry [%s] in docker config file", authKey)
+				}
+				authConfig.Username, authConfig.Password = userAndPassword[0], userAndPassword[1]
+				found = true
+			}
+			if auth.IdentityToken != "" {
+				authConfig.IdentityToken = auth.IdentityToken
+				found = true
+			}
+		}
+	}
+
+	if !found {
+		return "", nil
+	}
+	return registry.EncodeAuthConfig(authConfig)
+}
+
+// getCredentialsFromHelper asks the credential helper `docker-credential-<helper>` for the credentials of a registry.
+// It returns false if the helper doesn't have any.
+func getCredentialsFromHelper(helper, key string, authConfig *registry.AuthConfig) (bool, error) {
+	cmd := exec.Command(fmt.Sprintf("docker-credential-%s", helper), "get")
+	cmd.Stdin = strings.NewReader(key)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// the helpers report missing credentials on stdout and exit with 1
+		if strings.Contains(string(out), "credentials not found") {
+			return false, nil
+		}
+		return false, fmt.Errorf("credential helper [%s] failed\n%+v\n%s%s", helper, err, out, stderr)
+	}
+
+	response := credentialHelperResponse{}
+	if err := json.Unmarshal(out, &response); err != nil {
+		return false, fmt.Errorf("couldn't parse the output of credential helper [%s]\n%+v", helper, err)
+	}
+	// identity tokens are stored with the username <token>
+	if response.Username == "<token>" {
+		authConfig.IdentityToken = response.Secret
+	} else {
+		authConfig.Username = response.Username
+		authConfig.Password = response.Secret
+	}
+	return true, nil
+}
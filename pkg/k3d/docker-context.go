@@ -0,0 +1,322 @@
+package k3d
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	dockerClient "github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+	"github.com/mitchellh/go-homedir"
+)
+
+// defaultDockerContext is the name of the implicit docker context, which uses the DOCKER_* environment variables
+const defaultDockerContext = "default"
+
+// dockerContextName is the docker context selected via the global --context flag
+var dockerContextName string
+
+// SetDockerContext selects the docker context (as in `docker context ls`) used for all docker clients
+func SetDockerContext(name string) {
+	dockerContextName = name
+}
+
+// dockerEndpoint describes how to reach the docker daemon of a docker context
+type dockerEndpoint struct {
+	Host          string
+	SkipTLSVerify bool
+	// TLSDir contains ca.pem, cert.pem and key.pem if the endpoint uses TLS
+	TLSDir string
+}
+
+// dockerContextMeta is the content of ~/.docker/contexts/meta/<sha256(name)>/meta.json
+type dockerContextMeta struct {
+	Name      string
+	Endpoints map[string]struct {
+		Host          string
+		SkipTLSVerify bool
+	}
+}
+
+// getDockerConfigDir returns the docker CLI config directory, which is $DOCKER_CONFIG or $HOME/.docker
+func getDockerConfigDir() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir, nil
+	}
+	homeDir, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(homeDir, ".docker"), nil
+}
+
+// getCurrentDockerContext returns the name of the docker context that should be used.
+// It follows the precedence of the docker CLI:
+// --context > DOCKER_HOST (implicit default context) > DOCKER_CONTEXT > currentContext in config.json > default
+func getCurrentDockerContext() (string, error) {
+	if dockerContextName != "" {
+		return dockerContextName, nil
+	}
+	if os.Getenv("DOCKER_HOST") != "" {
+		return defaultDockerContext, nil
+	}
+	if name := os.Getenv("DOCKER_CONTEXT"); name != "" {
+		return name, nil
+	}
+
+	configDir, err := getDockerConfigDir()
+	if err != nil {
+		return "", err
+	}
+	configBytes, err := os.ReadFile(path.Join(configDir, "config.json"))
+	if err != nil {
+		// no config file means no context was ever selected
+		if os.IsNotExist(err) {
+			return defaultDockerContext, nil
+		}
+		return "", fmt.Errorf("couldn't read docker config file\n%+v", err)
+	}
+	config := struct {
+		CurrentContext string `json:"currentContext"`
+	}{}
+	if err := json.Unmarshal(configBytes, &config); err != nil {
+		return "", fmt.Errorf("couldn't parse docker config file\n%+v", err)
+	}
+	if config.CurrentContext == "" {
+		return defaultDockerContext, nil
+	}
+	return config.CurrentContext, nil
+}
+
+// getDockerEndpoint returns the docker endpoint of the current docker context.
+// It returns nil for the default context, meaning that the DOCKER_* environment variables should be used.
+func getDockerEndpoint() (*dockerEndpoint, error) {
+	name, err := getCurrentDockerContext()
+	if err != nil {
+		return nil, err
+	}
+	if name == defaultDockerContext {
+		return nil, nil
+	}
+
+	configDir, err := getDockerConfigDir()
+	if err != nil {
+		return nil, err
+	}
+
+	// the docker CLI stores contexts in directories named after the sha256 digest of the context name
+	digest := sha256.Sum256([]byte(name))
+	contextID := hex.EncodeToString(digest[:])
+
+	metaBytes, err := os.ReadFile(path.Join(configDir, "contexts", "meta", contextID, "meta.json"))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read docker context [%s]\n%+v", name, err)
+	}
+	meta := dockerContextMeta{}
+	if err := json.Unmarshal(metaBytes, &meta); err != nil {
+		return nil, fmt.Errorf("couldn't parse docker context [%s]\n%+v", name, err)
+	}
+	docker, ok := meta.Endpoints["docker"]
+	if !ok || docker.Host == "" {
+		return nil, fmt.Errorf("docker context [%s] has no docker endpoint", name)
+	}
+
+	endpoint := &dockerEndpoint{
+		Host:          docker.Host,
+		SkipTLSVerify: docker.SkipTLSVerify,
+	}
+	tlsDir := path.Join(configDir, "contexts", "tls", contextID, "docker")
+	if _, err := os.Stat(tlsDir); err == nil {
+		endpoint.TLSDir = tlsDir
+	}
+	return endpoint, nil
+}
+
+// newDockerClient creates a docker client for the current docker context.
+// Besides unix sockets and TCP hosts (optionally secured with TLS), it supports ssh:// hosts
+// by tunneling the API through `docker system dial-stdio` on the remote host.
+func newDockerClient() (*dockerClient.Client, error) {
+	endpoint, err := getDockerEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	// default context: DOCKER_HOST, DOCKER_API_VERSION, DOCKER_CERT_PATH, DOCKER_TLS_VERIFY
+	if endpoint == nil {
+		host := os.Getenv("DOCKER_HOST")
+		if !strings.HasPrefix(host, "ssh://") {
+			return dockerClient.NewClientWithOpts(dockerClient.FromEnv, dockerClient.WithAPIVersionNegotiation())
+		}
+		// the docker client doesn't support ssh hosts itself, so DOCKER_HOST=ssh://... is handled like an ssh context
+		endpoint = &dockerEndpoint{Host: host}
+	}
+
+	hostURL, err := url.Parse(endpoint.Host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host [%s]\n%+v", endpoint.Host, err)
+	}
+
+	if hostURL.Scheme == "ssh" {
+		return dockerClient.NewClientWithOpts(
+			// the host is a dummy, since all connections go through the ssh dialer
+			dockerClient.WithHost("http://docker.example.com"),
+			dockerClient.WithDialContext(sshDialer(hostURL)),
+			dockerClient.WithVersionFromEnv(),
+			// remote daemons might be older than the client, DOCKER_API_VERSION takes precedence though
+			dockerClient.WithAPIVersionNegotiation(),
+		)
+	}
+
+	opts := []dockerClient.Opt{}
+	if endpoint.TLSDir != "" || endpoint.SkipTLSVerify {
+		tlsOptions := tlsconfig.Options{
+			InsecureSkipVerify: endpoint.SkipTLSVerify,
+		}
+		if endpoint.TLSDir != "" {
+			tlsOptions.CAFile = path.Join(endpoint.TLSDir, "ca.pem")
+			tlsOptions.CertFile = path.Join(endpoint.TLSDir, "cert.pem")
+			tlsOptions.KeyFile = path.Join(endpoint.TLSDir, "key.pem")
+		}
+		tlsConfig, err := tlsconfig.Client(tlsOptions)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load TLS configuration for docker host [%s]\n%+v", endpoint.Host, err)
+		}
+		// the client switches to https if the transport has a TLS configuration
+		opts = append(opts, dockerClient.WithHTTPClient(&http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		}))
+	}
+	opts = append(opts, dockerClient.WithHost(endpoint.Host), dockerClient.WithVersionFromEnv(), dockerClient.WithAPIVersionNegotiation())
+	return dockerClient.NewClientWithOpts(opts...)
+}
+
+// getDockerHost returns the host name of the docker daemon if it is running on a remote machine.
+// Cluster ports are published on that machine, so this is where the API server can be reached.
+// It returns an empty string for local daemons (unix socket, npipe, localhost).
+func getDockerHost() (string, error) {
+	host := ""
+	endpoint, err := getDockerEndpoint()
+	if err != nil {
+		return "", err
+	}
+	if endpoint != nil {
+		host = endpoint.Host
+	} else {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		return "", nil
+	}
+
+	hostURL, err := url.Parse(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid docker host [%s]\n%+v", host, err)
+	}
+	switch hostURL.Scheme {
+	case "tcp", "http", "https", "ssh":
+		hostname := hostURL.Hostname()
+		if hostname == "localhost" {
+			return "", nil
+		}
+		if ip := net.ParseIP(hostname); ip != nil && ip.IsLoopback() {
+			return "", nil
+		}
+		return hostname, nil
+	}
+	return "", nil
+}
+
+// sshArgs returns the args of `ssh <host> docker system dial-stdio` for an ssh:// docker host
+func sshArgs(hostURL *url.URL) []string {
+	args := []string{}
+	if hostURL.User != nil {
+		args = append(args, "-l", hostURL.User.Username())
+	}
+	if hostURL.Port() != "" {
+		args = append(args, "-p", hostURL.Port())
+	}
+	return append(args, "--", hostURL.Hostname(), "docker", "system", "dial-stdio")
+}
+
+// sshDialer returns a dialer, which connects to the docker daemon on a remote host via
+// `ssh <host> docker system dial-stdio`, the same way the docker CLI does it.
+func sshDialer(hostURL *url.URL) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		// the HTTP transport pools the connection, so the ssh process must outlive the request that dialed it.
+		// It's killed when the connection is closed.
+		cmd := exec.Command("ssh", sshArgs(hostURL)...)
+		cmd.Stderr = os.Stderr
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("couldn't connect to docker host [%s] via ssh\n%+v", hostURL.Host, err)
+		}
+		return &commandConn{cmd: cmd, stdin: stdin, stdout: stdout}, nil
+	}
+}
+
+// commandConn implements net.Conn on top of the stdin and stdout of a command
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+}
+
+func (c *commandConn) Read(p []byte) (int, error) {
+	return c.stdout.Read(p)
+}
+
+func (c *commandConn) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+// CloseWrite closes stdin, which is used to signal EOF on hijacked connections (e.g. exec)
+func (c *commandConn) CloseWrite() error {
+	return c.stdin.Close()
+}
+
+func (c *commandConn) Close() error {
+	c.stdin.Close()
+	c.stdout.Close()
+	if c.cmd.Process != nil {
+		c.cmd.Process.Kill()
+	}
+	// the command was killed, so the exit error is expected
+	c.cmd.Wait()
+	return nil
+}
+
+func (c *commandConn) LocalAddr() net.Addr {
+	return dummyAddr{}
+}
+
+func (c *commandConn) RemoteAddr() net.Addr {
+	return dummyAddr{}
+}
+
+// deadlines are not supported on pipes to a command
+func (c *commandConn) SetDeadline(t time.Time) error      { return nil }
+func (c *commandConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *commandConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// dummyAddr is the address of a commandConn
+type dummyAddr struct{}
+
+func (dummyAddr) Network() string { return "dummy" }
+func (dummyAddr) String() string  { return "dummy" }
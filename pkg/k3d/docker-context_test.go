@@ -0,0 +1,156 @@
+package k3d
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// writeDockerContext writes the meta.json of a docker context (and its TLS directory) into the docker config directory
+func writeDockerContext(t *testing.T, configDir, name, meta string, withTLS bool) string {
+	t.Helper()
+	digest := sha256.Sum256([]byte(name))
+	contextID := hex.EncodeToString(digest[:])
+	metaDir := path.Join(configDir, "contexts", "meta", contextID)
+	if err := os.MkdirAll(metaDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(metaDir, "meta.json"), []byte(meta), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tlsDir := path.Join(configDir, "contexts", "tls", contextID, "docker")
+	if withTLS {
+		if err := os.MkdirAll(tlsDir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tlsDir
+}
+
+// setDockerEnv isolates the docker configuration of a test
+func setDockerEnv(t *testing.T, configDir, host, context string) {
+	t.Helper()
+	t.Setenv("DOCKER_CONFIG", configDir)
+	t.Setenv("DOCKER_HOST", host)
+	t.Setenv("DOCKER_CONTEXT", context)
+	SetDockerContext("")
+	t.Cleanup(func() { SetDockerContext("") })
+}
+
+func TestGetCurrentDockerContext(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		flag          string
+		host          string
+		envContext    string
+		config        string
+		wantedContext string
+	}{
+		{name: "no config", wantedContext: defaultDockerContext},
+		{name: "config", config: `{"currentContext": "remote"}`, wantedContext: "remote"},
+		{name: "config without context", config: `{"auths": {}}`, wantedContext: defaultDockerContext},
+		{name: "DOCKER_CONTEXT over config", envContext: "env", config: `{"currentContext": "remote"}`, wantedContext: "env"},
+		{name: "DOCKER_HOST over DOCKER_CONTEXT", host: "tcp://1.2.3.4:2376", envContext: "env", wantedContext: defaultDockerContext},
+		{name: "flag over everything", flag: "flag", host: "tcp://1.2.3.4:2376", envContext: "env", config: `{"currentContext": "remote"}`, wantedContext: "flag"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			configDir := t.TempDir()
+			setDockerEnv(t, configDir, test.host, test.envContext)
+			SetDockerContext(test.flag)
+			if test.config != "" {
+				if err := os.WriteFile(path.Join(configDir, "config.json"), []byte(test.config), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			name, err := getCurrentDockerContext()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != test.wantedContext {
+				t.Errorf("context = %q, want %q", name, test.wantedContext)
+			}
+		})
+	}
+}
+
+func TestGetDockerEndpoint(t *testing.T) {
+	configDir := t.TempDir()
+	setDockerEnv(t, configDir, "", "")
+	tlsDir := writeDockerContext(t, configDir, "secure", `{"Name": "secure", "Endpoints": {"docker": {"Host": "tcp://10.0.0.1:2376", "SkipTLSVerify": true}}}`, true)
+	writeDockerContext(t, configDir, "remote", `{"Name": "remote", "Endpoints": {"docker": {"Host": "ssh://me@10.0.0.2:2222"}}}`, false)
+	writeDockerContext(t, configDir, "kubernetes", `{"Name": "kubernetes", "Endpoints": {"kubernetes": {"Host": "https://10.0.0.3"}}}`, false)
+
+	for _, test := range []struct {
+		context  string
+		endpoint *dockerEndpoint
+		host     string
+	}{
+		{context: defaultDockerContext},
+		{context: "secure", endpoint: &dockerEndpoint{Host: "tcp://10.0.0.1:2376", SkipTLSVerify: true, TLSDir: tlsDir}, host: "10.0.0.1"},
+		{context: "remote", endpoint: &dockerEndpoint{Host: "ssh://me@10.0.0.2:2222"}, host: "10.0.0.2"},
+	} {
+		SetDockerContext(test.context)
+		endpoint, err := getDockerEndpoint()
+		if err != nil {
+			t.Errorf("context %s: %+v", test.context, err)
+			continue
+		}
+		if !reflect.DeepEqual(endpoint, test.endpoint) {
+			t.Errorf("endpoint of context %s = %+v, want %+v", test.context, endpoint, test.endpoint)
+		}
+		if host, err := getDockerHost(); err != nil || host != test.host {
+			t.Errorf("docker host of context %s = %q, %v, want %q", test.context, host, err, test.host)
+		}
+	}
+
+	for _, context := range []string{"kubernetes", "missing"} {
+		SetDockerContext(context)
+		if endpoint, err := getDockerEndpoint(); err == nil {
+			t.Errorf("context %s returned endpoint %+v, want an error", context, endpoint)
+		}
+	}
+}
+
+func TestGetDockerHostFromEnv(t *testing.T) {
+	for _, test := range []struct {
+		dockerHost string
+		host       string
+	}{
+		{dockerHost: "", host: ""},
+		{dockerHost: "unix:///var/run/docker.sock", host: ""},
+		{dockerHost: "npipe:////./pipe/docker_engine", host: ""},
+		{dockerHost: "tcp://localhost:2375", host: ""},
+		{dockerHost: "tcp://127.0.0.1:2375", host: ""},
+		{dockerHost: "tcp://[::1]:2375", host: ""},
+		{dockerHost: "tcp://192.168.99.100:2376", host: "192.168.99.100"},
+		{dockerHost: "tcp://[fd00::1]:2376", host: "fd00::1"},
+		{dockerHost: "ssh://me@build.example.com", host: "build.example.com"},
+	} {
+		setDockerEnv(t, t.TempDir(), test.dockerHost, "")
+		if host, err := getDockerHost(); err != nil || host != test.host {
+			t.Errorf("docker host for DOCKER_HOST=%s = %q, %v, want %q", test.dockerHost, host, err, test.host)
+		}
+	}
+}
+
+func TestSSHArgs(t *testing.T) {
+	for _, test := range []struct {
+		host string
+		args []string
+	}{
+		{host: "ssh://build.example.com", args: []string{"--", "build.example.com", "docker", "system", "dial-stdio"}},
+		{host: "ssh://me@build.example.com:2222", args: []string{"-l", "me", "-p", "2222", "--", "build.example.com", "docker", "system", "dial-stdio"}},
+	} {
+		hostURL, err := url.Parse(test.host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if args := sshArgs(hostURL); !reflect.DeepEqual(args, test.args) {
+			t.Errorf("sshArgs(%s) = %v, want %v", test.host, args, test.args)
+		}
+	}
+}
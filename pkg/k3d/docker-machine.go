@@ -1,11 +1,12 @@
-package run
+package k3d
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
 	"strings"
+
+	log "github.com/sirupsen/logrus"
 )
 
 func getDockerMachineIp() (string, error) {
@@ -28,18 +29,17 @@ func getDockerMachineIp() (string, error) {
 
 	//handle err
 	if err != nil {
-		log.Printf("Error executing 'docker-machine ip'")
 		//ExitError is returned by the functions of the os package that can exit with a non-zero status.
 		//Stderr returns the error stream returned by the command.
 		if exitError, ok := err.(*exec.ExitError); ok {
-			log.Printf("%s", string(exitError.Stderr))
+			return "", fmt.Errorf("error executing 'docker-machine ip'\n%s", strings.TrimSpace(string(exitError.Stderr)))
 		}
-		return "", err
+		return "", fmt.Errorf("error executing 'docker-machine ip'\n%+v", err)
 	}
 
 	//TrimSuffix returns s without the provided trailing suffix string. If s doesn't end with suffix, s is returned unchanged.
 	ipStr := strings.TrimSuffix(string(out), "\n")
 	ipStr = strings.TrimSuffix(ipStr, "\r")
-	fmt.Printf("ipStr: %s\n", ipStr)
+	log.Debugf("docker-machine IP: %s", ipStr)
 	return ipStr, nil
 }
@@ -0,0 +1,142 @@
+package k3d
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ExecOptions describes a command run in the nodes of a cluster (see Client.Exec)
+type ExecOptions struct {
+	Name string
+	// Nodes are node specifiers (all, server, workers, container or short names, e.g. worker-1), default: server
+	Nodes []string
+	Cmd   []string
+	// Stdin, Tty, ConsoleSize and Resize can only be used with a single node (see ExecAttachOptions)
+	Stdin       io.Reader
+	Stdout      io.Writer
+	Stderr      io.Writer
+	Tty         bool
+	ConsoleSize *[2]uint
+	Resize      <-chan [2]uint
+	// Color highlights the node prefixes of the output with ANSI colors, if the command runs on several nodes
+	Color bool
+}
+
+// Exec runs a command in the selected nodes of a cluster and returns its exit code. On a single node, the command
+// is attached to the streams of the options as is. On several nodes, it runs in parallel, every line of the output
+// is prefixed with the node name and the first non-zero exit code (in the order of the nodes) is returned.
+func (k *Client) Exec(ctx context.Context, options ExecOptions) (int, error) {
+	if len(options.Cmd) == 0 {
+		return -1, fmt.Errorf("no command given")
+	}
+	cluster, err := k.GetCluster(ctx, options.Name)
+	if err != nil {
+		return -1, err
+	}
+	specifiers := options.Nodes
+	if len(specifiers) == 0 {
+		specifiers = []string{"server"}
+	}
+	nodes, err := selectNodes(cluster, specifiers)
+	if err != nil {
+		return -1, err
+	}
+	for _, node := range nodes {
+		if node.State != "running" {
+			return -1, fmt.Errorf("node %s of cluster %s is not running (%s)", node.Name, cluster.Name, node.State)
+		}
+	}
+
+	if len(nodes) == 1 {
+		return k.rt.ExecAttached(ctx, nodes[0].ID, options.Cmd, ExecAttachOptions{
+			Stdin:       options.Stdin,
+			Stdout:      options.Stdout,
+			Stderr:      options.Stderr,
+			Tty:         options.Tty,
+			ConsoleSize: options.ConsoleSize,
+			Resize:      options.Resize,
+		})
+	}
+	if options.Stdin != nil || options.Tty {
+		return -1, fmt.Errorf("stdin and a TTY can only be attached to a single node, but %d nodes are selected", len(nodes))
+	}
+
+	prefixes := logPrefixes(cluster.Name, nodes, options.Color)
+	lock := &sync.Mutex{}
+	exitCodes := make([]int, len(nodes))
+	errs := make([]error, len(nodes))
+	wg := sync.WaitGroup{}
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node Node) {
+			defer wg.Done()
+			stdout := &prefixWriter{lock: lock, out: options.Stdout, prefix: prefixes[i]}
+			stderr := &prefixWriter{lock: lock, out: options.Stderr, prefix: prefixes[i]}
+			exitCodes[i], errs[i] = k.rt.ExecAttached(ctx, node.ID, options.Cmd, ExecAttachOptions{Stdout: stdout, Stderr: stderr})
+			stdout.Flush()
+			stderr.Flush()
+		}(i, node)
+	}
+	wg.Wait()
+
+	for i, node := range nodes {
+		if errs[i] != nil {
+			return -1, fmt.Errorf("couldn't run the command in node %s\n%+v", node.Name, errs[i])
+		}
+	}
+	exitCode := 0
+	for i, node := range nodes {
+		if exitCodes[i] != 0 {
+			log.Warnf("Command exited with code %d in node %s", exitCodes[i], node.Name)
+			if exitCode == 0 {
+				exitCode = exitCodes[i]
+			}
+		}
+	}
+	return exitCode, nil
+}
+
+// prefixWriter writes complete lines with a prefix. Writers sharing the lock don't mix up their lines.
+type prefixWriter struct {
+	lock   *sync.Mutex
+	out    io.Writer
+	prefix string
+	// buffer holds the last, incomplete line
+	buffer []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buffer = append(w.buffer, p...)
+	for {
+		end := bytes.IndexByte(w.buffer, '\n')
+		if end < 0 {
+			return len(p), nil
+		}
+		if err := w.writeLine(w.buffer[:end+1]); err != nil {
+			return 0, err
+		}
+		w.buffer = w.buffer[end+1:]
+	}
+}
+
+// Flush writes the last line, even if it's incomplete
+func (w *prefixWriter) Flush() error {
+	if len(w.buffer) == 0 {
+		return nil
+	}
+	line := append(w.buffer, '\n')
+	w.buffer = nil
+	return w.writeLine(line)
+}
+
+func (w *prefixWriter) writeLine(line []byte) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	_, err := fmt.Fprintf(w.out, "%s%s", w.prefix, line)
+	return err
+}
@@ -0,0 +1,88 @@
+package k3d
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestExecExitCodes(t *testing.T) {
+	k, rt := newTestClient(t)
+	cluster, err := k.CreateCluster(context.Background(), &ClusterSpec{Name: "test", Workers: 2, Wait: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeNames := map[string]string{cluster.Server.ID: "server"}
+	for _, worker := range cluster.Workers {
+		nodeNames[worker.ID] = nodeShortName("test", worker.Name)
+	}
+
+	tests := []struct {
+		name      string
+		nodes     []string
+		exitCodes map[string]int
+		want      int
+		// lines is the number of output lines, one per selected node
+		lines int
+	}{
+		{name: "single node succeeds", nodes: nil, want: 0, lines: 1},
+		{name: "single node fails", nodes: []string{"worker-1"}, exitCodes: map[string]int{"worker-1": 7}, want: 7, lines: 1},
+		{name: "all nodes succeed", nodes: []string{"all"}, want: 0, lines: 3},
+		{name: "one worker fails", nodes: []string{"all"}, exitCodes: map[string]int{"worker-1": 3}, want: 3, lines: 3},
+		// the first non-zero exit code in the order of the nodes is returned
+		{name: "several nodes fail", nodes: []string{"all"}, exitCodes: map[string]int{"worker-1": 5, "server": 2}, want: 2, lines: 3},
+		{name: "several workers fail", nodes: []string{"workers"}, exitCodes: map[string]int{"worker-1": 5, "worker-0": 4}, want: 4, lines: 2},
+		{name: "unselected node fails", nodes: []string{"workers"}, exitCodes: map[string]int{"server": 9}, want: 0, lines: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt.ExecHandler = func(ID string, cmd []string) (string, error) {
+				node := nodeNames[ID]
+				if exitCode := test.exitCodes[node]; exitCode != 0 {
+					return "failed in " + node + "\n", FakeExitCode(exitCode)
+				}
+				return "done in " + node + "\n", nil
+			}
+			stdout := &bytes.Buffer{}
+			exitCode, err := k.Exec(context.Background(), ExecOptions{Name: "test", Nodes: test.nodes, Cmd: []string{"true"}, Stdout: stdout, Stderr: &bytes.Buffer{}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exitCode != test.want {
+				t.Errorf("exit code = %d, want %d", exitCode, test.want)
+			}
+			// the output of every node is written, even if the command failed in another node
+			if lines := strings.Count(stdout.String(), "\n"); lines != test.lines {
+				t.Errorf("output = %q, want %d lines", stdout, test.lines)
+			}
+		})
+	}
+}
+
+func TestExecMultipleNodesPrefixesOutput(t *testing.T) {
+	k, rt := newTestClient(t)
+	if _, err := k.CreateCluster(context.Background(), &ClusterSpec{Name: "test", Workers: 1, Wait: true}); err != nil {
+		t.Fatal(err)
+	}
+	rt.ExecHandler = func(ID string, cmd []string) (string, error) {
+		return "first\nsecond without newline", FakeExitCode(1)
+	}
+	stdout := &bytes.Buffer{}
+	exitCode, err := k.Exec(context.Background(), ExecOptions{Name: "test", Nodes: []string{"all"}, Cmd: []string{"false"}, Stdout: stdout, Stderr: &bytes.Buffer{}})
+	if err != nil || exitCode != 1 {
+		t.Fatalf("Exec() = %d, %v, want exit code 1", exitCode, err)
+	}
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	sort.Strings(lines)
+	want := []string{
+		"server   | first",
+		"server   | second without newline",
+		"worker-0 | first",
+		"worker-0 | second without newline",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("output = %q, want %q", lines, want)
+	}
+}
@@ -0,0 +1,489 @@
+package k3d
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// clusterDefinitionKind identifies cluster definition files
+const clusterDefinitionKind = "Cluster"
+
+// ClusterDefinition is the portable definition of a cluster (see ExportCluster), which can be written to a YAML file
+// and used to create an equivalent cluster on another machine. It doesn't contain any state or secrets: the token as well as
+// env vars and args carrying secrets (e.g. K3S_AGENT_TOKEN or --token) are left out, and so are manifests, since they are local files.
+type ClusterDefinition struct {
+	Kind    string `yaml:"kind"`
+	Name    string `yaml:"name"`
+	Image   string `yaml:"image"`
+	Workers int    `yaml:"workers"`
+	// APIPort has the format `[host:]port`
+	APIPort string `yaml:"apiPort"`
+	// Ports are port specs with node specifiers, e.g. `8080:80@server` (see mapNodesToPortSpecs)
+	Ports      []string `yaml:"ports,omitempty"`
+	Volumes    []string `yaml:"volumes,omitempty"`
+	Env        []string `yaml:"env,omitempty"`
+	ServerArgs []string `yaml:"serverArgs,omitempty"`
+	AgentArgs  []string `yaml:"agentArgs,omitempty"`
+	// NodeLabels and NodeTaints have node specifiers, e.g. `tier=db@worker-0`
+	NodeLabels []string `yaml:"nodeLabels,omitempty"`
+	NodeTaints []string `yaml:"nodeTaints,omitempty"`
+	// Disable are disabled k3s components, Ingress is the ingress controller (see ClusterSpec.Ingress)
+	Disable []string `yaml:"disable,omitempty"`
+	Ingress string   `yaml:"ingress,omitempty"`
+	// HelmCharts are the helm charts without values files (see ClusterSpec.HelmCharts)
+	HelmCharts []string `yaml:"helmCharts,omitempty"`
+	// Memory, CPUs and PidsLimit are resource limits with node specifiers, e.g. `2g@server`
+	Memory      []string `yaml:"memory,omitempty"`
+	CPUs        []string `yaml:"cpus,omitempty"`
+	PidsLimit   []string `yaml:"pidsLimit,omitempty"`
+	AutoRestart bool     `yaml:"autoRestart,omitempty"`
+	DataVolumes bool     `yaml:"dataVolumes,omitempty"`
+	IPv6        bool     `yaml:"ipv6,omitempty"`
+	DualStack   bool     `yaml:"dualStack,omitempty"`
+	NoHostEntry bool     `yaml:"noHostEntry,omitempty"`
+}
+
+// ClusterSpec returns the spec for creating a cluster from the definition
+func (d *ClusterDefinition) ClusterSpec() *ClusterSpec {
+	return &ClusterSpec{
+		Name:        d.Name,
+		Image:       d.Image,
+		Workers:     d.Workers,
+		APIPort:     d.APIPort,
+		Ports:       append([]string{}, d.Ports...),
+		Volumes:     append([]string{}, d.Volumes...),
+		Env:         append([]string{}, d.Env...),
+		ServerArgs:  append([]string{}, d.ServerArgs...),
+		AgentArgs:   append([]string{}, d.AgentArgs...),
+		NodeLabels:  append([]string{}, d.NodeLabels...),
+		NodeTaints:  append([]string{}, d.NodeTaints...),
+		Memory:      append([]string{}, d.Memory...),
+		CPUs:        append([]string{}, d.CPUs...),
+		PidsLimit:   append([]string{}, d.PidsLimit...),
+		Disable:     append([]string{}, d.Disable...),
+		Ingress:     d.Ingress,
+		HelmCharts:  append([]string{}, d.HelmCharts...),
+		AutoRestart: d.AutoRestart,
+		DataVolumes: d.DataVolumes,
+		IPv6:        d.IPv6,
+		DualStack:   d.DualStack,
+		NoHostEntry: d.NoHostEntry,
+	}
+}
+
+// Rename changes the name of the defined cluster, including the node specifiers that refer to nodes
+// by their container name (e.g. `8080:80@k3d-<name>-worker-0`)
+func (d *ClusterDefinition) Rename(name string) {
+	renameNodeSpecifiers(d.Name, name, d.Ports, d.Memory, d.CPUs, d.PidsLimit, d.ServerArgs, d.AgentArgs, d.Env, d.Volumes, d.NodeLabels, d.NodeTaints)
+	d.Name = name
+}
+
+// rename changes the name of the cluster in the spec like ClusterDefinition.Rename
+func (spec *ClusterSpec) rename(name string) {
+	renameNodeSpecifiers(spec.Name, name, spec.Ports, spec.Memory, spec.CPUs, spec.PidsLimit, spec.ServerArgs, spec.AgentArgs, spec.Env, spec.Volumes, spec.NodeLabels, spec.NodeTaints)
+	spec.Name = name
+}
+
+// renameNodeSpecifiers replaces the cluster name in node specifiers that refer to nodes by their container name (in place)
+func renameNodeSpecifiers(oldName, newName string, specLists ...[]string) {
+	oldPrefix := fmt.Sprintf("@%s-%s-", defaultContainerNamePrefix, oldName)
+	newPrefix := fmt.Sprintf("@%s-%s-", defaultContainerNamePrefix, newName)
+	for _, specs := range specLists {
+		for i, spec := range specs {
+			specs[i] = strings.ReplaceAll(spec, oldPrefix, newPrefix)
+		}
+	}
+}
+
+// WriteClusterDefinition writes a cluster definition as YAML
+func WriteClusterDefinition(w io.Writer, d *ClusterDefinition) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(d); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// ReadClusterDefinition reads a cluster definition from a YAML file (see WriteClusterDefinition)
+func ReadClusterDefinition(filePath string) (*ClusterDefinition, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read cluster definition\n%+v", err)
+	}
+	defer file.Close()
+
+	d := &ClusterDefinition{}
+	decoder := yaml.NewDecoder(file)
+	// typos must not silently change the cluster
+	decoder.KnownFields(true)
+	if err := decoder.Decode(d); err != nil {
+		return nil, fmt.Errorf("invalid cluster definition %s\n%+v", filePath, err)
+	}
+	if d.Kind != clusterDefinitionKind {
+		return nil, fmt.Errorf("invalid cluster definition %s: kind must be %s", filePath, clusterDefinitionKind)
+	}
+	if err := CheckClusterName(d.Name); err != nil {
+		return nil, fmt.Errorf("invalid cluster definition %s\n%+v", filePath, err)
+	}
+	return d, nil
+}
+
+// ExportCluster reconstructs the definition of an existing cluster from the configuration of its containers.
+// Ports are exported per node with the host ports the nodes actually use (i.e. including the port auto offset).
+// Everything that's left out of the definition (secrets, manifests, helm charts with values files) is reported as a warning,
+// so that it can be passed when creating the cluster from the definition.
+func (k *Client) ExportCluster(ctx context.Context, name string) (*ClusterDefinition, error) {
+	rt := k.rt
+	cluster, err := k.GetCluster(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	// stable output, regardless of the order in which the runtime lists the containers
+	sort.Slice(cluster.Workers, func(i, j int) bool { return cluster.Workers[i].Name < cluster.Workers[j].Name })
+	infos, err := inspectNodes(ctx, rt, cluster)
+	if err != nil {
+		return nil, err
+	}
+	server := infos[cluster.Server.Name]
+
+	d := &ClusterDefinition{
+		Kind:        clusterDefinitionKind,
+		Name:        cluster.Name,
+		Image:       server.Config.Image,
+		Workers:     len(cluster.Workers),
+		AutoRestart: server.HostConfig.RestartPolicy.Name == "unless-stopped",
+		NoHostEntry: true,
+	}
+	for _, host := range server.HostConfig.ExtraHosts {
+		if strings.HasPrefix(host, k3dInternalHost+":") {
+			d.NoHostEntry = false
+		}
+	}
+
+	// server args: k3d adds the API port, the TLS SAN of the API host, the IPv6 networks and the args disabling
+	// components in front of the user's args
+	args := []string{}
+	if len(server.Config.Cmd) > 0 {
+		args = append(args, server.Config.Cmd[1:]...)
+	}
+	apiPort := ""
+	for i, arg := range args {
+		if arg == "--https-listen-port" && i+1 < len(args) {
+			apiPort = args[i+1]
+			args = append(args[:i:i], args[i+2:]...)
+			break
+		}
+	}
+	if apiPort == "" {
+		return nil, fmt.Errorf("couldn't find the API port of cluster %s", name)
+	}
+	d.APIPort = apiPort
+	apiHost := cluster.Server.Labels["apihost"]
+	if apiHost != "" && apiHost != "localhost" {
+		args, _ = removeArgs(args, "--tls-san", apiHost)
+		// a host bound to all interfaces was derived from the docker host, which differs on other machines
+		for _, binding := range server.HostConfig.PortBindings[nat.Port(apiPort+"/tcp")] {
+			if binding.HostIP != "" && binding.HostIP != "0.0.0.0" {
+				d.APIPort = fmt.Sprintf("%s:%s", apiHost, apiPort)
+			}
+		}
+	}
+	if remaining, ok := removeArgs(args, "--cluster-cidr", defaultClusterCIDRv6, "--service-cidr", defaultServiceCIDRv6, "--flannel-ipv6-masq"); ok {
+		args, d.IPv6 = remaining, true
+	} else if remaining, ok := removeArgs(args, "--cluster-cidr", defaultClusterCIDRv4+","+defaultClusterCIDRv6,
+		"--service-cidr", defaultServiceCIDRv4+","+defaultServiceCIDRv6, "--flannel-ipv6-masq"); ok {
+		args, d.DualStack = remaining, true
+	}
+	d.Disable = splitLabelValue(cluster.Server.Labels[disabledComponentsLabel])
+	d.Ingress = cluster.Server.Labels[ingressLabel]
+	if addons, err := resolveAddons(d.Disable, d.Ingress, server.Config.Image); err == nil {
+		for _, arg := range addons.ServerArgs {
+			args, _ = removeArgs(args, arg)
+		}
+	}
+	d.ServerArgs = withoutSecrets("server arg", cluster.Server.Name, withoutNodeLabelArgs(args, cluster.Server.Labels))
+
+	// env: without the variables set by k3d and the ones of the image
+	imageEnv := map[string]bool{}
+	if image, err := rt.InspectImage(ctx, server.Config.Image); err != nil {
+		log.Warnf("couldn't inspect image %s, the exported environment might contain its variables: %+v", server.Config.Image, err)
+	} else if image.Config != nil {
+		for _, env := range image.Config.Env {
+			imageEnv[env] = true
+		}
+	}
+	d.Env = exportNodeValues(cluster, func(node Node) []string {
+		env := []string{}
+		for _, variable := range infos[node.Name].Config.Env {
+			switch strings.SplitN(variable, "=", 2)[0] {
+			case "K3S_KUBECONFIG_OUTPUT", "K3S_CLUSTER_SECRET", "K3S_TOKEN", "K3S_URL":
+			default:
+				if !imageEnv[variable] {
+					env = append(env, variable)
+				}
+			}
+		}
+		return withoutSecrets("env var", node.Name, env)
+	})
+
+	// volumes: without the image import directory, the volumes of the node (DataVolumes) and the fake meminfo
+	d.Volumes = exportNodeValues(cluster, func(node Node) []string {
+		volumes := []string{}
+		for _, bind := range infos[node.Name].HostConfig.Binds {
+			switch {
+			case isNodeVolumeBind(node.Name, bind):
+				d.DataVolumes = true
+			case strings.HasSuffix(bind, ":/images"), strings.HasSuffix(bind, ":/proc/meminfo:ro"):
+			default:
+				volumes = append(volumes, bind)
+			}
+		}
+		return volumes
+	})
+
+	d.AgentArgs = exportAgentArgs(cluster, infos)
+	d.NodeLabels = exportNodeValues(cluster, Node.NodeLabels)
+	d.NodeTaints = exportNodeValues(cluster, Node.NodeTaints)
+
+	d.Ports = exportPortSpecs(cluster, infos, apiPort)
+	d.Memory = exportResourceLimits(cluster, infos, func(r container.Resources) string {
+		if r.Memory == 0 {
+			return ""
+		}
+		return formatMemory(r.Memory)
+	})
+	d.CPUs = exportResourceLimits(cluster, infos, func(r container.Resources) string {
+		if r.NanoCPUs == 0 {
+			return ""
+		}
+		return formatNanoCPUs(r.NanoCPUs)
+	})
+	d.PidsLimit = exportResourceLimits(cluster, infos, func(r container.Resources) string {
+		if r.PidsLimit == nil || *r.PidsLimit <= 0 {
+			return ""
+		}
+		return strconv.FormatInt(*r.PidsLimit, 10)
+	})
+
+	exportHelmCharts(d)
+	return d, nil
+}
+
+// exportHelmCharts adds the helm charts of the cluster to its definition. They're taken from the spec in the cluster
+// directory, since k3s doesn't keep track of the files it applied. Manifests and the values files of helm charts
+// are local files, which aren't available on other machines, so they're only reported.
+func exportHelmCharts(d *ClusterDefinition) {
+	spec, err := readClusterSpec(d.Name)
+	if err != nil {
+		log.Warnf("The manifests and helm charts of cluster %s aren't exported: %+v", d.Name, err)
+		return
+	}
+	if len(spec.Manifests) > 0 {
+		log.Warnf("The manifests %v of cluster %s aren't exported, since they are local files (use --manifest)", spec.Manifests, d.Name)
+	}
+	for _, chart := range spec.HelmCharts {
+		if strings.Contains(chart, ",") {
+			log.Warnf("Helm chart %s of cluster %s isn't exported, since its values file is a local file (use --helm-chart)", chart, d.Name)
+			continue
+		}
+		d.HelmCharts = append(d.HelmCharts, chart)
+	}
+}
+
+// withoutSecrets leaves out the env vars or args of a node carrying secrets (see secretPrefixes and secretFlags),
+// e.g. a --token given with --server-arg, since the definition is meant to be shared
+func withoutSecrets(kind, nodeName string, values []string) []string {
+	result := []string{}
+	for i := 0; i < len(values); i++ {
+		if redacted, secret := redactSecretValue(values[i]); secret {
+			log.Warnf("The %s %s of node %s isn't exported, since it contains a secret", kind, redacted, nodeName)
+			continue
+		}
+		if isSecretFlag(values[i]) && i+1 < len(values) {
+			log.Warnf("The %s %s of node %s isn't exported, since it contains a secret", kind, values[i]+" "+secretMask, nodeName)
+			i++
+			continue
+		}
+		result = append(result, values[i])
+	}
+	return result
+}
+
+// exportAgentArgs returns the agent args of the workers. If the workers differ, every arg gets the name of its worker as node specifier.
+func exportAgentArgs(cluster *Cluster, infos map[string]types.ContainerJSON) []string {
+	if len(cluster.Workers) == 0 {
+		return nil
+	}
+	workerArgs := map[string][]string{}
+	for _, worker := range cluster.Workers {
+		if cmd := infos[worker.Name].Config.Cmd; len(cmd) > 0 && cmd[0] == "agent" {
+			workerArgs[worker.Name] = withoutSecrets("agent arg", worker.Name, withoutNodeLabelArgs(cmd[1:], worker.Labels))
+		}
+	}
+	same := true
+	for _, worker := range cluster.Workers {
+		same = same && slices.Equal(workerArgs[worker.Name], workerArgs[cluster.Workers[0].Name])
+	}
+	if same {
+		return append([]string{}, workerArgs[cluster.Workers[0].Name]...)
+	}
+
+	args := []string{}
+	for _, worker := range cluster.Workers {
+		for _, arg := range workerArgs[worker.Name] {
+			args = append(args, fmt.Sprintf("%s@%s", arg, nodeShortName(cluster.Name, worker.Name)))
+		}
+	}
+	return args
+}
+
+// withoutNodeLabelArgs removes the args that k3d added for the node labels, taints and reserved CPUs kept in the given container labels
+func withoutNodeLabelArgs(args []string, containerLabels map[string]string) []string {
+	for label, argName := range map[string]string{
+		nodeLabelsLabel:   "--node-label",
+		nodeTaintsLabel:   "--node-taint",
+		reservedCPUsLabel: "--kubelet-arg=system-reserved=cpu",
+	} {
+		for _, value := range splitLabelValue(containerLabels[label]) {
+			args, _ = removeArgs(args, fmt.Sprintf("%s=%s", argName, value))
+		}
+	}
+	return args
+}
+
+// removeArgs removes the first occurrence of a sequence of args. It returns false if the sequence wasn't found.
+func removeArgs(args []string, sequence ...string) ([]string, bool) {
+	for i := 0; i+len(sequence) <= len(args); i++ {
+		found := true
+		for j := range sequence {
+			if args[i+j] != sequence[j] {
+				found = false
+				break
+			}
+		}
+		if found {
+			return append(args[:i:i], args[i+len(sequence):]...), true
+		}
+	}
+	return args, false
+}
+
+// inspectNodes inspects the containers of all nodes of a cluster, keyed by container name
+func inspectNodes(ctx context.Context, rt Runtime, cluster *Cluster) (map[string]types.ContainerJSON, error) {
+	infos := map[string]types.ContainerJSON{}
+	for _, node := range append([]Node{cluster.Server}, cluster.Workers...) {
+		info, err := rt.InspectContainer(ctx, node.ID)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't inspect container %s\n%+v", node.Name, err)
+		}
+		infos[node.Name] = info
+	}
+	return infos, nil
+}
+
+// exportPortSpecs converts the port bindings of all nodes (except for the API port) into port specs
+func exportPortSpecs(cluster *Cluster, infos map[string]types.ContainerJSON, apiPort string) []string {
+	// port spec -> names of the nodes with that binding
+	specNodes := map[string]map[string]bool{}
+	for _, node := range append([]Node{cluster.Server}, cluster.Workers...) {
+		for port, bindings := range infos[node.Name].HostConfig.PortBindings {
+			if node.Role == "server" && port == nat.Port(apiPort+"/tcp") {
+				continue
+			}
+			for _, binding := range bindings {
+				addSpecNode(specNodes, portSpecFromBinding(port, binding), node.Name)
+			}
+		}
+	}
+	return specsWithNodeSpecifiers(cluster, specNodes)
+}
+
+// exportResourceLimits converts one kind of resource limit of all nodes into limits with node specifiers.
+// format returns the limit of a node, or "" if it's unlimited.
+func exportResourceLimits(cluster *Cluster, infos map[string]types.ContainerJSON, format func(container.Resources) string) []string {
+	return exportNodeValues(cluster, func(node Node) []string {
+		if limit := format(infos[node.Name].HostConfig.Resources); limit != "" {
+			return []string{limit}
+		}
+		return nil
+	})
+}
+
+// exportNodeValues collects values of all nodes (e.g. env vars) and appends the node specifiers to them
+func exportNodeValues(cluster *Cluster, values func(Node) []string) []string {
+	specNodes := map[string]map[string]bool{}
+	for _, node := range append([]Node{cluster.Server}, cluster.Workers...) {
+		for _, value := range values(node) {
+			addSpecNode(specNodes, value, node.Name)
+		}
+	}
+	return specsWithNodeSpecifiers(cluster, specNodes)
+}
+
+func addSpecNode(specNodes map[string]map[string]bool, spec, name string) {
+	if specNodes[spec] == nil {
+		specNodes[spec] = map[string]bool{}
+	}
+	specNodes[spec][name] = true
+}
+
+// specsWithNodeSpecifiers appends the node specifiers to specs (e.g. port specs), given the names of the nodes per spec.
+// This is the reverse of mapNodesToPortSpecs: the node specifier is either all, server, workers or single workers
+// (always given explicitly, since port specs without one apply to the server only).
+func specsWithNodeSpecifiers(cluster *Cluster, specNodes map[string]map[string]bool) []string {
+	specs := []string{}
+	for spec, names := range specNodes {
+		if len(names) == len(cluster.Workers)+1 {
+			specs = append(specs, spec+"@all")
+			continue
+		}
+		if names[cluster.Server.Name] {
+			spec += "@server"
+		}
+		allWorkers := len(cluster.Workers) > 0
+		for _, worker := range cluster.Workers {
+			allWorkers = allWorkers && names[worker.Name]
+		}
+		if allWorkers {
+			spec += "@workers"
+		} else {
+			for _, worker := range cluster.Workers {
+				if names[worker.Name] {
+					spec += "@" + nodeShortName(cluster.Name, worker.Name)
+				}
+			}
+		}
+		specs = append(specs, spec)
+	}
+	sort.Strings(specs)
+	return specs
+}
+
+// portSpecFromBinding formats a port binding as port spec `[ip:][hostPort:]containerPort/protocol`
+func portSpecFromBinding(port nat.Port, binding nat.PortBinding) string {
+	spec := fmt.Sprintf("%s/%s", port.Port(), port.Proto())
+	if binding.HostPort != "" || (binding.HostIP != "" && binding.HostIP != "0.0.0.0") {
+		spec = fmt.Sprintf("%s:%s", binding.HostPort, spec)
+	}
+	if binding.HostIP != "" && binding.HostIP != "0.0.0.0" {
+		hostIP := binding.HostIP
+		if isIPv6(hostIP) {
+			hostIP = fmt.Sprintf("[%s]", hostIP)
+		}
+		spec = fmt.Sprintf("%s:%s", hostIP, spec)
+	}
+	return spec
+}
@@ -0,0 +1,134 @@
+package k3d
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClusterDefinitionRename(t *testing.T) {
+	d := &ClusterDefinition{
+		Name:       "old",
+		Ports:      []string{"8080:80@k3d-old-worker-0"},
+		Env:        []string{"FOO=bar@k3d-old-server", "BAZ=qux@workers"},
+		Volumes:    []string{"/data:/data@k3d-old-worker-0"},
+		ServerArgs: []string{"--node-label=a=b@k3d-old-server"},
+		NodeLabels: []string{"tier=db@k3d-old-worker-0"},
+	}
+	d.Rename("new")
+
+	want := &ClusterDefinition{
+		Name:       "new",
+		Ports:      []string{"8080:80@k3d-new-worker-0"},
+		Env:        []string{"FOO=bar@k3d-new-server", "BAZ=qux@workers"},
+		Volumes:    []string{"/data:/data@k3d-new-worker-0"},
+		ServerArgs: []string{"--node-label=a=b@k3d-new-server"},
+		NodeLabels: []string{"tier=db@k3d-new-worker-0"},
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("renamed definition = %+v, want %+v", d, want)
+	}
+}
+
+func TestExportRoundTripWithAt(t *testing.T) {
+	ctx := context.Background()
+	k, _ := newTestClient(t)
+
+	hostDir := filepath.Join(t.TempDir(), "user@host")
+	spec := &ClusterSpec{
+		Name:    "test",
+		Workers: 1,
+		Env:     []string{"EMAIL=a@b.com", "DSN=postgres://u:p@db/k3s@server"},
+		Volumes: []string{hostDir + ":/data", "cache@v1:/cache@workers"},
+	}
+	if _, err := k.CreateCluster(ctx, spec); err != nil {
+		t.Fatal(err)
+	}
+	d, err := k.ExportCluster(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"DSN=postgres://u:p@db/k3s@server", "EMAIL=a@b.com@all"}; !reflect.DeepEqual(d.Env, want) {
+		t.Errorf("exported env = %v, want %v", d.Env, want)
+	}
+	if want := []string{hostDir + ":/data@all", "cache@v1:/cache@workers"}; !reflect.DeepEqual(d.Volumes, want) {
+		t.Errorf("exported volumes = %v, want %v", d.Volumes, want)
+	}
+
+	// the exported definition creates a cluster with the same env vars and volumes
+	d.Rename("copy")
+	if _, err := k.CreateCluster(ctx, d.ClusterSpec()); err != nil {
+		t.Fatal(err)
+	}
+	original, err := k.ExportCluster(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, err := k.ExportCluster(ctx, "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(copied.Env, original.Env) || !reflect.DeepEqual(copied.Volumes, original.Volumes) {
+		t.Errorf("copied env %v and volumes %v, want %v and %v", copied.Env, copied.Volumes, original.Env, original.Volumes)
+	}
+}
+
+func TestExportLeavesOutSecretsAndLocalFiles(t *testing.T) {
+	ctx := context.Background()
+	k, _ := newTestClient(t)
+
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "app.yaml")
+	values := filepath.Join(dir, "values.yaml")
+	for _, file := range []string{manifest, values} {
+		if err := os.WriteFile(file, []byte("kind: ConfigMap\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spec := &ClusterSpec{
+		Name:       "test",
+		Workers:    1,
+		Token:      "clustertoken",
+		Env:        []string{"K3S_AGENT_TOKEN=agenttoken@workers", "FOO=bar"},
+		ServerArgs: []string{"--token", "servertoken", "--tls-san=example.com"},
+		AgentArgs:  []string{"--token=workertoken"},
+		Manifests:  []string{manifest},
+		HelmCharts: []string{"podinfo=oci://ghcr.io/stefanprodan/charts/podinfo@6.5.4", "ingress=https://kubernetes.github.io/ingress-nginx/ingress-nginx," + values},
+	}
+	if _, err := k.CreateCluster(ctx, spec); err != nil {
+		t.Fatal(err)
+	}
+	d, err := k.ExportCluster(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := WriteClusterDefinition(buffer, d); err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"clustertoken", "agenttoken", "servertoken", "workertoken"} {
+		if strings.Contains(buffer.String(), secret) {
+			t.Errorf("the definition contains the secret %q:\n%s", secret, buffer.String())
+		}
+	}
+	if want := []string{"FOO=bar@all"}; !reflect.DeepEqual(d.Env, want) {
+		t.Errorf("exported env = %v, want %v", d.Env, want)
+	}
+	if want := []string{"--tls-san=example.com"}; !reflect.DeepEqual(d.ServerArgs, want) {
+		t.Errorf("exported server args = %v, want %v", d.ServerArgs, want)
+	}
+	if len(d.AgentArgs) != 0 {
+		t.Errorf("exported agent args = %v, want none", d.AgentArgs)
+	}
+	if want := []string{"podinfo=oci://ghcr.io/stefanprodan/charts/podinfo@6.5.4"}; !reflect.DeepEqual(d.HelmCharts, want) {
+		t.Errorf("exported helm charts = %v, want %v", d.HelmCharts, want)
+	}
+	if got := d.ClusterSpec().HelmCharts; !reflect.DeepEqual(got, d.HelmCharts) {
+		t.Errorf("helm charts of the spec = %v, want %v", got, d.HelmCharts)
+	}
+}
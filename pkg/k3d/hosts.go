@@ -0,0 +1,78 @@
+package k3d
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// k3dInternalHost is the host name under which the docker host is reachable from inside the cluster
+const k3dInternalHost = "host.k3d.internal"
+
+// hostEntry returns the `host:ip` notation used by docker's --add-host for the k3d internal host
+func hostEntry(hostIP string) string {
+	return fmt.Sprintf("%s:%s", k3dInternalHost, hostIP)
+}
+
+// coreDNSPatchTimeout limits the time CoreDNS may take to be deployed once the server is up
+const coreDNSPatchTimeout = 2 * time.Minute
+
+// coreDNSPatchScript returns a shell script adding the k3d internal host to the NodeHosts of the CoreDNS config map
+// in a single attempt. It fails if the config map doesn't exist yet, i.e. k3s didn't deploy CoreDNS yet.
+func coreDNSPatchScript(hostIP string) string {
+	// 1. read the current NodeHosts
+	// 2. drop a previous host.k3d.internal entry and append the new one
+	// 3. join the lines with a literal "\n", so they can be embedded in the JSON patch
+	// 4. patch the config map
+	return fmt.Sprintf(`hosts=$(kubectl -n kube-system get configmap coredns -o jsonpath='{.data.NodeHosts}') && [ -n "$hosts" ] || exit 1
+entries=$( { printf '%%s\n' "$hosts" | sed -e '/^$/d' -e '/ %[3]s$/d'; echo '%[2]s %[1]s'; } | awk 'BEGIN{ORS="\\n"} {print}' )
+kubectl -n kube-system patch configmap coredns --type merge -p "{\"data\":{\"NodeHosts\":\"$entries\"}}"`,
+		k3dInternalHost, hostIP, strings.ReplaceAll(k3dInternalHost, ".", `\.`))
+}
+
+// injectHostEntryIntoCoreDNS adds the k3d internal host to the NodeHosts of the CoreDNS config map,
+// so that pods can resolve it as well (the /etc/hosts entry only helps processes on the nodes).
+// The config map only exists once k3s deployed CoreDNS, so the patch is retried until it succeeds,
+// at most for coreDNSPatchTimeout. The server has to be up already (see waitForServer).
+func injectHostEntryIntoCoreDNS(ctx context.Context, rt Runtime, serverID, hostIP string) error {
+	ctx, cancel := context.WithTimeout(ctx, coreDNSPatchTimeout)
+	defer cancel()
+
+	cmd := []string{"sh", "-c", coreDNSPatchScript(hostIP)}
+	for {
+		out, err := rt.Exec(ctx, serverID, cmd)
+		if err == nil {
+			return nil
+		}
+		log.Tracef("Couldn't patch CoreDNS yet: %s %+v", out, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("couldn't patch CoreDNS within %s\n%+v", coreDNSPatchTimeout, err)
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// injectHostEntryIntoCoreDNSDetached is injectHostEntryIntoCoreDNS for servers that might not be up yet (i.e. without --wait):
+// the patch runs as a detached exec process inside the server container. It first waits for k3s to deploy the CoreDNS
+// config map, without a bound, since the server may take long to come up on a slow host or with a cold image. Only then
+// the patch is retried once per second, at most for coreDNSPatchTimeout. Failures can't be reported, since nobody waits for the process.
+func injectHostEntryIntoCoreDNSDetached(ctx context.Context, rt Runtime, serverID, hostIP string) error {
+	script := fmt.Sprintf(`until kubectl -n kube-system get configmap coredns >/dev/null 2>&1; do sleep 1; done
+i=0
+while [ $i -lt %d ]; do
+( %s ) && exit 0
+i=$((i+1))
+sleep 1
+done
+exit 1`, int(coreDNSPatchTimeout.Seconds()), coreDNSPatchScript(hostIP))
+
+	// the exec process keeps running in the background of the server container
+	if err := rt.ExecDetached(ctx, serverID, []string{"sh", "-c", script}); err != nil {
+		return fmt.Errorf("couldn't start exec command for patching CoreDNS in container [%s]\n%+v", serverID, err)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package k3d
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/moby/term"
+	log "github.com/sirupsen/logrus"
+)
+
+const imageBasePathRemote = "/images/"
+
+// PullPolicy defines when the node images are pulled
+type PullPolicy string
+
+const (
+	// PullMissing pulls images that aren't present locally (default)
+	PullMissing PullPolicy = "missing"
+	// PullAlways pulls images even if they're present locally, e.g. to update a `latest` tag
+	PullAlways PullPolicy = "always"
+	// PullNever never pulls images, so they have to be present locally
+	PullNever PullPolicy = "never"
+)
+
+// ParsePullPolicy validates a pull policy given on the command line
+func ParsePullPolicy(policy string) (PullPolicy, error) {
+	switch PullPolicy(policy) {
+	case PullMissing, PullAlways, PullNever:
+		return PullPolicy(policy), nil
+	case "":
+		return PullMissing, nil
+	}
+	return "", fmt.Errorf("invalid pull policy [%s], must be one of [%s, %s, %s]", policy, PullAlways, PullMissing, PullNever)
+}
+
+// ensureImage makes sure that an image is present locally according to the pull policy.
+// It's called once per image before the nodes are created, instead of pulling the same image for every node.
+func ensureImage(ctx context.Context, rt Runtime, image string, policy PullPolicy, verbose bool) error {
+	if policy != PullAlways {
+		exists, err := rt.ImageExists(ctx, image)
+		if err != nil {
+			return fmt.Errorf("couldn't check for image %s\n%+v", image, err)
+		}
+		if exists {
+			log.Infof("Using local image %s", image)
+			return nil
+		}
+		if policy == PullNever {
+			return fmt.Errorf("image %s is not present locally and the pull policy is [%s]", image, PullNever)
+		}
+	}
+
+	log.Infof("Pulling image %s...", image)
+	// var reader io.ReadCloser. PullImage function returns (io.ReadCloser, error)
+	reader, err := rt.PullImage(ctx, image)
+	if err != nil {
+		return fmt.Errorf("couldn't pull image %s\n%+v", image, err)
+	}
+	// It's up to the caller to handle the reader (io.ReadCloser) and close it properly.
+	defer reader.Close()
+	// The pull is only finished once the stream has been read completely
+	if err := showPullProgress(reader, verbose); err != nil {
+		return fmt.Errorf("couldn't pull image %s\n%+v", image, err)
+	}
+	return nil
+}
+
+// showPullProgress decodes the JSON progress stream of an image pull and renders it to stderr:
+// as progress bars per layer on a terminal, line by line otherwise. Without a terminal, only the status changes of the
+// layers are rendered (e.g. Downloading, Pull complete), unless every progress update is requested with verbose.
+// Nothing is rendered if info logs are disabled (e.g. --quiet) or the logs are JSON formatted.
+// Errors sent within the stream (e.g. access denied or manifest unknown) are returned.
+func showPullProgress(stream io.Reader, verbose bool) error {
+	fd, isTerminal := term.GetFdInfo(os.Stderr)
+	_, jsonLogs := log.StandardLogger().Formatter.(*log.JSONFormatter)
+	switch {
+	case jsonLogs || !log.IsLevelEnabled(log.InfoLevel):
+		return jsonmessage.DisplayJSONMessagesStream(stream, io.Discard, fd, false, nil)
+	case isTerminal || verbose:
+		return jsonmessage.DisplayJSONMessagesStream(stream, os.Stderr, fd, isTerminal, nil)
+	}
+	return showPullStatus(stream, os.Stderr)
+}
+
+// showPullStatus renders the JSON progress stream of an image pull line by line, a layer only when its status changes
+func showPullStatus(stream io.Reader, out io.Writer) error {
+	decoder := json.NewDecoder(stream)
+	statuses := map[string]string{}
+	for {
+		message := jsonmessage.JSONMessage{}
+		if err := decoder.Decode(&message); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if message.Error != nil {
+			return message.Error
+		}
+		switch {
+		case message.ID == "":
+			if message.Status != "" {
+				fmt.Fprintln(out, message.Status)
+			}
+		case statuses[message.ID] != message.Status:
+			statuses[message.ID] = message.Status
+			fmt.Fprintf(out, "%s: %s\n", message.ID, message.Status)
+		}
+	}
+}
+
+func importImage(ctx context.Context, rt Runtime, clusterName, image string) error {
+	// get cluster directory to temporarily save the image tarball there
+	imageBasePathLocal, err := getClusterDir(clusterName)
+	imageBasePathLocal = imageBasePathLocal + "/images/"
+	if err != nil {
+		return fmt.Errorf("couldn't get cluster directory for cluster [%s]\n%+v", clusterName, err)
+	}
+
+	// TODO: extend to enable importing a list of images
+	imageList := []string{image}
+
+	//*** first, save the images using the local docker daemon
+	log.Infof("Saving image [%s] from local docker daemon...", image)
+
+	// SaveImages retrieves one or more images from the docker host as an io.ReadCloser. It's up to the caller to store the images and close the stream.
+	imageReader, err := rt.SaveImages(ctx, imageList)
+	if err != nil {
+		return fmt.Errorf("failed to save image [%s] locally\n%+v", image, err)
+	}
+	defer imageReader.Close()
+
+	// create tarball
+	// generate a unique filename for the image tarball based on the image name.
+	// replace ":" with "_" and "/" with "_"
+	imageTarName := strings.ReplaceAll(strings.ReplaceAll(image, ":", "_"), "/", "_") + ".tar"
+	// create tarball file with that name
+	imageTar, err := os.Create(imageBasePathLocal + imageTarName)
+	if err != nil {
+		return err
+	}
+	defer imageTar.Close()
+
+	// copy the content of the image reader (which contains the saved image) to the newly created image tarball file.
+	_, err = io.Copy(imageTar, imageReader)
+	if err != nil {
+		return fmt.Errorf("couldn't save image [%s] to file [%s]\n%+v", image, imageTar.Name(), err)
+	}
+
+	// TODO: get correct container ID by cluster name
+	clusters, err := getClusters(ctx, rt, false, clusterName)
+	if err != nil {
+		return fmt.Errorf("couldn't get cluster by name [%s]\n%+v", clusterName, err)
+	}
+	cluster, ok := clusters[clusterName]
+	if !ok {
+		return fmt.Errorf("Cluster %s does not exist: %w", clusterName, ErrClusterNotFound)
+	}
+	nodes := append([]Node{cluster.Server}, cluster.Workers...)
+
+	// *** second, import the images using ctr in the k3d nodes
+
+	// ctr is a command used to import an Image in a container.
+	// Command: ctr image import <image_tarball_name>
+	// ctr is a command-line tool for interacting with a container runtime.
+	cmd := []string{"ctr", "image", "import", imageBasePathRemote + imageTarName}
+
+	// import in each node separately
+	// TODO: create a shared image cache volume, so we don't need to import it separately
+	for _, node := range nodes {
+
+		containerName := node.Name
+		log.Infof("Importing image [%s] in container [%s]", image, containerName)
+
+		// run the import command in the container and get its output
+		content, err := rt.Exec(ctx, node.ID, cmd)
+		if err != nil {
+			return fmt.Errorf("couldn't import image in container [%s]\n%+v\n%s", containerName, err, content)
+		}
+
+		// example output "unpacking image........ ...done"
+		if !strings.Contains(content, "done") {
+			return fmt.Errorf("seems like something went wrong using `ctr image import` in container [%s]. Full output below:\n%s", containerName, content)
+		}
+	}
+
+	log.Infof("Successfully imported image [%s] in all nodes of cluster [%s]", image, clusterName)
+
+	log.Info("Cleaning up tarball...")
+	if err := os.Remove(imageBasePathLocal + imageTarName); err != nil {
+		return fmt.Errorf("Couldn't remove tarball [%s]\n%+v", imageBasePathLocal+imageTarName, err)
+	}
+	log.Info("...Done")
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package k3d
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestShowPullStatus(t *testing.T) {
+	stream := strings.Join([]string{
+		`{"status":"Pulling from rancher/k3s","id":"v1.30.0-k3s1"}`,
+		`{"status":"Pulling fs layer","id":"a1"}`,
+		`{"status":"Pulling fs layer","id":"b2"}`,
+		`{"status":"Downloading","progressDetail":{"current":10,"total":100},"id":"a1"}`,
+		`{"status":"Downloading","progressDetail":{"current":50,"total":100},"id":"a1"}`,
+		`{"status":"Download complete","id":"a1"}`,
+		`{"status":"Pull complete","id":"a1"}`,
+		`{"status":"Already exists","id":"b2"}`,
+		`{"status":"Digest: sha256:0123"}`,
+		`{"status":"Status: Downloaded newer image for rancher/k3s:v1.30.0-k3s1"}`,
+	}, "\n")
+	out := &bytes.Buffer{}
+	if err := showPullStatus(strings.NewReader(stream), out); err != nil {
+		t.Fatal(err)
+	}
+	want := "v1.30.0-k3s1: Pulling from rancher/k3s\n" +
+		"a1: Pulling fs layer\n" +
+		"b2: Pulling fs layer\n" +
+		"a1: Downloading\n" +
+		"a1: Download complete\n" +
+		"a1: Pull complete\n" +
+		"b2: Already exists\n" +
+		"Digest: sha256:0123\n" +
+		"Status: Downloaded newer image for rancher/k3s:v1.30.0-k3s1\n"
+	if out.String() != want {
+		t.Errorf("pull status =\n%s\nwant\n%s", out, want)
+	}
+
+	// errors sent within the stream fail the pull
+	stream = `{"status":"Pulling fs layer","id":"a1"}` + "\n" + `{"errorDetail":{"message":"manifest unknown"},"error":"manifest unknown"}`
+	if err := showPullStatus(strings.NewReader(stream), &bytes.Buffer{}); err == nil || err.Error() != "manifest unknown" {
+		t.Errorf("showPullStatus() = %v, want the error of the stream", err)
+	}
+	if err := showPullStatus(strings.NewReader("{no json"), &bytes.Buffer{}); err == nil {
+		t.Error("showPullStatus() of a broken stream succeeded")
+	}
+}
@@ -0,0 +1,81 @@
+package k3d
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// resourceKind is the type of a resource created for a cluster
+type resourceKind string
+
+const (
+	resourceNetwork   resourceKind = "network"
+	resourceDir       resourceKind = "directory"
+	resourceContainer resourceKind = "container"
+	resourceVolume    resourceKind = "volume"
+)
+
+// journalEntry is a resource that was created for a cluster
+type journalEntry struct {
+	kind resourceKind
+	// ID identifies the resource in the runtime (the path for directories)
+	ID   string
+	name string
+}
+
+// journal records every resource created during the creation of a cluster, in order.
+// If the creation fails, the journal is unwound in reverse order, so that exactly the resources
+// that were created are removed (even if e.g. the server container was never created).
+// Anonymous volumes are not recorded, since they are removed together with their container.
+// A nil journal doesn't record anything.
+type journal struct {
+	mutex   sync.Mutex
+	entries []journalEntry
+}
+
+// record adds a created resource to the journal
+func (j *journal) record(kind resourceKind, ID, name string) {
+	if j == nil {
+		return
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.entries = append(j.entries, journalEntry{kind: kind, ID: ID, name: name})
+}
+
+// unwind removes all recorded resources in reverse order and returns a description of every removed resource.
+// Errors are logged, but don't stop the unwinding.
+func (j *journal) unwind(ctx context.Context, rt Runtime) []string {
+	if j == nil {
+		return nil
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	removed := []string{}
+	for i := len(j.entries) - 1; i >= 0; i-- {
+		entry := j.entries[i]
+		var err error
+		switch entry.kind {
+		case resourceContainer:
+			err = removeContainer(ctx, rt, entry.ID)
+		case resourceNetwork:
+			err = rt.RemoveNetwork(ctx, entry.ID)
+		case resourceVolume:
+			err = rt.RemoveVolume(ctx, entry.ID)
+		case resourceDir:
+			err = os.RemoveAll(entry.ID)
+		}
+		if err != nil {
+			log.Warnf("couldn't remove %s [%s]: %+v", entry.kind, entry.name, err)
+			continue
+		}
+		removed = append(removed, fmt.Sprintf("%s %s", entry.kind, entry.name))
+	}
+	j.entries = nil
+	return removed
+}
@@ -0,0 +1,768 @@
+// Package k3d creates and manages k3s clusters running in docker containers.
+//
+// It can be embedded e.g. in Go integration tests:
+//
+//	cluster, err := k3d.CreateCluster(ctx, &k3d.ClusterSpec{Name: "test", Workers: 2, Wait: true})
+//	kubeconfig, err := k3d.GetKubeconfig(ctx, "test")
+//	err = k3d.DeleteCluster(ctx, "test")
+//
+// The package level functions use the docker daemon of the current docker context.
+// Use NewClient to run against another Runtime, e.g. the in-memory FakeRuntime.
+package k3d
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"k3d-go/version"
+
+	"github.com/docker/docker/api/types/container"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultK3sImageRepo is the image repository used for server and workers if no image is specified
+const DefaultK3sImageRepo = "docker.io/rancher/k3s"
+
+const (
+	defaultRegistry    = "docker.io"
+	defaultServerCount = 1
+	defaultAPIPort     = "6443"
+)
+
+// pod and service CIDRs passed to k3s for IPv6 and dual-stack clusters
+// (the IPv4 ones are the k3s defaults)
+const (
+	defaultClusterCIDRv4 = "10.42.0.0/16"
+	defaultServiceCIDRv4 = "10.43.0.0/16"
+	defaultClusterCIDRv6 = "fd42:42::/56"
+	defaultServiceCIDRv6 = "fd43:43::/112"
+)
+
+var (
+	// ErrClusterNotFound is returned if no cluster with the given name exists
+	ErrClusterNotFound = errors.New("cluster not found")
+	// ErrClusterExists is returned when creating a cluster whose name is already taken
+	ErrClusterExists = errors.New("cluster already exists")
+	// ErrTimeout is returned if the cluster didn't come up within the specified timeout
+	ErrTimeout = errors.New("cluster creation exceeded specified timeout")
+)
+
+// ClusterSpec describes a cluster to be created
+type ClusterSpec struct {
+	Name string
+	// Image is the k3s image used for all nodes (default: DefaultK3sImageRepo with the k3s version of this build)
+	Image   string
+	Workers int
+	// APIPort is the Kubernetes API server port, format `[host:]port` (default: 6443)
+	APIPort string
+	// Ports are published to the host, format `[ip:][host-port:]container-port[/protocol]@node-specifier`
+	Ports []string
+	// PortAutoOffset is added (* worker number) to the host ports of the workers
+	PortAutoOffset int
+	// Volumes are mounted into the nodes, docker notation `source:destination[@node-specifier]` (default: all nodes)
+	Volumes []string
+	// Env are environment variables of the nodes, format `KEY=value[@node-specifier]` (default: all nodes)
+	Env []string
+	// ServerArgs and AgentArgs are passed to k3s on the server and on the workers, format `<arg>[@node-specifier]`
+	// (default: all servers and all workers respectively), e.g. --node-label=disk=ssd@worker-0
+	ServerArgs []string
+	AgentArgs  []string
+	// NodeLabels (`key=value`) and NodeTaints (`key=value:effect`) are set on the Kubernetes nodes,
+	// format `<label-or-taint>[@node-specifier]` (default: all nodes)
+	NodeLabels []string
+	NodeTaints []string
+	// Memory, CPUs and PidsLimit limit the resources of the nodes, format `<value>[@node-specifier]` (default: all nodes),
+	// e.g. 2g@server, 1.5@workers or 1024@k3d-mycluster-worker-0. Memory is given in docker notation.
+	// The allocatable CPUs of a node with a CPU limit match it, since the other CPUs of the docker host are reserved for the system.
+	Memory    []string
+	CPUs      []string
+	PidsLimit []string
+	// Manifests are files or directories of manifests (.yaml, .yml, .json) which k3s applies when the server starts
+	Manifests []string
+	// HelmCharts are deployed by the helm controller of k3s, format `name=repo/chart[@version][,values.yaml]`,
+	// e.g. cert-manager=https://charts.jetstack.io/cert-manager@v1.14.4,values.yaml
+	HelmCharts []string
+	// Disable are k3s components which aren't deployed (coredns, servicelb, traefik, local-storage, metrics-server)
+	Disable []string
+	// Ingress is the ingress controller of the cluster: IngressNone, IngressTraefik (default) or IngressNginx
+	Ingress string
+	// Token is the secret the nodes use to join the cluster (default: random)
+	Token string
+	// AutoRestart sets docker's --restart=unless-stopped on the containers
+	AutoRestart bool
+	// DataVolumes keeps the k3s state and the node password of every node in named volumes (k3d-<cluster>-<node>-data
+	// and -node), which survive the recreation of the node container. Existing volumes (see DeleteOptions.KeepVolumes)
+	// are reused along with the token they were created with.
+	DataVolumes bool
+	// IPv6 creates an IPv6-only cluster, DualStack an IPv4/IPv6 dual-stack one
+	IPv6      bool
+	DualStack bool
+	// NoHostEntry disables the host.k3d.internal entry
+	NoHostEntry bool
+	// Wait blocks until the server is up, at most for Timeout (0 = forever)
+	Wait    bool
+	Timeout time.Duration
+	// Pull defines when the image is pulled (default: PullMissing)
+	Pull PullPolicy
+	// Verbose prints every progress update of the image pull if stderr is not a terminal, not only the status changes of the layers
+	Verbose bool
+}
+
+// Client manages k3d clusters in a Runtime
+type Client struct {
+	rt Runtime
+}
+
+// NewClient creates a client that manages clusters in the given runtime
+func NewClient(rt Runtime) *Client {
+	return &Client{rt: rt}
+}
+
+// NewDockerClient creates a client for the docker daemon of the current docker context
+func NewDockerClient() (*Client, error) {
+	rt, err := NewDockerRuntime()
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(rt), nil
+}
+
+// Runtime returns the runtime of the client
+func (k *Client) Runtime() Runtime {
+	return k.rt
+}
+
+// CreateCluster creates a cluster using the docker daemon of the current docker context
+func CreateCluster(ctx context.Context, spec *ClusterSpec) (*Cluster, error) {
+	k, err := NewDockerClient()
+	if err != nil {
+		return nil, err
+	}
+	return k.CreateCluster(ctx, spec)
+}
+
+// DeleteCluster deletes a cluster using the docker daemon of the current docker context
+func DeleteCluster(ctx context.Context, name string) error {
+	k, err := NewDockerClient()
+	if err != nil {
+		return err
+	}
+	return k.DeleteCluster(ctx, name)
+}
+
+// GetKubeconfig returns the path of the kubeconfig file of a cluster using the docker daemon of the current docker context
+func GetKubeconfig(ctx context.Context, name string) (string, error) {
+	k, err := NewDockerClient()
+	if err != nil {
+		return "", err
+	}
+	return k.GetKubeconfig(ctx, name)
+}
+
+// ListClusters lists all clusters using the docker daemon of the current docker context
+func ListClusters(ctx context.Context) ([]Cluster, error) {
+	k, err := NewDockerClient()
+	if err != nil {
+		return nil, err
+	}
+	return k.ListClusters(ctx)
+}
+
+// Ping checks if the runtime is reachable and returns its API version
+func (k *Client) Ping(ctx context.Context) (string, error) {
+	return k.rt.Ping(ctx)
+}
+
+// resolveImage applies the default image and registry
+func resolveImage(image string) string {
+	if image == "" {
+		image = fmt.Sprintf("%s:%s", DefaultK3sImageRepo, version.GetK3sVersion())
+	}
+	if len(strings.Split(image, "/")) <= 2 {
+		// fallback to default registry
+		image = fmt.Sprintf("%s/%s", defaultRegistry, image)
+	}
+	return image
+}
+
+// CreateCluster creates the network, the cluster directory, the server and the workers of a new cluster.
+// If the creation fails or ctx is canceled (e.g. Ctrl-C), everything that was created so far is rolled back.
+func (k *Client) CreateCluster(ctx context.Context, spec *ClusterSpec) (*Cluster, error) {
+	return k.createCluster(ctx, spec, nil)
+}
+
+// createCluster creates a cluster, copying the given node data (see clusterConfig.NodeData) into the nodes
+func (k *Client) createCluster(ctx context.Context, spec *ClusterSpec, nodeData map[string]string) (*Cluster, error) {
+	rt := k.rt
+
+	//handle cluster name
+	if err := CheckClusterName(spec.Name); err != nil {
+		return nil, err
+	}
+
+	if spec.IPv6 && spec.DualStack {
+		return nil, errors.New("IPv6 and dual-stack are mutually exclusive")
+	}
+	enableIPv6 := spec.IPv6 || spec.DualStack
+
+	// secrets must not show up in the logs (unless SetShowSecrets), e.g. in the debug logs of the args per node
+	registerSecret(spec.Name, spec.Token)
+	registerSecretValues(spec.Name, spec.ServerArgs)
+	registerSecretValues(spec.Name, spec.AgentArgs)
+	registerSecretValues(spec.Name, spec.Env)
+
+	// clusterSecret and token is a must. otherwise we can't join the server with workers
+	token := spec.Token
+	// kept data volumes can only be reused with the token they were created with, since k3s encrypts its datastore with it
+	keptTokenHash, reuseKeptToken := "", false
+	if spec.DataVolumes {
+		tokenHash, kept, err := keptVolumesTokenHash(ctx, rt, spec.Name)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case !kept:
+		case token == "" && tokenHash == "":
+			return nil, fmt.Errorf("the existing volumes of cluster %s don't record its token, use the token it was created with (--token) or remove the volumes with `k3d prune --volumes`", spec.Name)
+		case token == "":
+			// the token is read from the volumes once the image is present (see readKeptToken)
+			keptTokenHash, reuseKeptToken = tokenHash, true
+		case tokenHash != "" && hashToken(token) != tokenHash:
+			return nil, fmt.Errorf("the existing volumes of cluster %s were created with another token, use that one or remove the volumes with `k3d prune --volumes`", spec.Name)
+		}
+	}
+	if token == "" && !reuseKeptToken {
+		var err error
+		if token, err = generateToken(); err != nil {
+			return nil, err
+		}
+		registerSecret(spec.Name, token)
+		log.Debug("Generated a cluster token")
+	}
+
+	// validate everything before creating any resources
+	apiPortSpec := spec.APIPort
+	if apiPortSpec == "" {
+		apiPortSpec = defaultAPIPort
+	}
+	apiPort, err := parseAPIPort(apiPortSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeNames := GetAllContainerNames(spec.Name, defaultServerCount, spec.Workers)
+	portmap, err := mapNodesToPortSpecs(spec.Ports, nodeNames)
+	if err != nil {
+		return nil, err
+	}
+
+	resourceLimits, err := parseResourceLimits(spec.Memory, spec.CPUs, spec.PidsLimit, nodeNames)
+	if err != nil {
+		return nil, err
+	}
+	if len(resourceLimits.NanoCPUs) > 0 {
+		if resourceLimits.HostCPUs, err = rt.HostCPUs(ctx); err != nil {
+			return nil, fmt.Errorf("couldn't get the number of CPUs of the docker host\n%+v", err)
+		}
+	}
+
+	serverArgsMap, err := mapNodesToArgs("server arg", spec.ServerArgs, nodeNames, "server")
+	if err != nil {
+		return nil, err
+	}
+	agentArgsMap, err := mapNodesToArgs("agent arg", spec.AgentArgs, nodeNames, "workers")
+	if err != nil {
+		return nil, err
+	}
+	envMap, err := mapNodesToArgs("env var", spec.Env, nodeNames, "all")
+	if err != nil {
+		return nil, err
+	}
+	volumesMap, err := mapNodesToArgs("volume", spec.Volumes, nodeNames, "all")
+	if err != nil {
+		return nil, err
+	}
+	labelsMap, err := mapNodesToLabelsOrTaints("node label", spec.NodeLabels, nodeNames, validateNodeLabel)
+	if err != nil {
+		return nil, err
+	}
+	taintsMap, err := mapNodesToLabelsOrTaints("node taint", spec.NodeTaints, nodeNames, validateNodeTaint)
+	if err != nil {
+		return nil, err
+	}
+	addons, err := resolveAddons(spec.Disable, spec.Ingress, resolveImage(spec.Image))
+	if err != nil {
+		return nil, err
+	}
+	manifests, err := loadManifests(spec.Manifests, append(append([]string{}, addons.HelmCharts...), spec.HelmCharts...))
+	if err != nil {
+		return nil, err
+	}
+
+	// Check for cluster existence before using a name to create a new cluster
+	if cluster, err := getClusters(ctx, rt, false, spec.Name); err != nil {
+		return nil, err
+	} else if len(cluster) != 0 {
+		// A cluster exists with the same name. Return with an error.
+		return nil, fmt.Errorf("Cluster %s already exists: %w", spec.Name, ErrClusterExists)
+	}
+
+	// define image
+	image := resolveImage(spec.Image)
+
+	// pull the image once for all nodes, before creating any resources
+	pullPolicy := spec.Pull
+	if pullPolicy == "" {
+		pullPolicy = PullMissing
+	}
+	if err := ensureImage(ctx, rt, image, pullPolicy, spec.Verbose); err != nil {
+		return nil, abortError(ctx, err)
+	}
+	if reuseKeptToken {
+		log.Infof("Reusing the token of the existing volumes of cluster %s", spec.Name)
+		if token, err = readKeptToken(ctx, rt, spec.Name, image); err != nil {
+			return nil, abortError(ctx, err)
+		}
+		registerSecret(spec.Name, token)
+		if hashToken(token) != keptTokenHash {
+			return nil, fmt.Errorf("the token kept in the volumes of cluster %s doesn't match their token, use the token it was created with (--token) or remove the volumes with `k3d prune --volumes`", spec.Name)
+		}
+	}
+
+	// On Error (or cancellation) roll back the cluster. Every resource created from here on is recorded
+	// in the journal, which is unwound if createCluster() encounters any error, so that exactly the
+	// resources allocated for the cluster so far are removed and don't linger around.
+	j := &journal{}
+	created := false
+	defer func() {
+		if !created {
+			rollbackCluster(rt, j, spec.Name)
+		}
+	}()
+
+	// create cluster network
+	networkID, networkCreated, err := createClusterNetwork(ctx, rt, spec.Name, enableIPv6)
+	if err != nil {
+		return nil, abortError(ctx, err)
+	}
+	if networkCreated {
+		j.record(resourceNetwork, networkID, k3dNetworkName(spec.Name))
+	}
+	log.Debugf("Created cluster network with ID %s", networkID)
+
+	// the gateway of the cluster network is the docker host as seen from inside the cluster
+	extraHosts := []string{}
+	hostIP := ""
+	if !spec.NoHostEntry {
+		hostIP, err = getClusterNetworkGateway(ctx, rt, networkID)
+		if err != nil {
+			log.Warnf("couldn't resolve host gateway IP, skipping the %s entry: %+v", k3dInternalHost, err)
+		} else {
+			extraHosts = append(extraHosts, hostEntry(hostIP))
+		}
+	}
+
+	// environment variables
+	env := []string{"K3S_KUBECONFIG_OUTPUT=/output/kubeconfig.yaml"}
+
+	//The cluster secret and token to the environment variables
+	// (K3S_CLUSTER_SECRET for older k3s versions, K3S_TOKEN for newer ones)
+	k3sClusterSecret := fmt.Sprintf("K3S_CLUSTER_SECRET=%s", token)
+	k3sToken := fmt.Sprintf("K3S_TOKEN=%s", token)
+	env = append(env, k3sClusterSecret, k3sToken)
+
+	k3sServerArgs := []string{"--https-listen-port", apiPort.Port}
+	// see why docker client doesn't pay attention to DOCKER_MACHINE_NAME..
+	// 	It turns out that docker client only pays attention to the following
+	// 	environment variables:
+	// 	DOCKER_HOST to set the url to the docker server.
+	// 	DOCKER_API_VERSION to set the version of the API to reach, leave empty for latest.
+	// 	DOCKER_CERT_PATH to load the TLS certificates from.
+	// 	DOCKER_TLS_VERIFY to enable or disable TLS verification, off by default.
+	// 	A miss configured DOCKER_MACHINE_NAME won't affect docker client, so k3d
+	// 	should just ignore the error.
+
+	// If the docker daemon runs on a remote host (docker context, ssh:// or tcp:// DOCKER_HOST), the API server
+	// is published there. The ports are still bound to all interfaces of the remote host, since its public
+	// address might not be assigned to any of them (e.g. NAT).
+	if apiPort.Host == "" {
+		dockerHost, err := getDockerHost()
+		if err != nil {
+			log.Warnf("Failed to get the docker host, assuming a local docker daemon: %+v", err)
+		}
+		if dockerHost != "" {
+			apiPort.Host = dockerHost
+			apiPort.HostIP = "0.0.0.0"
+		}
+	}
+
+	// fall back to docker-machine, if DOCKER_MACHINE_NAME is configured
+	if apiPort.Host == "" {
+		apiPort.Host, err = getDockerMachineIp()
+		// IP address is the same as the host
+		apiPort.HostIP = apiPort.Host
+		if err != nil {
+			log.Warnf("Failed to get docker machine IP address, ignoring the DOCKER_MACHINE_NAME environment variable setting: %+v", err)
+		}
+	}
+
+	if apiPort.Host != "" {
+		// Add TLS SAN for non default host name
+		log.Infof("Add TLS SAN for %s", apiPort.Host)
+		k3sServerArgs = append(k3sServerArgs, "--tls-san", apiPort.Host)
+	}
+
+	// IPv6-only clusters get IPv6 pod and service networks, dual-stack clusters get both families
+	switch {
+	case spec.IPv6:
+		k3sServerArgs = append(k3sServerArgs,
+			"--cluster-cidr", defaultClusterCIDRv6,
+			"--service-cidr", defaultServiceCIDRv6,
+			"--flannel-ipv6-masq",
+		)
+	case spec.DualStack:
+		k3sServerArgs = append(k3sServerArgs,
+			"--cluster-cidr", defaultClusterCIDRv4+","+defaultClusterCIDRv6,
+			"--service-cidr", defaultServiceCIDRv4+","+defaultServiceCIDRv6,
+			"--flannel-ipv6-masq",
+		)
+	}
+
+	// disabled components of k3s (including traefik, if another ingress controller was chosen)
+	k3sServerArgs = append(k3sServerArgs, addons.ServerArgs...)
+
+	config := &clusterConfig{
+		Addons:            addons,
+		APIPort:           *apiPort,
+		AutoRestart:       spec.AutoRestart,
+		ClusterName:       spec.Name,
+		DataVolumes:       spec.DataVolumes,
+		Env:               env,
+		ExtraHosts:        extraHosts,
+		Image:             image,
+		Manifests:         manifests,
+		NodeData:          nodeData,
+		NodeToAgentArgs:   agentArgsMap,
+		NodeToEnv:         envMap,
+		NodeToLabels:      labelsMap,
+		NodeToPortSpecMap: portmap,
+		NodeToServerArgs:  serverArgsMap,
+		NodeToTaints:      taintsMap,
+		NodeToVolumes:     volumesMap,
+		PortAutoOffset:    spec.PortAutoOffset,
+		Resources:         resourceLimits,
+		ServerArgs:        k3sServerArgs,
+		Token:             token,
+	}
+
+	// let's go
+	log.Infof("Creating cluster [%s]", spec.Name)
+
+	// create the directory where we will put the kubeconfig file by default (when running `k3d get-config`)
+	clusterDir, dirCreated, err := createClusterDir(spec.Name)
+	if dirCreated {
+		j.record(resourceDir, clusterDir, clusterDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// store the resolved spec, e.g. for snapshots
+	resolvedSpec := *spec
+	resolvedSpec.Image = image
+	resolvedSpec.Token = token
+	if err := writeClusterSpec(&resolvedSpec); err != nil {
+		return nil, err
+	}
+
+	// create a k3s server container by passing the arguments
+	// createServer creates a new server container
+	// dockerID is the ID of the container
+	// container.go -> createServer()
+	dockerID, err := createServer(ctx, rt, j, config)
+	if err != nil {
+		return nil, abortError(ctx, err)
+	}
+
+	// wait for k3s to be up and running if we want it
+	if spec.Wait {
+		if err := waitForServer(ctx, rt, dockerID, spec.Timeout); err != nil {
+			return nil, err
+		}
+	}
+
+	// creating the specified worker nodes (concurrently)
+	if spec.Workers > 0 {
+		log.Infof("Booting %d workers for cluster %s", spec.Workers, spec.Name)
+		if err := createWorkers(ctx, rt, j, config, spec.Workers); err != nil {
+			// if worker creation fails, roll back the cluster and exit. Atomic creation
+			return nil, abortError(ctx, err)
+		}
+	}
+	// make the host entry resolvable for pods as well: right away if the server is up (--wait),
+	// otherwise in the background as soon as k3s deployed CoreDNS.
+	// Without CoreDNS there's no config map to patch.
+	if hostIP != "" && addons.isDisabled("coredns") {
+		log.Infof("Not injecting %s into CoreDNS, since coredns is disabled", k3dInternalHost)
+	} else if hostIP != "" {
+		log.Infof("Injecting %s (%s) into CoreDNS", k3dInternalHost, hostIP)
+		inject := injectHostEntryIntoCoreDNSDetached
+		if spec.Wait {
+			inject = injectHostEntryIntoCoreDNS
+		}
+		if err := inject(ctx, rt, dockerID, hostIP); err != nil {
+			log.Warnf("couldn't inject %s into CoreDNS: %+v", k3dInternalHost, err)
+		}
+	}
+
+	created = true
+	log.Infof("Created cluster [%s]", spec.Name)
+	return k.GetCluster(ctx, spec.Name)
+}
+
+// waitForServer waits until k3s is up and running in the server container, i.e. until the server logs
+// contain "Running kubelet". It returns ErrTimeout if that takes longer than timeout (0 = wait forever).
+func waitForServer(ctx context.Context, rt Runtime, serverID string, timeout time.Duration) error {
+	if timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// infinite loop until k3s is up, the timeout exceeded or the context was canceled
+	for {
+		// get the docker logs of the created container
+		// GetContainerLogs returns the logs generated by a container in an io.ReadCloser. It's up to the caller to close the stream.
+		// The options parameter allows to specify the options of the logs.
+		out, err := rt.GetContainerLogs(ctx, serverID, container.LogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return abortError(ctx, err)
+			}
+			return fmt.Errorf("couldn't get docker logs for %s\n%+v", serverID, err)
+		}
+		// represents a buffer for bytes data.
+		// The new keyword used to allocate memory for a new value of a specified type. It
+		// allocates memory for a new bytes.Buffer value and initializes it with its zero value.
+		//The buf variable is declared to hold a pointer to a bytes.Buffer object.
+		buf := new(bytes.Buffer)
+		// ReadFrom reads data from r until EOF or error. The return value n is the number of bytes read. The data is read into buf.
+		nRead, _ := buf.ReadFrom(out)
+		// Close closes the buffer.
+		out.Close()
+		// output is the string representation of the buffer
+		output := buf.String()
+		// the loop continuously checks the Docker logs of the created container for the message "Running kubelet"
+		// if the message is found, the loop is broken
+		if nRead > 0 && strings.Contains(string(output), "Running kubelet") {
+			return nil
+		}
+		//delay for one second and try again (unless we're done waiting)
+		select {
+		case <-ctx.Done():
+			return abortError(ctx, ctx.Err())
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+// abortError returns ErrTimeout if the deadline of ctx exceeded, a cancellation error if ctx was canceled
+// and err otherwise.
+func abortError(ctx context.Context, err error) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return ErrTimeout
+	case context.Canceled:
+		return fmt.Errorf("cluster creation aborted: %w", ctx.Err())
+	}
+	return err
+}
+
+// rollbackTimeout limits the time for removing a partially created cluster
+const rollbackTimeout = 1 * time.Minute
+
+// rollbackCluster unwinds the journal of a partially created cluster. It runs with its own context,
+// since the context of the creation might have been canceled already, and reports everything that was rolled back.
+func rollbackCluster(rt Runtime, j *journal, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+
+	log.Infof("Rolling back cluster [%s]", name)
+	rolledBack := j.unwind(ctx, rt)
+	if len(rolledBack) == 0 {
+		log.Infof("Nothing to roll back for cluster [%s]", name)
+		return
+	}
+	log.Infof("Rolled back cluster [%s]:", name)
+	for _, resource := range rolledBack {
+		log.Infof("...%s", resource)
+	}
+}
+
+// GetCluster returns the cluster with the given name
+func (k *Client) GetCluster(ctx context.Context, name string) (*Cluster, error) {
+	clusters, err := getClusters(ctx, k.rt, false, name)
+	if err != nil {
+		return nil, err
+	}
+	cluster, ok := clusters[name]
+	if !ok {
+		return nil, fmt.Errorf("Cluster %s does not exist: %w", name, ErrClusterNotFound)
+	}
+	return &cluster, nil
+}
+
+// ListClusters returns all clusters, sorted by name
+func (k *Client) ListClusters(ctx context.Context) ([]Cluster, error) {
+	clusters, err := getClusters(ctx, k.rt, true, "")
+	if err != nil {
+		return nil, err
+	}
+	list := []Cluster{}
+	for _, cluster := range clusters {
+		list = append(list, cluster)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// DeleteOptions modify the deletion of a cluster
+type DeleteOptions struct {
+	// KeepVolumes keeps the data volumes of the nodes, so that a cluster with the same name can reuse them
+	KeepVolumes bool
+}
+
+// DeleteCluster removes the containers, the data volumes, the network and the directory of a cluster
+func (k *Client) DeleteCluster(ctx context.Context, name string) error {
+	return k.DeleteClusterWithOptions(ctx, name, DeleteOptions{})
+}
+
+// DeleteClusterWithOptions removes a cluster like DeleteCluster, but allows to keep the data volumes
+func (k *Client) DeleteClusterWithOptions(ctx context.Context, name string, opts DeleteOptions) error {
+	cluster, err := k.GetCluster(ctx, name)
+	if err != nil {
+		return err
+	}
+	rt := k.rt
+
+	log.Infof("Removing cluster [%s]", cluster.Name)
+	// first delete workder node
+	if len(cluster.Workers) > 0 {
+		log.Infof("...Removing %d workers", len(cluster.Workers))
+		// iterate over all the worker node and delete each one
+		for _, worker := range cluster.Workers {
+			//removeContainer defined in container.go used to deleteContianer
+			if err := removeContainer(ctx, rt, worker.ID); err != nil {
+				log.Warn(err)
+				continue
+			}
+		}
+	}
+	//now remove the k3d server
+	log.Info("...Removing server")
+	//directory
+	deleteClusterDir(cluster.Name)
+	if err := removeContainer(ctx, rt, cluster.Server.ID); err != nil {
+		return fmt.Errorf("Couldn't remove server for cluster %s\n%+v", cluster.Name, err)
+	}
+
+	// named volumes aren't removed along with the containers, neither are the volumes adopted by upgraded nodes
+	if opts.KeepVolumes {
+		log.Info("...Keeping data volumes")
+	} else {
+		log.Info("...Removing data volumes")
+		if err := deleteClusterVolumes(ctx, rt, cluster.Name); err != nil {
+			log.Warn(err)
+		}
+		for _, node := range append([]Node{cluster.Server}, cluster.Workers...) {
+			removeAdoptedVolumes(ctx, rt, node)
+		}
+	}
+
+	// deleting the cluster network
+	log.Info("...Removing cluster network")
+	if err := deleteClusterNetwork(ctx, rt, cluster.Name); err != nil {
+		log.Warnf("couldn't delete cluster network for cluster %s: %+v", cluster.Name, err)
+	}
+
+	log.Infof("Removed cluster [%s]", cluster.Name)
+	forgetSecrets(cluster.Name)
+	return nil
+}
+
+// StopCluster stops the containers of a running cluster (restartable)
+func (k *Client) StopCluster(ctx context.Context, name string) error {
+	cluster, err := k.GetCluster(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Stopping cluster [%s]", cluster.Name)
+	// handle workers
+	if len(cluster.Workers) > 0 {
+		log.Infof("...Stopping %d workers", len(cluster.Workers))
+		for _, worker := range cluster.Workers {
+			if err := k.rt.StopContainer(ctx, worker.ID); err != nil {
+				log.Warn(err)
+				continue
+			}
+		}
+	}
+	log.Info("...Stopping server")
+	//now stop the server
+	if err := k.rt.StopContainer(ctx, cluster.Server.ID); err != nil {
+		return fmt.Errorf("Couldn't stop server for cluster %s\n%+v", cluster.Name, err)
+	}
+
+	log.Infof("Stopped cluster [%s]", cluster.Name)
+	return nil
+}
+
+// StartCluster starts the containers of a stopped cluster
+func (k *Client) StartCluster(ctx context.Context, name string) error {
+	cluster, err := k.GetCluster(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Starting cluster [%s]", cluster.Name)
+
+	log.Info("...Starting server")
+	// first start the server container
+	if err := k.rt.StartContainer(ctx, cluster.Server.ID); err != nil {
+		return fmt.Errorf("Couldn't start server for cluster %s\n%+v", cluster.Name, err)
+	}
+
+	//if any worker node start them
+	if len(cluster.Workers) > 0 {
+		log.Infof("...Starting %d workers", len(cluster.Workers))
+		for _, worker := range cluster.Workers {
+			if err := k.rt.StartContainer(ctx, worker.ID); err != nil {
+				log.Warn(err)
+				continue
+			}
+		}
+	}
+	log.Infof("Started cluster [%s]", cluster.Name)
+	return nil
+}
+
+// GetKubeconfig returns the path of the kubeconfig file of a cluster, which is created on first use
+func (k *Client) GetKubeconfig(ctx context.Context, name string) (string, error) {
+	return getKubeConfig(ctx, k.rt, name)
+}
+
+// ImportImage saves an image from the local docker daemon and imports it into all nodes of a cluster
+func (k *Client) ImportImage(ctx context.Context, name, image string) error {
+	return importImage(ctx, k.rt, name, image)
+}
@@ -0,0 +1,261 @@
+package k3d
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/mitchellh/go-homedir"
+)
+
+// newTestClient returns a client on an empty FakeRuntime. The cluster directories are created in a temporary home directory.
+func newTestClient(t *testing.T) (*Client, *FakeRuntime) {
+	t.Helper()
+	homedir.DisableCache = true
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONTEXT", "")
+	t.Setenv("DOCKER_MACHINE_NAME", "")
+	rt := NewFakeRuntime()
+	return NewClient(rt), rt
+}
+
+// tarFile returns a tar archive containing a single file
+func tarFile(t *testing.T, name string, content []byte) io.Reader {
+	t.Helper()
+	buffer := &bytes.Buffer{}
+	tarWriter := tar.NewWriter(buffer)
+	if err := writeTarEntry(tarWriter, name, int64(len(content)), bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer
+}
+
+// clusterDirExists checks if the directory of a cluster exists in the home directory of the test
+func clusterDirExists(t *testing.T, name string) bool {
+	t.Helper()
+	clusterDir, err := getClusterDir(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(clusterDir)
+	return err == nil
+}
+
+func TestFakeRuntimeCopyRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	rt := NewFakeRuntime()
+	ID, err := rt.CreateContainer(ctx, "node", &container.Config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.CopyToContainer(ctx, ID, "/etc", tarFile(t, "rancher/node/password", []byte("secret"))); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(rt.Files[ID]["/etc/rancher/node/password"]); got != "secret" {
+		t.Fatalf("extracted file = %q, want %q", got, "secret")
+	}
+
+	// directories are archived under their base name, like docker does
+	reader, err := rt.CopyFromContainer(ctx, ID, "/etc/rancher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	tarReader := tar.NewReader(reader)
+	header, err := tarReader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Name != "rancher/node/password" {
+		t.Fatalf("archived name = %q, want %q", header.Name, "rancher/node/password")
+	}
+	if content, _ := io.ReadAll(tarReader); string(content) != "secret" {
+		t.Fatalf("archived content = %q, want %q", content, "secret")
+	}
+
+	if _, err := rt.CopyFromContainer(ctx, ID, "/etc/missing"); err == nil {
+		t.Fatal("copying a missing file succeeded")
+	}
+}
+
+func TestCreateCluster(t *testing.T) {
+	ctx := context.Background()
+	k, rt := newTestClient(t)
+
+	cluster, err := k.CreateCluster(ctx, &ClusterSpec{Name: "test", Workers: 2, Wait: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Status != "running" {
+		t.Errorf("status = %q, want running", cluster.Status)
+	}
+	if cluster.Server.Name != "k3d-test-server" {
+		t.Errorf("server = %q, want k3d-test-server", cluster.Server.Name)
+	}
+	if len(cluster.Workers) != 2 {
+		t.Fatalf("%d workers, want 2", len(cluster.Workers))
+	}
+	networks, _ := rt.ListNetworks(ctx, map[string]string{"app": "k3d", "cluster": "test"})
+	if len(networks) != 1 {
+		t.Errorf("%d cluster networks, want 1", len(networks))
+	}
+	if !clusterDirExists(t, "test") {
+		t.Error("cluster directory wasn't created")
+	}
+	if spec, err := readClusterSpec("test"); err != nil {
+		t.Error(err)
+	} else if spec.Token == "" {
+		t.Error("the generated token wasn't stored in the spec")
+	}
+
+	// k3s writes the kubeconfig into the server, k3d copies it out of there and replaces the host of the API server
+	kubeconfig := []byte("clusters:\n- cluster:\n    server: https://127.0.0.1:6443\n")
+	if err := rt.CopyToContainer(ctx, cluster.Server.ID, "/output", tarFile(t, "kubeconfig.yaml", kubeconfig)); err != nil {
+		t.Fatal(err)
+	}
+	kubeconfigPath, err := k.GetKubeconfig(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(kubeconfigPath); err != nil {
+		t.Error(err)
+	} else if want := "clusters:\n- cluster:\n    server: https://localhost:6443\n"; string(content) != want {
+		t.Errorf("kubeconfig = %q, want %q", content, want)
+	}
+
+	if _, err := k.CreateCluster(ctx, &ClusterSpec{Name: "test"}); !errors.Is(err, ErrClusterExists) {
+		t.Errorf("creating the cluster again returned %v, want %v", err, ErrClusterExists)
+	}
+}
+
+func TestGetKubeconfigReplacesAPIHost(t *testing.T) {
+	tests := []struct {
+		apiPort string
+		host    string
+	}{
+		{apiPort: "", host: "localhost"},
+		{apiPort: "6550", host: "localhost"},
+		{apiPort: "127.0.0.2:6550", host: "127.0.0.2"},
+		{apiPort: "[::1]:6550", host: "[::1]"},
+		{apiPort: "[fd00::1]:6550", host: "[fd00::1]"},
+	}
+	for _, test := range tests {
+		ctx := context.Background()
+		k, rt := newTestClient(t)
+		cluster, err := k.CreateCluster(ctx, &ClusterSpec{Name: "test", APIPort: test.apiPort})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// k3s writes the server URL with the API port, either with an IPv4 or an IPv6 address
+		for _, server := range []string{"https://127.0.0.1:6550", "https://[::1]:6550"} {
+			kubeconfig := []byte("clusters:\n- cluster:\n    server: " + server + "\n")
+			if err := rt.CopyToContainer(ctx, cluster.Server.ID, "/output", tarFile(t, "kubeconfig.yaml", kubeconfig)); err != nil {
+				t.Fatal(err)
+			}
+			if err := createKubeConfigFile(ctx, rt, "test"); err != nil {
+				t.Fatal(err)
+			}
+			kubeconfigPath, err := getClusterKubeConfigPath("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "clusters:\n- cluster:\n    server: https://" + test.host + ":6550\n"
+			if content, err := os.ReadFile(kubeconfigPath); err != nil {
+				t.Error(err)
+			} else if string(content) != want {
+				t.Errorf("kubeconfig with --api-port %q and server %s = %q, want %q", test.apiPort, server, content, want)
+			}
+		}
+	}
+}
+
+func TestDeleteCluster(t *testing.T) {
+	ctx := context.Background()
+	k, rt := newTestClient(t)
+
+	if _, err := k.CreateCluster(ctx, &ClusterSpec{Name: "test", Workers: 1, DataVolumes: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.DeleteCluster(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := k.GetCluster(ctx, "test"); !errors.Is(err, ErrClusterNotFound) {
+		t.Errorf("getting the deleted cluster returned %v, want %v", err, ErrClusterNotFound)
+	}
+	if containers, _ := rt.ListContainers(ctx, true, nil); len(containers) != 0 {
+		t.Errorf("%d containers left", len(containers))
+	}
+	if networks, _ := rt.ListNetworks(ctx, nil); len(networks) != 0 {
+		t.Errorf("%d networks left", len(networks))
+	}
+	if volumes, _ := rt.ListVolumes(ctx, nil); len(volumes) != 0 {
+		t.Errorf("%d volumes left", len(volumes))
+	}
+	if clusterDirExists(t, "test") {
+		t.Error("cluster directory wasn't deleted")
+	}
+	if err := k.DeleteCluster(ctx, "test"); !errors.Is(err, ErrClusterNotFound) {
+		t.Errorf("deleting the cluster again returned %v, want %v", err, ErrClusterNotFound)
+	}
+}
+
+func TestCreateClusterRollback(t *testing.T) {
+	ctx := context.Background()
+	k, rt := newTestClient(t)
+
+	// a foreign container blocks the name of the second worker, so the creation fails after the server was created
+	blockingID, err := rt.CreateContainer(ctx, GetContainerName("worker", "test", 1), &container.Config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the anonymous volumes of the foreign container stay
+	foreignVolumes, _ := rt.ListVolumes(ctx, nil)
+
+	_, err = k.CreateCluster(ctx, &ClusterSpec{Name: "test", Workers: 2, DataVolumes: true})
+	if err == nil || !strings.Contains(err.Error(), "already in use") {
+		t.Fatalf("creation returned %v, want an error about the name in use", err)
+	}
+
+	containers, _ := rt.ListContainers(ctx, true, nil)
+	if len(containers) != 1 || containers[0].ID != blockingID {
+		t.Errorf("containers left after the rollback: %+v", containers)
+	}
+	if networks, _ := rt.ListNetworks(ctx, nil); len(networks) != 0 {
+		t.Errorf("%d networks left after the rollback", len(networks))
+	}
+	if volumes, _ := rt.ListVolumes(ctx, nil); len(volumes) != len(foreignVolumes) {
+		t.Errorf("%d volumes left after the rollback", len(volumes)-len(foreignVolumes))
+	}
+	if clusterDirExists(t, "test") {
+		t.Error("cluster directory wasn't removed by the rollback")
+	}
+}
+
+func TestCreateClusterValidatesBeforeCreatingResources(t *testing.T) {
+	ctx := context.Background()
+	k, rt := newTestClient(t)
+
+	if _, err := k.CreateCluster(ctx, &ClusterSpec{Name: "test", Disable: []string{"nonexistent"}}); err == nil {
+		t.Fatal("creation with an invalid component to disable succeeded")
+	}
+	if containers, _ := rt.ListContainers(ctx, true, nil); len(containers) != 0 {
+		t.Errorf("%d containers created", len(containers))
+	}
+	if networks, _ := rt.ListNetworks(ctx, nil); len(networks) != 0 {
+		t.Errorf("%d networks created", len(networks))
+	}
+	if clusterDirExists(t, "test") {
+		t.Error("cluster directory was created")
+	}
+}
@@ -0,0 +1,227 @@
+package k3d
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	timetypes "github.com/docker/docker/api/types/time"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// logColors are the ANSI colors of the node prefixes, assigned to the nodes in turn
+var logColors = []string{"36", "33", "32", "35", "34", "31"}
+
+// LogsOptions selects the logs of a cluster (see ClusterLogs)
+type LogsOptions struct {
+	Name string
+	// Nodes are node specifiers (all, server, workers, container or short names, e.g. worker-1), default: all nodes
+	Nodes []string
+	// Follow streams new logs until the context is canceled
+	Follow bool
+	// Since only shows newer logs: a duration (e.g. 5m) or a timestamp, as in `docker logs --since`
+	Since string
+	// Tail is the number of lines shown per node ("" or "all" = all lines)
+	Tail string
+	// Color highlights the node prefixes with ANSI colors
+	Color bool
+	// Timestamps keeps the timestamps docker adds to the lines
+	Timestamps bool
+}
+
+// logLineBuffer is the number of lines read ahead per node while merging the logs
+const logLineBuffer = 256
+
+// logLine is a line of the logs of a node, with the timestamp added by docker
+type logLine struct {
+	Time      time.Time
+	Timestamp string
+	Text      string
+}
+
+// format returns the text of the line, prefixed with its timestamp if requested
+func (l logLine) format(timestamps bool) string {
+	if timestamps && l.Timestamp != "" {
+		return l.Timestamp + " " + l.Text
+	}
+	return l.Text
+}
+
+// ClusterLogs writes the logs of the selected nodes of a cluster to out, every line prefixed with the node name.
+// The logs of the nodes are merged by their timestamps, when following they're written as they come in.
+// The timestamps docker adds to the lines are only kept with options.Timestamps.
+func (k *Client) ClusterLogs(ctx context.Context, options LogsOptions, out io.Writer) error {
+	if options.Since != "" {
+		if _, err := timetypes.GetTimestamp(options.Since, time.Now()); err != nil {
+			return fmt.Errorf("Invalid value [%s] for since\n%+v", options.Since, err)
+		}
+	}
+	if options.Tail != "" && options.Tail != "all" {
+		if tail, err := strconv.Atoi(options.Tail); err != nil || tail < 0 {
+			return fmt.Errorf("Invalid value [%s] for tail, must be a number of lines or all", options.Tail)
+		}
+	}
+
+	cluster, err := k.GetCluster(ctx, options.Name)
+	if err != nil {
+		return err
+	}
+	nodes, err := selectNodes(cluster, options.Nodes)
+	if err != nil {
+		return err
+	}
+	prefixes := logPrefixes(cluster.Name, nodes, options.Color)
+
+	// every node passes its lines on through a channel, which is closed when its logs end
+	wg := sync.WaitGroup{}
+	errs := make([]error, len(nodes))
+	lines := make([]chan logLine, len(nodes))
+	for i, node := range nodes {
+		lines[i] = make(chan logLine, logLineBuffer)
+		wg.Add(1)
+		go func(i int, node Node) {
+			defer wg.Done()
+			defer close(lines[i])
+			errs[i] = readNodeLogs(ctx, k.rt, node, options, func(line logLine) { lines[i] <- line })
+		}(i, node)
+	}
+
+	if options.Follow {
+		followLogLines(prefixes, lines, options.Timestamps, out)
+	} else {
+		mergeLogLines(prefixes, lines, options.Timestamps, out)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil && options.Follow {
+		// following ends with canceling the context (e.g. Ctrl-C)
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// readNodeLogs reads the logs of a node, demultiplexing its stdout and stderr, and passes them on line by line
+func readNodeLogs(ctx context.Context, rt Runtime, node Node, options LogsOptions, handleLine func(logLine)) error {
+	stream, err := rt.GetContainerLogs(ctx, node.ID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     options.Follow,
+		Since:      options.Since,
+		Tail:       options.Tail,
+		Timestamps: true,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't get the logs of node %s\n%+v", node.Name, err)
+	}
+	defer stream.Close()
+
+	// the node containers don't have a TTY, so docker multiplexes stdout and stderr into one stream with frame headers
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	go func() {
+		_, err := stdcopy.StdCopy(writer, writer, stream)
+		writer.CloseWithError(err)
+	}()
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLogLineSize)
+	// lines without timestamp (e.g. the rest of a line split by docker) stay behind the line before them
+	previous := time.Time{}
+	for scanner.Scan() {
+		line := parseLogLine(scanner.Text())
+		if line.Time.IsZero() {
+			line.Time = previous
+		}
+		previous = line.Time
+		handleLine(line)
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("couldn't read the logs of node %s\n%+v", node.Name, err)
+	}
+	return nil
+}
+
+// maxLogLineSize is the size of the longest line of the logs of a node, longer lines end reading its logs with an error
+const maxLogLineSize = 1024 * 1024
+
+// parseLogLine splits the timestamp added by docker (RFC3339Nano) from a line. Lines without timestamp are kept as they are.
+func parseLogLine(text string) logLine {
+	timestamp, rest, _ := strings.Cut(text, " ")
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return logLine{Text: text}
+	}
+	return logLine{Time: t, Timestamp: timestamp, Text: rest}
+}
+
+// followLogLines writes the lines of the nodes as they come in, until the logs of all nodes ended
+func followLogLines(prefixes []string, lines []chan logLine, timestamps bool, out io.Writer) {
+	// lines are written by one node at a time, so that lines of different nodes don't get mixed up
+	lock := &sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for node := range lines {
+		wg.Add(1)
+		go func(node int) {
+			defer wg.Done()
+			for line := range lines[node] {
+				lock.Lock()
+				fmt.Fprintf(out, "%s%s\n", prefixes[node], line.format(timestamps))
+				lock.Unlock()
+			}
+		}(node)
+	}
+	wg.Wait()
+}
+
+// mergeLogLines merges the lines of the nodes by their timestamps, lines of the same time keep the order of the nodes.
+// The lines are merged as they're read, only the next line of every node is held back.
+func mergeLogLines(prefixes []string, lines []chan logLine, timestamps bool, out io.Writer) {
+	next := make([]*logLine, len(lines))
+	for node := range lines {
+		if line, ok := <-lines[node]; ok {
+			next[node] = &line
+		}
+	}
+	for {
+		earliest := -1
+		for node, line := range next {
+			if line != nil && (earliest < 0 || line.Time.Before(next[earliest].Time)) {
+				earliest = node
+			}
+		}
+		if earliest < 0 {
+			return
+		}
+		fmt.Fprintf(out, "%s%s\n", prefixes[earliest], next[earliest].format(timestamps))
+		if line, ok := <-lines[earliest]; ok {
+			next[earliest] = &line
+		} else {
+			next[earliest] = nil
+		}
+	}
+}
+
+// logPrefixes returns the prefixes of the lines of the nodes: their short names (e.g. worker-0), padded to the same width
+func logPrefixes(clusterName string, nodes []Node, color bool) []string {
+	width := 0
+	for _, node := range nodes {
+		if name := nodeShortName(clusterName, node.Name); len(name) > width {
+			width = len(name)
+		}
+	}
+	prefixes := []string{}
+	for i, node := range nodes {
+		name := fmt.Sprintf("%-*s", width, nodeShortName(clusterName, node.Name))
+		if color {
+			name = fmt.Sprintf("\x1b[%sm%s\x1b[0m", logColors[i%len(logColors)], name)
+		}
+		prefixes = append(prefixes, name+" | ")
+	}
+	return prefixes
+}
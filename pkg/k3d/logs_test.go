@@ -0,0 +1,161 @@
+package k3d
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newLogsTestCluster creates the cluster test with a server and a worker, which log the given lines
+func newLogsTestCluster(t *testing.T, serverLogs, workerLogs string) *Client {
+	t.Helper()
+	k, rt := newTestClient(t)
+	if _, err := k.CreateCluster(context.Background(), &ClusterSpec{Name: "test", Workers: 1, Wait: true}); err != nil {
+		t.Fatal(err)
+	}
+	rt.ContainerLogs = map[string]string{
+		"k3d-test-server":   serverLogs,
+		"k3d-test-worker-0": workerLogs,
+	}
+	return k
+}
+
+func TestClusterLogs(t *testing.T) {
+	serverLogs := "2024-01-02T13:23:37.000000001Z starting server\n" +
+		"2024-01-02T13:23:39Z server ready\n" +
+		"continued without timestamp\n" +
+		"2024-01-02T13:23:41Z server done\n"
+	workerLogs := "2024-01-02T13:23:38Z starting worker\n" +
+		"2024-01-02T13:23:39Z worker ready\n" +
+		"2024-01-02T13:23:40Z worker done\n"
+
+	tests := []struct {
+		name    string
+		options LogsOptions
+		want    string
+	}{
+		{
+			name:    "merged by timestamps",
+			options: LogsOptions{Name: "test"},
+			want: "server   | starting server\n" +
+				"worker-0 | starting worker\n" +
+				"server   | server ready\n" +
+				"server   | continued without timestamp\n" +
+				"worker-0 | worker ready\n" +
+				"worker-0 | worker done\n" +
+				"server   | server done\n",
+		},
+		{
+			name:    "timestamps",
+			options: LogsOptions{Name: "test", Timestamps: true},
+			want: "server   | 2024-01-02T13:23:37.000000001Z starting server\n" +
+				"worker-0 | 2024-01-02T13:23:38Z starting worker\n" +
+				"server   | 2024-01-02T13:23:39Z server ready\n" +
+				"server   | continued without timestamp\n" +
+				"worker-0 | 2024-01-02T13:23:39Z worker ready\n" +
+				"worker-0 | 2024-01-02T13:23:40Z worker done\n" +
+				"server   | 2024-01-02T13:23:41Z server done\n",
+		},
+		{
+			name:    "single node",
+			options: LogsOptions{Name: "test", Nodes: []string{"workers"}},
+			want: "worker-0 | starting worker\n" +
+				"worker-0 | worker ready\n" +
+				"worker-0 | worker done\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			k := newLogsTestCluster(t, serverLogs, workerLogs)
+			out := &bytes.Buffer{}
+			if err := k.ClusterLogs(context.Background(), test.options, out); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != test.want {
+				t.Errorf("logs =\n%s\nwant\n%s", out, test.want)
+			}
+		})
+	}
+}
+
+func TestClusterLogsMergesMoreLinesThanBuffered(t *testing.T) {
+	// the server logs every second, the worker every other second, so the merge has to hold back the lines of the worker
+	start := time.Date(2024, 1, 2, 13, 23, 37, 0, time.UTC)
+	serverLogs, workerLogs, want := &strings.Builder{}, &strings.Builder{}, &strings.Builder{}
+	for second := 0; second < 4*logLineBuffer; second++ {
+		timestamp := start.Add(time.Duration(second) * time.Second).Format(time.RFC3339Nano)
+		serverLogs.WriteString(timestamp + " server\n")
+		want.WriteString("server   | server\n")
+		if second%2 == 0 {
+			workerLogs.WriteString(timestamp + " worker\n")
+			want.WriteString("worker-0 | worker\n")
+		}
+	}
+
+	k := newLogsTestCluster(t, serverLogs.String(), workerLogs.String())
+	out := &bytes.Buffer{}
+	if err := k.ClusterLogs(context.Background(), LogsOptions{Name: "test"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want.String() {
+		t.Errorf("logs aren't merged by their timestamps")
+	}
+}
+
+func TestClusterLogsFollow(t *testing.T) {
+	k := newLogsTestCluster(t,
+		"2024-01-02T13:23:39Z server ready\n2024-01-02T13:23:37Z server late\n",
+		"2024-01-02T13:23:38Z worker ready\n")
+	out := &bytes.Buffer{}
+	if err := k.ClusterLogs(context.Background(), LogsOptions{Name: "test", Follow: true}, out); err != nil {
+		t.Fatal(err)
+	}
+
+	// when following, the lines of a node are written as they come in, regardless of their timestamps
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	serverLines := []string{}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "server") {
+			serverLines = append(serverLines, line)
+		}
+	}
+	if len(lines) != 3 || len(serverLines) != 2 || serverLines[0] != "server   | server ready" || serverLines[1] != "server   | server late" {
+		t.Errorf("followed logs = %q", lines)
+	}
+}
+
+func TestClusterLogsLineTooLong(t *testing.T) {
+	k := newLogsTestCluster(t,
+		"2024-01-02T13:23:37Z "+strings.Repeat("x", maxLogLineSize)+"\n",
+		"2024-01-02T13:23:38Z worker ready\n")
+	out := &bytes.Buffer{}
+	err := k.ClusterLogs(context.Background(), LogsOptions{Name: "test"}, out)
+	if err == nil || !strings.Contains(err.Error(), "couldn't read the logs of node k3d-test-server") {
+		t.Errorf("ClusterLogs() = %v, want an error about the logs of the server", err)
+	}
+	// the logs of the other nodes are still written
+	if out.String() != "worker-0 | worker ready\n" {
+		t.Errorf("logs = %q, want the logs of the worker", out)
+	}
+}
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		text      string
+		timestamp string
+		want      string
+	}{
+		{"2024-01-02T13:23:37.123456789Z I0102 started", "2024-01-02T13:23:37.123456789Z", "I0102 started"},
+		{"2024-01-02T13:23:37Z ", "2024-01-02T13:23:37Z", ""},
+		{"no timestamp here", "", "no timestamp here"},
+		{"2024-01-02 13:23:37 not RFC3339", "", "2024-01-02 13:23:37 not RFC3339"},
+	}
+	for _, test := range tests {
+		line := parseLogLine(test.text)
+		if line.Timestamp != test.timestamp || line.Text != test.want {
+			t.Errorf("parseLogLine(%q) = %q, %q, want %q, %q", test.text, line.Timestamp, line.Text, test.timestamp, test.want)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package k3d
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// k3sManifestsDir is the directory from which k3s applies manifests automatically (auto-deploying manifests)
+const k3sManifestsDir = "/var/lib/rancher/k3s/server/manifests"
+
+// prefixes of the files k3d adds to the manifests directory, so that they don't replace the manifests k3s ships
+// (e.g. traefik.yaml or coredns.yaml)
+const (
+	manifestFilePrefix  = "k3d-manifest-"
+	helmChartFilePrefix = "k3d-helm-chart-"
+)
+
+// namespaceRegexp matches valid Kubernetes namespaces: RFC 1123 DNS labels (lowercase alphanumerics and '-', at most 63 characters)
+var namespaceRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// manifestFileExtensions are the extensions of the files taken from manifest directories
+var manifestFileExtensions = []string{".yaml", ".yml", ".json"}
+
+// manifestFile is a file that's copied into the manifests directory of the server
+type manifestFile struct {
+	Name    string
+	Content []byte
+}
+
+// helmChart is a HelmChart custom resource, which is deployed by the helm controller of k3s
+type helmChart struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"metadata"`
+	Spec struct {
+		Repo            string `yaml:"repo,omitempty"`
+		Chart           string `yaml:"chart"`
+		Version         string `yaml:"version,omitempty"`
+		TargetNamespace string `yaml:"targetNamespace"`
+		CreateNamespace bool   `yaml:"createNamespace"`
+		ValuesContent   string `yaml:"valuesContent,omitempty"`
+	} `yaml:"spec"`
+}
+
+// helmRepositoriesFile is the part of the repositories file of helm (`helm repo add`) needed to resolve repository names
+type helmRepositoriesFile struct {
+	Repositories []struct {
+		Name string `yaml:"name"`
+		URL  string `yaml:"url"`
+	} `yaml:"repositories"`
+}
+
+// loadManifests reads the manifest files (files or directories) and generates HelmChart manifests for the helm charts.
+// The files are prefixed (see manifestFilePrefix and helmChartFilePrefix), so that they don't replace the manifests of k3s.
+// It fails if two manifests have the same file name, since one would silently replace the other.
+func loadManifests(manifests, helmCharts []string) ([]manifestFile, error) {
+	files := []manifestFile{}
+	names := map[string]string{}
+	add := func(file manifestFile, source string) error {
+		if other, ok := names[file.Name]; ok {
+			return fmt.Errorf("manifests %s and %s have the same file name %s", other, source, file.Name)
+		}
+		names[file.Name] = source
+		files = append(files, file)
+		return nil
+	}
+
+	for _, manifest := range manifests {
+		paths, err := manifestPaths(manifest)
+		if err != nil {
+			return nil, err
+		}
+		for _, manifestPath := range paths {
+			content, err := os.ReadFile(manifestPath)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't read manifest %s\n%+v", manifestPath, err)
+			}
+			if err := add(manifestFile{Name: manifestFilePrefix + filepath.Base(manifestPath), Content: content}, manifestPath); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, chart := range helmCharts {
+		content, name, err := helmChartManifest(chart)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(manifestFile{Name: fmt.Sprintf("%s%s.yaml", helmChartFilePrefix, name), Content: content}, chart); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// manifestPaths returns the manifest itself, if it's a file, or the manifest files in it, if it's a directory
+func manifestPaths(manifest string) ([]string, error) {
+	info, err := os.Stat(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read manifest %s\n%+v", manifest, err)
+	}
+	if !info.IsDir() {
+		return []string{manifest}, nil
+	}
+
+	entries, err := os.ReadDir(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read manifest directory %s\n%+v", manifest, err)
+	}
+	paths := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		for _, extension := range manifestFileExtensions {
+			if strings.HasSuffix(entry.Name(), extension) {
+				paths = append(paths, filepath.Join(manifest, entry.Name()))
+				break
+			}
+		}
+	}
+	if len(paths) == 0 {
+		log.Warnf("Manifest directory %s doesn't contain any manifests (%s)", manifest, strings.Join(manifestFileExtensions, ", "))
+	}
+	return paths, nil
+}
+
+// helmChartManifest generates a HelmChart manifest from a chart spec `name=repo/chart[@version][,values.yaml]`.
+// repo is either the URL of a chart repository or the name of a repository added with `helm repo add`.
+// OCI charts are given as `name=oci://registry/path/chart[@version]`. The chart is installed into the namespace `name`.
+// The version follows the last '@' after the last '/', so that repository URLs may contain a user (https://user@host/...).
+// It returns the manifest and the name of the chart.
+func helmChartManifest(spec string) ([]byte, string, error) {
+	nameAndRef := strings.SplitN(spec, "=", 2)
+	if len(nameAndRef) != 2 {
+		return nil, "", fmt.Errorf("Invalid helm chart [%s], expected name=repo/chart[@version][,values.yaml]", spec)
+	}
+	name := nameAndRef[0]
+	if !namespaceRegexp.MatchString(name) {
+		return nil, "", fmt.Errorf("Invalid name of helm chart [%s], it's the namespace of the chart, so it must consist of at most 63 lowercase alphanumeric characters or '-', starting and ending with an alphanumeric character", spec)
+	}
+
+	ref, valuesFile, _ := strings.Cut(nameAndRef[1], ",")
+	version := ""
+	if at := strings.LastIndex(ref, "@"); at > strings.LastIndex(ref, "/") {
+		ref, version = ref[:at], ref[at+1:]
+	}
+
+	chart := &helmChart{APIVersion: "helm.cattle.io/v1", Kind: "HelmChart"}
+	chart.Metadata.Name = name
+	chart.Metadata.Namespace = "kube-system"
+	chart.Spec.Version = version
+	chart.Spec.TargetNamespace = name
+	chart.Spec.CreateNamespace = true
+
+	if strings.HasPrefix(ref, "oci://") {
+		chart.Spec.Chart = ref
+	} else {
+		separator := strings.LastIndex(ref, "/")
+		if separator <= 0 || separator == len(ref)-1 {
+			return nil, "", fmt.Errorf("Invalid helm chart [%s], expected name=repo/chart[@version][,values.yaml]", spec)
+		}
+		repo, err := resolveHelmRepository(ref[:separator])
+		if err != nil {
+			return nil, "", fmt.Errorf("Invalid helm chart [%s]\n%+v", spec, err)
+		}
+		chart.Spec.Repo = repo
+		chart.Spec.Chart = ref[separator+1:]
+	}
+
+	if valuesFile != "" {
+		values, err := os.ReadFile(valuesFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("couldn't read values of helm chart [%s]\n%+v", spec, err)
+		}
+		chart.Spec.ValuesContent = string(values)
+	}
+
+	content := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(content)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(chart); err != nil {
+		return nil, "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, "", err
+	}
+	return content.Bytes(), name, nil
+}
+
+// resolveHelmRepository returns the URL of a chart repository, which is either given as URL
+// or as name of a repository in the repositories file of helm
+func resolveHelmRepository(repo string) (string, error) {
+	if strings.HasPrefix(repo, "https://") || strings.HasPrefix(repo, "http://") {
+		return repo, nil
+	}
+
+	repositoriesPath, err := helmRepositoriesPath()
+	if err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(repositoriesPath)
+	if err != nil {
+		return "", fmt.Errorf("repository [%s] is not a URL and the helm repositories couldn't be read\n%+v", repo, err)
+	}
+	repositories := helmRepositoriesFile{}
+	if err := yaml.Unmarshal(content, &repositories); err != nil {
+		return "", fmt.Errorf("couldn't parse helm repositories %s\n%+v", repositoriesPath, err)
+	}
+	for _, repository := range repositories.Repositories {
+		if repository.Name == repo {
+			return repository.URL, nil
+		}
+	}
+	return "", fmt.Errorf("unknown helm repository [%s], use its URL or add it with `helm repo add`", repo)
+}
+
+// helmRepositoriesPath returns the path of the repositories file of helm ($HELM_REPOSITORY_CONFIG or the default of the platform)
+func helmRepositoriesPath() (string, error) {
+	if repositoriesPath := os.Getenv("HELM_REPOSITORY_CONFIG"); repositoriesPath != "" {
+		return repositoriesPath, nil
+	}
+	if runtime.GOOS == "darwin" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, "Library", "Preferences", "helm", "repositories.yaml"), nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "helm", "repositories.yaml"), nil
+}
+
+// copyManifests copies the manifests into the manifests directory of the (not yet started) server container
+func copyManifests(ctx context.Context, rt Runtime, ID string, manifests []manifestFile) error {
+	if len(manifests) == 0 {
+		return nil
+	}
+	buffer := &bytes.Buffer{}
+	tarWriter := tar.NewWriter(buffer)
+	dir := strings.TrimPrefix(k3sManifestsDir, "/")
+	// the parent directories are created by docker, but they must be accessible by k3s
+	if err := tarWriter.WriteHeader(&tar.Header{Name: dir + "/", Typeflag: tar.TypeDir, Mode: 0700, ModTime: time.Now()}); err != nil {
+		return err
+	}
+	for _, manifest := range manifests {
+		log.Debugf("Adding manifest %s", manifest.Name)
+		if err := writeTarEntry(tarWriter, path.Join(dir, manifest.Name), int64(len(manifest.Content)), bytes.NewReader(manifest.Content)); err != nil {
+			return err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	if err := rt.CopyToContainer(ctx, ID, "/", buffer); err != nil {
+		return fmt.Errorf("couldn't copy manifests into the server\n%+v", err)
+	}
+	return nil
+}
+
+// beforeServerStart returns a function which restores the data of the server (see restoreNodeData), copies
+// the manifests and, with data volumes, the token into it before it's started, or nil if there's nothing to copy
+func (spec *clusterConfig) beforeServerStart(ctx context.Context, rt Runtime, containerName string) func(ID string) error {
+	restore := spec.restoreNodeData(ctx, rt, containerName)
+	if restore == nil && len(spec.Manifests) == 0 && !spec.DataVolumes {
+		return nil
+	}
+	return func(ID string) error {
+		if restore != nil {
+			if err := restore(ID); err != nil {
+				return err
+			}
+		}
+		if spec.DataVolumes {
+			if err := copyClusterToken(ctx, rt, ID, spec.Token); err != nil {
+				return err
+			}
+		}
+		return copyManifests(ctx, rt, ID, spec.Manifests)
+	}
+}
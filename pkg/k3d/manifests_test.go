@@ -0,0 +1,106 @@
+package k3d
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestLoadManifestsArePrefixed(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"traefik.yaml", "app.json", "README.md"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("kind: ConfigMap\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := loadManifests([]string{dir}, []string{"traefik=https://traefik.github.io/charts/traefik"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	// the manifests must not replace the ones k3s ships, e.g. traefik.yaml
+	want := []string{"k3d-manifest-app.json", "k3d-manifest-traefik.yaml", "k3d-helm-chart-traefik.yaml"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("manifest files = %v, want %v", names, want)
+	}
+
+	if _, err := loadManifests([]string{dir, filepath.Join(dir, "app.json")}, nil); err == nil {
+		t.Error("loading two manifests with the same file name succeeded")
+	}
+}
+
+func TestHelmChartManifest(t *testing.T) {
+	dir := t.TempDir()
+	valuesFile := filepath.Join(dir, "values.yaml")
+	if err := os.WriteFile(valuesFile, []byte("replicas: 2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	repositories := filepath.Join(dir, "repositories.yaml")
+	if err := os.WriteFile(repositories, []byte("repositories:\n- name: jetstack\n  url: https://charts.jetstack.io\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HELM_REPOSITORY_CONFIG", repositories)
+
+	for _, test := range []struct {
+		spec    string
+		repo    string
+		chart   string
+		version string
+		values  string
+	}{
+		{spec: "cert-manager=https://charts.jetstack.io/cert-manager", repo: "https://charts.jetstack.io", chart: "cert-manager"},
+		{spec: "cert-manager=https://charts.jetstack.io/cert-manager@v1.14.4," + valuesFile, repo: "https://charts.jetstack.io", chart: "cert-manager", version: "v1.14.4", values: "replicas: 2\n"},
+		{spec: "cert-manager=jetstack/cert-manager@v1.14.4", repo: "https://charts.jetstack.io", chart: "cert-manager", version: "v1.14.4"},
+		{spec: "podinfo=oci://ghcr.io/stefanprodan/charts/podinfo@6.5.4", chart: "oci://ghcr.io/stefanprodan/charts/podinfo", version: "6.5.4"},
+		{spec: "podinfo=oci://ghcr.io/stefanprodan/charts/podinfo", chart: "oci://ghcr.io/stefanprodan/charts/podinfo"},
+		{spec: "podinfo=oci://user@registry.local:5000/charts/podinfo@6.5.4", chart: "oci://user@registry.local:5000/charts/podinfo", version: "6.5.4"},
+		// repository URLs may contain a user
+		{spec: "private=https://user@charts.example.com/stable/app", repo: "https://user@charts.example.com/stable", chart: "app"},
+		{spec: "private=https://user@charts.example.com/stable/app@1.2.3", repo: "https://user@charts.example.com/stable", chart: "app", version: "1.2.3"},
+		{spec: strings.Repeat("a", 63) + "=jetstack/cert-manager", repo: "https://charts.jetstack.io", chart: "cert-manager"},
+	} {
+		content, name, err := helmChartManifest(test.spec)
+		if err != nil {
+			t.Errorf("helmChartManifest(%q) failed: %+v", test.spec, err)
+			continue
+		}
+		chart := helmChart{}
+		if err := yaml.Unmarshal(content, &chart); err != nil {
+			t.Fatal(err)
+		}
+		wantName, _, _ := strings.Cut(test.spec, "=")
+		if name != wantName || chart.Metadata.Name != wantName || chart.Spec.TargetNamespace != wantName || chart.Metadata.Namespace != "kube-system" {
+			t.Errorf("helmChartManifest(%q) named %q: %+v", test.spec, name, chart)
+		}
+		if chart.Spec.Repo != test.repo || chart.Spec.Chart != test.chart || chart.Spec.Version != test.version || chart.Spec.ValuesContent != test.values {
+			t.Errorf("helmChartManifest(%q) = %+v, want repo %q, chart %q, version %q, values %q",
+				test.spec, chart.Spec, test.repo, test.chart, test.version, test.values)
+		}
+	}
+
+	for _, spec := range []string{
+		"https://charts.jetstack.io/cert-manager",
+		"Cert_Manager=https://charts.jetstack.io/cert-manager",
+		// the name is the namespace of the chart, so it has to be a lowercase DNS label
+		"CertManager=https://charts.jetstack.io/cert-manager",
+		"-cert-manager=https://charts.jetstack.io/cert-manager",
+		"cert-manager-=https://charts.jetstack.io/cert-manager",
+		"=https://charts.jetstack.io/cert-manager",
+		strings.Repeat("a", 64) + "=https://charts.jetstack.io/cert-manager",
+		"cert-manager=cert-manager",
+		"cert-manager=https://charts.jetstack.io/",
+		"cert-manager=unknown/cert-manager",
+		"cert-manager=https://charts.jetstack.io/cert-manager," + filepath.Join(dir, "missing.yaml"),
+	} {
+		if _, _, err := helmChartManifest(spec); err == nil {
+			t.Errorf("helmChartManifest(%q) succeeded", spec)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package k3d
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net"
+
+	"github.com/docker/docker/api/types/network"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/docker/docker/api/types"
+)
+
+// add k3d prefix to every container name
+func k3dNetworkName(clusterName string) string {
+	return fmt.Sprintf("k3d-%s", clusterName)
+}
+
+// clusterIPv6Subnet derives a unique local IPv6 subnet (RFC 4193) for the cluster network from the cluster name,
+// so that IPv6 enabled networks of different clusters don't collide.
+// fd00::/8 prefix + 40 bit global ID (taken from the hash of the cluster name) + 16 bit subnet ID (0) = /64 subnet
+func clusterIPv6Subnet(clusterName string) string {
+	sum := sha256.Sum256([]byte(clusterName))
+	return fmt.Sprintf("fd%02x:%02x%02x:%02x%02x::/64", sum[0], sum[1], sum[2], sum[3], sum[4])
+}
+
+// createClusterNetwork creates the bridge network of the cluster. If enableIPv6 is set, the network gets an
+// additional IPv6 subnet next to the IPv4 one (docker always assigns an IPv4 subnet).
+// It returns the network ID and whether the network was created (false if it existed already).
+func createClusterNetwork(ctx context.Context, rt Runtime, clusterName string, enableIPv6 bool) (string, bool, error) {
+	// check if there is any netork found. if found take the first one
+	// ListNetworks returns the list of networks with the labels app=k3d and cluster=<clusterName>
+	nl, err := rt.ListNetworks(ctx, map[string]string{"app": "k3d", "cluster": clusterName})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to list networks\n%+v", err)
+	}
+
+	if len(nl) > 1 {
+		log.Warnf("Found %d networks for %s when we only expect 1", len(nl), clusterName)
+	}
+
+	// if any network found return the first one
+	if len(nl) > 0 {
+		return nl[0].ID, false, nil
+	}
+
+	// resp: containens the info about the newly created network, such as its ID, name, and configuration.
+	// create the network with a set of labels and the cluster name as network name
+	networkCreate := types.NetworkCreate{
+		// "app": "k3d": indicates that the network is associated with the "k3d" application.
+		// "cluster" : clusterName: indicates the name of the network
+		Labels: map[string]string{
+			"app":     "k3d",
+			"cluster": clusterName,
+		},
+	}
+	// docker needs an explicit IPv6 subnet, unless the daemon has a default IPv6 address pool configured
+	if enableIPv6 {
+		networkCreate.EnableIPv6 = true
+		networkCreate.IPAM = &network.IPAM{
+			Config: []network.IPAMConfig{
+				{Subnet: clusterIPv6Subnet(clusterName)},
+			},
+		}
+	}
+	networkID, err := rt.CreateNetwork(ctx, k3dNetworkName(clusterName), networkCreate)
+	if err != nil {
+		return "", false, fmt.Errorf("couldn't create network\n%+v", err)
+	}
+
+	return networkID, true, nil
+}
+
+func deleteClusterNetwork(ctx context.Context, rt Runtime, clusterName string) error {
+	//This code block performs a filtered listing of Docker networks using specific label-based criteria -->
+	// app=k3d
+	// cluster=clusterName
+	networks, err := rt.ListNetworks(ctx, map[string]string{"app": "k3d", "cluster": clusterName})
+	if err != nil {
+		return fmt.Errorf("couldn't find network for cluster %s\n%+v", clusterName, err)
+	}
+
+	for _, network := range networks {
+		// RemoveNetwork removes an existent network from the docker host.
+		if err := rt.RemoveNetwork(ctx, network.ID); err != nil {
+			log.Warnf("couldn't remove network for cluster %s: %+v", clusterName, err)
+			continue
+		}
+	}
+	return nil
+}
+
+// getClusterNetworkGateway returns the gateway IP of the cluster network, which is the address under which
+// the docker host is reachable from inside the cluster containers
+func getClusterNetworkGateway(ctx context.Context, rt Runtime, networkID string) (string, error) {
+	// InspectNetwork returns the information for a specific network configured in the docker host.
+	networkResource, err := rt.InspectNetwork(ctx, networkID)
+	if err != nil {
+		return "", fmt.Errorf("couldn't inspect network [%s]\n%+v", networkID, err)
+	}
+
+	// IPAM.Config holds one entry per subnet of the network. Prefer the IPv4 gateway, since every
+	// docker network has one, and fall back to the IPv6 gateway.
+	gateway := ""
+	for _, config := range networkResource.IPAM.Config {
+		ip := net.ParseIP(config.Gateway)
+		if ip == nil {
+			continue
+		}
+		if ip.To4() != nil {
+			return config.Gateway, nil
+		}
+		if gateway == "" {
+			gateway = config.Gateway
+		}
+	}
+	if gateway == "" {
+		return "", fmt.Errorf("no gateway found for network [%s]", networkResource.Name)
+	}
+	return gateway, nil
+}
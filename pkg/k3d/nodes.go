@@ -0,0 +1,236 @@
+package k3d
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// container labels keeping the Kubernetes node labels and taints of a node (comma separated)
+const (
+	nodeLabelsLabel = "nodeLabels"
+	nodeTaintsLabel = "nodeTaints"
+)
+
+// nodeGroups are the node specifiers that refer to groups of nodes (see nodeRuleGroupsMap)
+var nodeGroups = []string{"all", "workers", "server", "master"}
+
+// shortNodeNameRegexp matches short node names, i.e. container names without the prefix of the cluster (e.g. worker-0)
+var shortNodeNameRegexp = regexp.MustCompile(`^(server|worker)-\d+$`)
+
+// resolveNodeSpecifier returns the canonical form of a node specifier: groups (all, workers, server, master) and
+// container names (e.g. k3d-mycluster-worker-0) are kept, short node names (e.g. worker-0) are expanded to the container name.
+// Other fragments of container names (e.g. 0 or mycluster-worker-0) don't match, since they might match several nodes.
+// It returns false if the specifier doesn't match any of the nodes to be created.
+func resolveNodeSpecifier(node string, createdNodes []string) (string, bool) {
+	if isNodeGroup(node) {
+		return node, true
+	}
+	short := shortNodeNameRegexp.MatchString(node)
+	for _, name := range createdNodes {
+		if node == name || (short && strings.HasSuffix(name, "-"+node)) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// isNodeGroup checks if a node specifier refers to a group of nodes (see nodeGroups)
+func isNodeGroup(node string) bool {
+	for _, group := range nodeGroups {
+		if node == group {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeNameSpecifierRegexp matches node specifiers naming a node (e.g. server, worker-1 or k3d-mycluster-worker-1),
+// which are split off even if there's no such node, so that they're reported as unknown instead of ending up in the value
+var nodeNameSpecifierRegexp = regexp.MustCompile(`^([a-z0-9-]+-)?(server|worker-\d+)$`)
+
+// splitNodeSpecifiers separates a value from its node specifiers, e.g. `--node-label=a=b@worker-0@worker-1`
+// -> `--node-label=a=b`, [worker-0 worker-1]. Only trailing `@<specifier>` parts that are node groups or node names
+// (see nodeNameSpecifierRegexp) are split off, so that values may contain @ themselves,
+// e.g. `--datastore-endpoint=postgres://user:pass@db/k3s` or `EMAIL=a@b.com@workers`.
+// Values without node specifier apply to defaultNode.
+func splitNodeSpecifiers(spec, defaultNode string) (string, []string) {
+	value := spec
+	nodes := []string{}
+	for {
+		separator := strings.LastIndex(value, "@")
+		if separator < 0 {
+			break
+		}
+		node := value[separator+1:]
+		if !isNodeGroup(node) && !nodeNameSpecifierRegexp.MatchString(node) {
+			break
+		}
+		nodes = append([]string{node}, nodes...)
+		value = value[:separator]
+	}
+	if len(nodes) == 0 {
+		nodes = []string{defaultNode}
+	}
+	return value, nodes
+}
+
+// mapNodesToArgs maps node specifiers to k3s args, format `<arg>[@<node-specifier>...]`.
+// The order of the args is kept, since args may consist of several values (e.g. `--node-label`, `a=b`).
+// Unknown node specifiers are an error, since the arg would silently apply to no node.
+func mapNodesToArgs(kind string, args []string, createdNodes []string, defaultNode string) (map[string][]string, error) {
+	nodeToArgsMap := map[string][]string{}
+	for _, spec := range args {
+		if spec == "" {
+			continue
+		}
+		arg, nodes := splitNodeSpecifiers(spec, defaultNode)
+		for _, node := range nodes {
+			name, ok := resolveNodeSpecifier(node, createdNodes)
+			if !ok {
+				return nil, fmt.Errorf("Unknown node-specifier [%s] in %s [%s]", node, kind, spec)
+			}
+			nodeToArgsMap[name] = append(nodeToArgsMap[name], arg)
+		}
+	}
+	if log.IsLevelEnabled(log.DebugLevel) {
+		// the secrets must not depend on the SecretMaskingHook, which library users might not install
+		redacted := map[string][]string{}
+		for node, nodeArgs := range nodeToArgsMap {
+			redacted[node] = redactSecretValues(nodeArgs)
+		}
+		log.Debugf("%s per node: %+v", kind, redacted)
+	}
+	return nodeToArgsMap, nil
+}
+
+// argsForNode returns the args of a node: the ones for all nodes first, then the ones for its role and the ones for the node itself
+func argsForNode(nodeToArgsMap map[string][]string, role, name string) []string {
+	args := []string{}
+	for _, group := range nodeRuleGroupsMap[role] {
+		args = append(args, nodeToArgsMap[group]...)
+	}
+	return append(args, nodeToArgsMap[name]...)
+}
+
+// taintEffects are the valid effects of Kubernetes taints
+var taintEffects = []string{"NoSchedule", "PreferNoSchedule", "NoExecute"}
+
+// validateNodeLabel checks a Kubernetes node label `key=value`
+func validateNodeLabel(label string) error {
+	parts := strings.SplitN(label, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("Invalid node label [%s], expected key=value", label)
+	}
+	if strings.Contains(label, ",") {
+		return fmt.Errorf("Invalid node label [%s], it must not contain commas", label)
+	}
+	return nil
+}
+
+// validateNodeTaint checks a Kubernetes node taint `key[=value]:effect`
+func validateNodeTaint(taint string) error {
+	separator := strings.LastIndex(taint, ":")
+	if separator <= 0 || strings.HasPrefix(taint, "=") {
+		return fmt.Errorf("Invalid node taint [%s], expected key=value:effect", taint)
+	}
+	if strings.Contains(taint, ",") {
+		return fmt.Errorf("Invalid node taint [%s], it must not contain commas", taint)
+	}
+	effect := taint[separator+1:]
+	for _, valid := range taintEffects {
+		if effect == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid effect [%s] of node taint [%s], must be one of %v", effect, taint, taintEffects)
+}
+
+// mapNodesToLabelsOrTaints validates node labels or taints (`<label-or-taint>[@node-specifier]`, default: all nodes)
+// and maps node specifiers to them
+func mapNodesToLabelsOrTaints(kind string, specs []string, createdNodes []string, validate func(string) error) (map[string][]string, error) {
+	for _, spec := range specs {
+		value, _ := splitNodeSpecifiers(spec, "all")
+		if err := validate(value); err != nil {
+			return nil, err
+		}
+	}
+	return mapNodesToArgs(kind, specs, createdNodes, "all")
+}
+
+// nodeLabelArgs returns the k3s args for the node labels and taints of a node
+// and records them in its container labels (see nodeLabelsLabel and nodeTaintsLabel)
+func nodeLabelArgs(spec *clusterConfig, role, name string, containerLabels map[string]string) []string {
+	args := []string{}
+	labels := argsForNode(spec.NodeToLabels, role, name)
+	for _, label := range labels {
+		args = append(args, "--node-label="+label)
+	}
+	taints := argsForNode(spec.NodeToTaints, role, name)
+	for _, taint := range taints {
+		args = append(args, "--node-taint="+taint)
+	}
+	if len(labels) > 0 {
+		containerLabels[nodeLabelsLabel] = strings.Join(labels, ",")
+	}
+	if len(taints) > 0 {
+		containerLabels[nodeTaintsLabel] = strings.Join(taints, ",")
+	}
+	return args
+}
+
+// NodeLabels returns the Kubernetes labels k3d set on the node (see ClusterSpec.NodeLabels)
+func (n Node) NodeLabels() []string {
+	return splitLabelValue(n.Labels[nodeLabelsLabel])
+}
+
+// NodeTaints returns the Kubernetes taints k3d set on the node (see ClusterSpec.NodeTaints)
+func (n Node) NodeTaints() []string {
+	return splitLabelValue(n.Labels[nodeTaintsLabel])
+}
+
+func splitLabelValue(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// selectNodes returns the nodes of a cluster matching any of the node specifiers, the server first, then the workers by name.
+// Without specifiers, all nodes are selected. Unknown specifiers are an error, since they'd silently select nothing.
+func selectNodes(cluster *Cluster, specifiers []string) ([]Node, error) {
+	nodes := append([]Node{cluster.Server}, cluster.Workers...)
+	sort.Slice(nodes[1:], func(i, j int) bool { return nodes[1+i].Name < nodes[1+j].Name })
+	if len(specifiers) == 0 {
+		return nodes, nil
+	}
+	names := []string{}
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+	selected := map[string]bool{}
+	for _, specifier := range specifiers {
+		name, ok := resolveNodeSpecifier(specifier, names)
+		if !ok {
+			return nil, fmt.Errorf("Unknown node-specifier [%s] for cluster %s", specifier, cluster.Name)
+		}
+		selected[name] = true
+	}
+	result := []Node{}
+	for _, node := range nodes {
+		if selected[node.Name] {
+			result = append(result, node)
+			continue
+		}
+		for _, group := range nodeRuleGroupsMap[node.Role] {
+			if selected[group] {
+				result = append(result, node)
+				break
+			}
+		}
+	}
+	return result, nil
+}
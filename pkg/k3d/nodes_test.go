@@ -0,0 +1,86 @@
+package k3d
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitNodeSpecifiers(t *testing.T) {
+	for _, test := range []struct {
+		spec  string
+		value string
+		nodes []string
+	}{
+		{spec: "--node-label=a=b", value: "--node-label=a=b", nodes: []string{"server"}},
+		{spec: "--node-label=a=b@worker-0@worker-1", value: "--node-label=a=b", nodes: []string{"worker-0", "worker-1"}},
+		{spec: "--node-label=a=b@k3d-test-worker-1", value: "--node-label=a=b", nodes: []string{"k3d-test-worker-1"}},
+		{spec: "--datastore-endpoint=postgres://u:p@host/db", value: "--datastore-endpoint=postgres://u:p@host/db", nodes: []string{"server"}},
+		{spec: "--datastore-endpoint=postgres://u:p@host/db@server", value: "--datastore-endpoint=postgres://u:p@host/db", nodes: []string{"server"}},
+		{spec: "EMAIL=a@b.com", value: "EMAIL=a@b.com", nodes: []string{"server"}},
+		{spec: "EMAIL=a@b.com@all", value: "EMAIL=a@b.com", nodes: []string{"all"}},
+		{spec: "EMAIL=a@b.com@server@workers", value: "EMAIL=a@b.com", nodes: []string{"server", "workers"}},
+		// node names are split off even if the node doesn't exist, so that they're reported as unknown
+		{spec: "FOO=bar@worker-5", value: "FOO=bar", nodes: []string{"worker-5"}},
+		{spec: "FOO=bar@", value: "FOO=bar@", nodes: []string{"server"}},
+	} {
+		value, nodes := splitNodeSpecifiers(test.spec, "server")
+		if value != test.value || !reflect.DeepEqual(nodes, test.nodes) {
+			t.Errorf("splitNodeSpecifiers(%q) = %q, %v, want %q, %v", test.spec, value, nodes, test.value, test.nodes)
+		}
+	}
+}
+
+func TestMapNodesToArgsWithAt(t *testing.T) {
+	createdNodes := GetAllContainerNames("test", 1, 1)
+	args, err := mapNodesToArgs("server arg", []string{
+		"--datastore-endpoint=postgres://u:p@host/db",
+		"--node-label=mail=a@b.com@k3d-test-server",
+	}, createdNodes, "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"server":          {"--datastore-endpoint=postgres://u:p@host/db"},
+		"k3d-test-server": {"--node-label=mail=a@b.com"},
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+}
+
+func TestRegisterSecretValuesWithAt(t *testing.T) {
+	registerSecretValues("test", []string{"--token=p@ssw0rd@server"})
+	if masked := maskSecrets("token p@ssw0rd"); masked != "token "+secretMask {
+		t.Errorf("masked message = %q, want the secret masked", masked)
+	}
+}
+
+func TestResolveNodeSpecifier(t *testing.T) {
+	createdNodes := GetAllContainerNames("test", 1, 12)
+	for _, test := range []struct {
+		node string
+		name string
+		ok   bool
+	}{
+		{node: "all", name: "all", ok: true},
+		{node: "workers", name: "workers", ok: true},
+		{node: "server", name: "server", ok: true},
+		{node: "worker-1", name: "k3d-test-worker-1", ok: true},
+		{node: "worker-11", name: "k3d-test-worker-11", ok: true},
+		{node: "k3d-test-worker-1", name: "k3d-test-worker-1", ok: true},
+		{node: "k3d-test-server", name: "k3d-test-server", ok: true},
+		// fragments of container names are ambiguous
+		{node: "1"},
+		{node: "0"},
+		{node: "r-1"},
+		{node: "test-worker-1"},
+		{node: "orker-1"},
+		{node: "worker-12"},
+		{node: "k3d-other-worker-1"},
+	} {
+		name, ok := resolveNodeSpecifier(test.node, createdNodes)
+		if name != test.name || ok != test.ok {
+			t.Errorf("resolveNodeSpecifier(%q) = %q, %t, want %q, %t", test.node, name, ok, test.name, test.ok)
+		}
+	}
+}
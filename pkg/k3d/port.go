@@ -1,11 +1,11 @@
-package run
+package k3d
 
 import (
 	"fmt"
-	"log"
 	"strings"
 
 	"github.com/docker/go-connections/nat"
+	log "github.com/sirupsen/logrus"
 )
 
 // PublishedPorts is a struct used for exposing container ports on the host system
@@ -33,10 +33,6 @@ func mapNodesToPortSpecs(specs []string, createdNodes []string) (map[string][]st
 	// fmt.Println("List of created nodes:")
 	// fmt.Println(createdNodes)
 
-	// check node-specifier possibilitites
-	possibleNodeSpecifiers := []string{"all", "workers", "server", "master"}
-	possibleNodeSpecifiers = append(possibleNodeSpecifiers, createdNodes...)
-
 	nodeToPortSpecMap := make(map[string][]string)
 
 	for _, spec := range specs {
@@ -50,20 +46,15 @@ func mapNodesToPortSpecs(specs []string, createdNodes []string) (map[string][]st
 			// each node is mapped to a slice of port specifications.
 			//nodeToPortSpecMap[node] = append(nodeToPortSpecMap[node], portSpec)
 			// check if node-specifier is valid (either a role or a name) and append to list if matches
-			nodeFound := false
-			for _, name := range possibleNodeSpecifiers {
-				if node == name {
-					nodeFound = true
-					nodeToPortSpecMap[node] = append(nodeToPortSpecMap[node], portSpec)
-					break
-				}
-			}
+			name, nodeFound := resolveNodeSpecifier(node, createdNodes)
 			if !nodeFound {
-				log.Printf("WARNING: Unknown node-specifier [%s] in port mapping entry [%s]", node, spec)
+				log.Warnf("Unknown node-specifier [%s] in port mapping entry [%s]", node, spec)
+				continue
 			}
+			nodeToPortSpecMap[name] = append(nodeToPortSpecMap[name], portSpec)
 		}
 	}
-	fmt.Printf("nodeToPortSpecMap: %+v\n", nodeToPortSpecMap)
+	log.Debugf("nodeToPortSpecMap: %+v", nodeToPortSpecMap)
 
 	return nodeToPortSpecMap, nil
 }
@@ -92,12 +83,12 @@ func validatePortSpecs(specs []string) error {
 		atSplit := strings.Split(spec, "@") // {"8080:80", "worker-1", "worker-2", ....}
 		_, err := nat.ParsePortSpec(atSplit[0])
 		if err != nil {
-			return fmt.Errorf("ERROR: Invalid port specification [%s] in port mapping [%s]\n%+v", atSplit[0], spec, err)
+			return fmt.Errorf("Invalid port specification [%s] in port mapping [%s]\n%+v", atSplit[0], spec, err)
 		}
 		if len(atSplit) > 0 {
 			for i := 1; i < len(atSplit); i++ {
 				if err := ValidateHostname(atSplit[i]); err != nil {
-					return fmt.Errorf("ERROR: Invalid node-specifier [%s] in port mapping [%s]\n%+v", atSplit[i], spec, err)
+					return fmt.Errorf("Invalid node-specifier [%s] in port mapping [%s]\n%+v", atSplit[i], spec, err)
 				}
 			}
 		}
@@ -0,0 +1,200 @@
+package k3d
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PrunedResource is a leftover resource found (and removed) by Prune
+type PrunedResource struct {
+	// Kind is one of container, network, volume or directory
+	Kind string
+	// Name is the name of the resource, the path for directories
+	Name    string
+	Cluster string
+}
+
+// PruneOptions modify which resources are pruned
+type PruneOptions struct {
+	// DryRun only returns the leftover resources without removing them
+	DryRun bool
+	// Volumes prunes data volumes as well. They're skipped by default, since they might have been kept on purpose.
+	Volumes bool
+}
+
+// pruneGracePeriod protects clusters being created by another k3d process, which don't have a server container yet:
+// clusters with any resource younger than this aren't pruned
+const pruneGracePeriod = 5 * time.Minute
+
+// Prune removes leftover k3d resources (labelled app=k3d) whose cluster has no server container anymore,
+// e.g. after a k3d process got killed during creation. Cluster directories below $HOME/.config/k3d
+// without a server container are removed as well. Clusters with resources younger than pruneGracePeriod are skipped.
+func (k *Client) Prune(ctx context.Context, opts PruneOptions) ([]PrunedResource, error) {
+	dryRun := opts.DryRun
+	rt := k.rt
+	labels := map[string]string{"app": "k3d"}
+
+	// the clusters that still exist are the ones with a server container
+	clusters, err := getClusters(ctx, rt, true, "")
+	if err != nil {
+		return nil, err
+	}
+
+	containers, err := rt.ListContainers(ctx, true, labels)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list containers\n%+v", err)
+	}
+	volumes, err := rt.ListVolumes(ctx, labels)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list volumes\n%+v", err)
+	}
+	networks, err := rt.ListNetworks(ctx, labels)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list networks\n%+v", err)
+	}
+
+	// clusters with recently created resources might still be being created
+	recent := map[string]bool{}
+	checkAge := func(cluster string, created time.Time) {
+		if time.Since(created) < pruneGracePeriod {
+			recent[cluster] = true
+		}
+	}
+	for _, container := range containers {
+		checkAge(container.Labels["cluster"], time.Unix(container.Created, 0))
+	}
+	for _, volume := range volumes {
+		created, err := time.Parse(time.RFC3339, volume.CreatedAt)
+		if err != nil {
+			// without a creation time, the volume might be a recent one
+			created = time.Now()
+		}
+		checkAge(volume.Labels["cluster"], created)
+	}
+	for _, network := range networks {
+		checkAge(network.Labels["cluster"], network.Created)
+	}
+
+	skipped := map[string]bool{}
+	leftover := func(cluster string) bool {
+		if _, ok := clusters[cluster]; ok {
+			return false
+		}
+		if recent[cluster] {
+			if !skipped[cluster] {
+				log.Infof("Skipping cluster [%s], whose resources were created less than %s ago and which might still be being created", cluster, pruneGracePeriod)
+				skipped[cluster] = true
+			}
+			return false
+		}
+		return true
+	}
+
+	pruned := []PrunedResource{}
+
+	for _, container := range containers {
+		node := nodeFromContainer(container)
+		cluster := container.Labels["cluster"]
+		if !leftover(cluster) {
+			continue
+		}
+		if !dryRun {
+			if err := removeContainer(ctx, rt, node.ID); err != nil {
+				log.Warn(err)
+				continue
+			}
+		}
+		pruned = append(pruned, PrunedResource{Kind: string(resourceContainer), Name: node.Name, Cluster: cluster})
+		// the volumes adopted by upgraded nodes were anonymous volumes, which go along with their container
+		volumes := adoptedVolumes(node.Labels)
+		if !dryRun {
+			volumes = removeAdoptedVolumes(ctx, rt, node)
+		}
+		for _, volume := range volumes {
+			pruned = append(pruned, PrunedResource{Kind: string(resourceVolume), Name: volume, Cluster: cluster})
+		}
+	}
+
+	if !opts.Volumes {
+		volumes = nil
+	}
+	for _, volume := range volumes {
+		cluster := volume.Labels["cluster"]
+		if !leftover(cluster) {
+			continue
+		}
+		if !dryRun {
+			if err := rt.RemoveVolume(ctx, volume.Name); err != nil {
+				log.Warnf("couldn't remove volume [%s]: %+v", volume.Name, err)
+				continue
+			}
+		}
+		pruned = append(pruned, PrunedResource{Kind: string(resourceVolume), Name: volume.Name, Cluster: cluster})
+	}
+
+	// networks go last, since docker refuses to remove networks with attached containers
+	for _, network := range networks {
+		cluster := network.Labels["cluster"]
+		if !leftover(cluster) {
+			continue
+		}
+		if !dryRun {
+			if err := rt.RemoveNetwork(ctx, network.ID); err != nil {
+				log.Warnf("couldn't remove network [%s]: %+v", network.Name, err)
+				continue
+			}
+		}
+		pruned = append(pruned, PrunedResource{Kind: string(resourceNetwork), Name: network.Name, Cluster: cluster})
+	}
+
+	dirs, err := leftoverClusterDirs(leftover)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		cluster := path.Base(dir)
+		if !dryRun {
+			if err := os.RemoveAll(dir); err != nil {
+				log.Warnf("couldn't remove directory [%s]: %+v", dir, err)
+				continue
+			}
+		}
+		pruned = append(pruned, PrunedResource{Kind: string(resourceDir), Name: dir, Cluster: cluster})
+	}
+
+	return pruned, nil
+}
+
+// leftoverClusterDirs returns the paths of the cluster directories for whose cluster leftover returns true.
+// Only directories with an images subdirectory are considered, since $HOME/.config/k3d might contain other files.
+func leftoverClusterDirs(leftover func(cluster string) bool) ([]string, error) {
+	configDir, err := getConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(configDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read config directory [%s]\n%+v", configDir, err)
+	}
+
+	dirs := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() || !leftover(entry.Name()) {
+			continue
+		}
+		dir := path.Join(configDir, entry.Name())
+		if info, err := os.Stat(path.Join(dir, "images")); err != nil || !info.IsDir() {
+			continue
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs, nil
+}
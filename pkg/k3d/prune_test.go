@@ -0,0 +1,50 @@
+package k3d
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// prunedNames returns the kinds and names of pruned resources
+func prunedNames(pruned []PrunedResource) []string {
+	names := []string{}
+	for _, resource := range pruned {
+		names = append(names, resource.Kind+" "+resource.Name)
+	}
+	return names
+}
+
+func TestPruneSkipsClustersBeingCreated(t *testing.T) {
+	ctx := context.Background()
+	k, rt := newTestClient(t)
+	// another k3d process created the network of the cluster, but not its server container yet
+	networkID, err := rt.CreateNetwork(ctx, k3dNetworkName("test"), types.NetworkCreate{Labels: map[string]string{"app": "k3d", "cluster": "test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err := k.Prune(ctx, PruneOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 0 {
+		t.Errorf("Prune() removed %v of a cluster being created", prunedNames(pruned))
+	}
+	if _, err := rt.InspectNetwork(ctx, networkID); err != nil {
+		t.Errorf("network of the cluster being created was removed: %v", err)
+	}
+
+	// after the grace period, the network is a leftover
+	rt.networks[networkID].Created = time.Now().Add(-pruneGracePeriod)
+	pruned, err = k.Prune(ctx, PruneOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"network " + k3dNetworkName("test")}; !reflect.DeepEqual(prunedNames(pruned), want) {
+		t.Errorf("Prune() = %v, want %v", prunedNames(pruned), want)
+	}
+}
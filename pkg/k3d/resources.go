@@ -0,0 +1,203 @@
+package k3d
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+)
+
+// reservedCPUsLabel is the container label keeping the CPUs k3d reserved for the system on a node with a CPU limit (see kubeletArgs)
+const reservedCPUsLabel = "reservedCPUs"
+
+// nodeResourceLimits are the resource limits of the nodes by node specifier (all, server, master, workers or a container name)
+type nodeResourceLimits struct {
+	// Memory in bytes
+	Memory   map[string]int64
+	NanoCPUs map[string]int64
+	Pids     map[string]int64
+	// HostCPUs is the number of CPUs of the docker host, needed for CPU limits (see kubeletArgs)
+	HostCPUs int
+}
+
+// parseResourceLimits parses resource limits `<value>[@<node-specifier>...]`. Limits without node specifier apply to all nodes.
+// Memory is given in docker notation (e.g. 512m, 2g), CPUs as decimal number (e.g. 1.5).
+func parseResourceLimits(memory, cpus, pids []string, createdNodes []string) (*nodeResourceLimits, error) {
+	limits := &nodeResourceLimits{}
+	var err error
+	if limits.Memory, err = mapNodesToLimits("memory", memory, createdNodes, units.RAMInBytes); err != nil {
+		return nil, err
+	}
+	if limits.NanoCPUs, err = mapNodesToLimits("cpus", cpus, createdNodes, parseNanoCPUs); err != nil {
+		return nil, err
+	}
+	if limits.Pids, err = mapNodesToLimits("pids limit", pids, createdNodes, parsePidsLimit); err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
+
+// mapNodesToLimits maps node specifiers to the parsed limit values. A later value for the same specifier wins.
+// Unknown node specifiers are an error, like in mapNodesToArgs.
+func mapNodesToLimits(kind string, specs []string, createdNodes []string, parse func(string) (int64, error)) (map[string]int64, error) {
+	limits := map[string]int64{}
+	for _, spec := range specs {
+		limit, nodes := splitNodeSpecifiers(spec, "all")
+		value, err := parse(limit)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s [%s]\n%+v", kind, spec, err)
+		}
+		for _, node := range nodes {
+			name, ok := resolveNodeSpecifier(node, createdNodes)
+			if !ok {
+				return nil, fmt.Errorf("Unknown node-specifier [%s] in %s [%s]", node, kind, spec)
+			}
+			limits[name] = value
+		}
+	}
+	return limits, nil
+}
+
+// parseNanoCPUs parses a number of CPUs, e.g. 1.5
+func parseNanoCPUs(value string) (int64, error) {
+	cpus, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if cpus <= 0 {
+		return 0, fmt.Errorf("the number of CPUs must be positive")
+	}
+	return int64(cpus * 1e9), nil
+}
+
+// parsePidsLimit parses a maximum number of processes
+func parsePidsLimit(value string) (int64, error) {
+	pids, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if pids <= 0 {
+		return 0, fmt.Errorf("the pids limit must be positive")
+	}
+	return pids, nil
+}
+
+// limitForNode returns the limit of a node (0 = unlimited). The most specific node specifier wins:
+// the container name, then the role (server, master or workers), then all.
+func limitForNode(limits map[string]int64, role, name string) int64 {
+	if value, ok := limits[name]; ok {
+		return value
+	}
+	for _, group := range nodeRuleGroupsMap[role] {
+		if value, ok := limits[group]; ok && group != "all" {
+			return value
+		}
+	}
+	return limits["all"]
+}
+
+// forNode returns the container resources of a node
+func (l *nodeResourceLimits) forNode(role, name string) container.Resources {
+	resources := container.Resources{}
+	if l == nil {
+		return resources
+	}
+	resources.Memory = limitForNode(l.Memory, role, name)
+	resources.NanoCPUs = limitForNode(l.NanoCPUs, role, name)
+	if pids := limitForNode(l.Pids, role, name); pids > 0 {
+		resources.PidsLimit = &pids
+	}
+	return resources
+}
+
+// kubeletArgs returns the k3s args making the allocatable CPUs of a node match its CPU limit and records the reservation
+// in its container labels (see reservedCPUsLabel). The kubelet reads the CPUs from sysfs, so it reports all CPUs of the
+// docker host as capacity: the CPUs above the limit are reserved for the system, so that the scheduler doesn't overcommit the node.
+func (l *nodeResourceLimits) kubeletArgs(role, name string, containerLabels map[string]string) []string {
+	if l == nil {
+		return nil
+	}
+	nanoCPUs := limitForNode(l.NanoCPUs, role, name)
+	reserved := int64(l.HostCPUs)*1e9 - nanoCPUs
+	if nanoCPUs == 0 || reserved <= 0 {
+		return nil
+	}
+	containerLabels[reservedCPUsLabel] = fmt.Sprintf("%dm", reserved/1e6)
+	return []string{"--kubelet-arg=system-reserved=cpu=" + containerLabels[reservedCPUsLabel]}
+}
+
+// meminfoFields are the fields of /proc/meminfo in the order of the kernel. Tools parsing the file (e.g. cadvisor in the kubelet
+// or free) expect all of them, so the fake file has them all: the memory fields report the limit, the others are zero.
+var meminfoFields = []string{
+	"MemTotal", "MemFree", "MemAvailable", "Buffers", "Cached", "SwapCached", "Active", "Inactive",
+	"Active(anon)", "Inactive(anon)", "Active(file)", "Inactive(file)", "Unevictable", "Mlocked",
+	"SwapTotal", "SwapFree", "Dirty", "Writeback", "AnonPages", "Mapped", "Shmem", "KReclaimable",
+	"Slab", "SReclaimable", "SUnreclaim", "KernelStack", "PageTables", "NFS_Unstable", "Bounce", "WritebackTmp",
+	"CommitLimit", "Committed_AS", "VmallocTotal", "VmallocUsed", "VmallocChunk", "Percpu", "HardwareCorrupted",
+	"AnonHugePages", "ShmemHugePages", "ShmemPmdMapped", "FileHugePages", "FilePmdMapped",
+	"HugePages_Total", "HugePages_Free", "HugePages_Rsvd", "HugePages_Surp", "Hugepagesize", "Hugetlb",
+	"DirectMap4k", "DirectMap2M", "DirectMap1G",
+}
+
+// fakeMeminfo returns the content of a /proc/meminfo file for a node with the given memory (in bytes)
+func fakeMeminfo(memory int64) string {
+	kb := memory / 1024
+	content := &strings.Builder{}
+	for _, field := range meminfoFields {
+		value := int64(0)
+		switch field {
+		case "MemTotal", "MemFree", "MemAvailable", "CommitLimit", "DirectMap4k":
+			value = kb
+		case "Hugepagesize":
+			value = 2048
+		}
+		// the hugepage counters are numbers of pages, all other fields are sizes
+		if strings.HasPrefix(field, "HugePages_") {
+			fmt.Fprintf(content, "%-16s%8d\n", field+":", value)
+		} else {
+			fmt.Fprintf(content, "%-16s%8d kB\n", field+":", value)
+		}
+	}
+	return content.String()
+}
+
+// fakeMeminfoBind writes a /proc/meminfo file reporting the memory limit of a node to the cluster directory and returns
+// its bind. Without it, the kubelet would report the memory of the host as capacity of the node.
+// CPU limits are reflected by reserving the other CPUs of the host for the system instead (see kubeletArgs).
+func fakeMeminfoBind(clusterName, containerName string, memory int64) (string, error) {
+	clusterDir, err := getClusterDir(clusterName)
+	if err != nil {
+		return "", err
+	}
+	meminfoDir := path.Join(clusterDir, "meminfo")
+	if err := createDirIfNotExists(meminfoDir); err != nil {
+		return "", fmt.Errorf("couldn't create directory %s\n%+v", meminfoDir, err)
+	}
+	meminfoPath := path.Join(meminfoDir, containerName)
+	if err := os.WriteFile(meminfoPath, []byte(fakeMeminfo(memory)), 0644); err != nil {
+		return "", fmt.Errorf("couldn't write %s\n%+v", meminfoPath, err)
+	}
+	return fmt.Sprintf("%s:/proc/meminfo:ro", meminfoPath), nil
+}
+
+// formatMemory formats a number of bytes in docker notation (e.g. 512m), without losing precision
+func formatMemory(bytes int64) string {
+	switch {
+	case bytes%units.GiB == 0:
+		return fmt.Sprintf("%dg", bytes/units.GiB)
+	case bytes%units.MiB == 0:
+		return fmt.Sprintf("%dm", bytes/units.MiB)
+	case bytes%units.KiB == 0:
+		return fmt.Sprintf("%dk", bytes/units.KiB)
+	}
+	return strconv.FormatInt(bytes, 10)
+}
+
+// formatNanoCPUs formats a number of nano CPUs as decimal number of CPUs (e.g. 1.5)
+func formatNanoCPUs(nanoCPUs int64) string {
+	return strconv.FormatFloat(float64(nanoCPUs)/1e9, 'f', -1, 64)
+}
@@ -0,0 +1,112 @@
+package k3d
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFakeMeminfo(t *testing.T) {
+	fields := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSuffix(fakeMeminfo(2<<30), "\n"), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			t.Fatalf("invalid line %q", line)
+		}
+		value = strings.TrimSpace(value)
+		number := strings.TrimSuffix(value, " kB")
+		if _, err := strconv.ParseInt(number, 10, 64); err != nil {
+			t.Errorf("invalid value of %s: %q", key, value)
+		}
+		if strings.HasPrefix(key, "HugePages_") == (number != value) {
+			t.Errorf("unit of %s: %q, want kB for sizes only", key, value)
+		}
+		fields[key] = value
+	}
+	if len(fields) != len(meminfoFields) {
+		t.Errorf("%d fields, want %d", len(fields), len(meminfoFields))
+	}
+	for key, want := range map[string]string{"MemTotal": "2097152 kB", "MemAvailable": "2097152 kB", "Buffers": "0 kB", "Cached": "0 kB", "SwapTotal": "0 kB", "HugePages_Total": "0", "Hugepagesize": "2048 kB"} {
+		if fields[key] != want {
+			t.Errorf("%s = %q, want %q", key, fields[key], want)
+		}
+	}
+}
+
+func TestUnknownNodeSpecifiersAreRejected(t *testing.T) {
+	ctx := context.Background()
+	for _, spec := range []*ClusterSpec{
+		{Name: "test", Workers: 1, ServerArgs: []string{"--node-label=a=b@worker-1"}},
+		{Name: "test", Workers: 1, AgentArgs: []string{"--node-label=a=b@k3d-other-worker-0"}},
+		{Name: "test", Workers: 1, Env: []string{"FOO=bar@worker-3"}},
+		{Name: "test", Workers: 1, Volumes: []string{"/data:/data@worker-1"}},
+		{Name: "test", Workers: 1, Memory: []string{"1g@worker-1"}},
+		{Name: "test", Workers: 1, CPUs: []string{"1@worker-1"}},
+	} {
+		k, rt := newTestClient(t)
+		if _, err := k.CreateCluster(ctx, spec); err == nil || !strings.Contains(err.Error(), "Unknown node-specifier") {
+			t.Errorf("creating %+v returned %v, want an error about the unknown node specifier", spec, err)
+		}
+		if containers, _ := rt.ListContainers(ctx, true, nil); len(containers) != 0 {
+			t.Errorf("%d containers created for %+v", len(containers), spec)
+		}
+	}
+}
+
+func TestCPULimitsReserveTheOtherHostCPUs(t *testing.T) {
+	ctx := context.Background()
+	k, rt := newTestClient(t)
+	rt.CPUs = 4
+	spec := &ClusterSpec{Name: "test", Workers: 3, CPUs: []string{"1.5", "0.25@worker-1", "4@worker-2"}, AgentArgs: []string{"--kubelet-arg=max-pods=50"}}
+	cluster, err := k.CreateCluster(ctx, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the reservation comes in front of the args of the user, so that their own system-reserved CPUs win
+	nodes := map[string]Node{}
+	for _, node := range append([]Node{cluster.Server}, cluster.Workers...) {
+		nodes[nodeShortName("test", node.Name)] = node
+	}
+	tests := []struct {
+		node string
+		want []string
+	}{
+		{"server", []string{"--kubelet-arg=system-reserved=cpu=2500m"}},
+		{"worker-0", []string{"agent", "--kubelet-arg=system-reserved=cpu=2500m", "--kubelet-arg=max-pods=50"}},
+		{"worker-1", []string{"agent", "--kubelet-arg=system-reserved=cpu=3750m", "--kubelet-arg=max-pods=50"}},
+		// a limit of all CPUs of the host doesn't reserve any
+		{"worker-2", []string{"agent", "--kubelet-arg=max-pods=50"}},
+	}
+	for _, test := range tests {
+		info, err := rt.InspectContainer(ctx, nodes[test.node].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd := []string(info.Config.Cmd)
+		if test.node == "server" {
+			reserved := []string{}
+			for _, arg := range cmd {
+				if strings.Contains(arg, "system-reserved") {
+					reserved = append(reserved, arg)
+				}
+			}
+			cmd = reserved
+		}
+		if strings.Join(cmd, " ") != strings.Join(test.want, " ") {
+			t.Errorf("args of %s = %q, want %q", test.node, cmd, test.want)
+		}
+	}
+
+	// the reservation isn't exported as args of the user
+	d, err := k.ExportCluster(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, arg := range append(d.ServerArgs, d.AgentArgs...) {
+		if strings.Contains(arg, "system-reserved") {
+			t.Errorf("the CPU reservation was exported as arg %s", arg)
+		}
+	}
+}
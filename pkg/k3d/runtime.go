@@ -0,0 +1,82 @@
+package k3d
+
+import (
+	"context"
+	"io"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+)
+
+// ExecAttachOptions are the streams and the terminal of a command run by Runtime.ExecAttached
+type ExecAttachOptions struct {
+	// Stdin is forwarded to the command, if set
+	Stdin  io.Reader
+	Stdout io.Writer
+	// Stderr receives the error output of the command, unless it runs with a pseudo-TTY (which combines both outputs in Stdout)
+	Stderr io.Writer
+	// Tty allocates a pseudo-TTY for the command
+	Tty bool
+	// ConsoleSize is the initial size [height, width] of the pseudo-TTY
+	ConsoleSize *[2]uint
+	// Resize receives new sizes [height, width] of the pseudo-TTY, e.g. when the local terminal is resized
+	Resize <-chan [2]uint
+}
+
+// Runtime is the container runtime in which the cluster nodes are running.
+// A runtime is created once per command and passed through to all functions that need it,
+// so that the cluster lifecycle logic can run against the in-memory FakeRuntime as well.
+type Runtime interface {
+	// Ping checks if the runtime is reachable and returns its API version
+	Ping(ctx context.Context) (string, error)
+	// HostCPUs returns the number of CPUs of the docker host
+	HostCPUs(ctx context.Context) (int, error)
+
+	// CreateContainer creates (but doesn't start) a container and returns its ID
+	CreateContainer(ctx context.Context, name string, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig) (string, error)
+	StartContainer(ctx context.Context, ID string) error
+	StopContainer(ctx context.Context, ID string) error
+	// RemoveContainer force-removes a container, optionally along with its anonymous volumes
+	RemoveContainer(ctx context.Context, ID string, removeVolumes bool) error
+	// ListContainers lists containers having all of the given labels (running ones only, unless all is set)
+	ListContainers(ctx context.Context, all bool, labels map[string]string) ([]types.Container, error)
+	GetContainerLogs(ctx context.Context, ID string, options container.LogsOptions) (io.ReadCloser, error)
+	// InspectContainer returns the configuration, state and mounts of a container
+	InspectContainer(ctx context.Context, ID string) (types.ContainerJSON, error)
+	RenameContainer(ctx context.Context, ID string, newName string) error
+
+	// Exec runs a command in a container, waits for it to finish and returns its (combined) output.
+	// It returns an error if the command exited with a non-zero exit code.
+	Exec(ctx context.Context, ID string, cmd []string) (string, error)
+	// ExecAttached runs a command in a container with the given streams attached, waits for it to finish and returns its exit code
+	ExecAttached(ctx context.Context, ID string, cmd []string, options ExecAttachOptions) (int, error)
+	// ExecDetached starts a command in a container without waiting for it
+	ExecDetached(ctx context.Context, ID string, cmd []string) error
+	// CopyFromContainer returns the content of srcPath in the container as a tar archive
+	CopyFromContainer(ctx context.Context, ID string, srcPath string) (io.ReadCloser, error)
+	// CopyToContainer extracts the tar archive content into dstPath in the container
+	CopyToContainer(ctx context.Context, ID string, dstPath string, content io.Reader) error
+
+	CreateNetwork(ctx context.Context, name string, options types.NetworkCreate) (string, error)
+	// ListNetworks lists networks having all of the given labels
+	ListNetworks(ctx context.Context, labels map[string]string) ([]types.NetworkResource, error)
+	InspectNetwork(ctx context.Context, ID string) (types.NetworkResource, error)
+	RemoveNetwork(ctx context.Context, ID string) error
+
+	// CreateVolume creates a named volume and returns its name
+	CreateVolume(ctx context.Context, name string, labels map[string]string) (string, error)
+	// ListVolumes lists volumes having all of the given labels
+	ListVolumes(ctx context.Context, labels map[string]string) ([]*volume.Volume, error)
+	RemoveVolume(ctx context.Context, name string) error
+
+	// ImageExists checks if an image is present locally
+	ImageExists(ctx context.Context, image string) (bool, error)
+	// InspectImage returns the configuration of a local image, e.g. its default environment
+	InspectImage(ctx context.Context, image string) (types.ImageInspect, error)
+	// PullImage pulls an image and returns the JSON progress stream of the pull
+	PullImage(ctx context.Context, image string) (io.ReadCloser, error)
+	// SaveImages returns the given images as a tarball (`docker save`)
+	SaveImages(ctx context.Context, images []string) (io.ReadCloser, error)
+}
@@ -0,0 +1,304 @@
+package k3d
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	dockerClient "github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	log "github.com/sirupsen/logrus"
+)
+
+// dockerRuntime implements the Runtime interface using the docker engine API
+type dockerRuntime struct {
+	client *dockerClient.Client
+}
+
+// NewDockerRuntime creates a runtime for the docker daemon of the current docker context
+func NewDockerRuntime() (Runtime, error) {
+	docker, err := newDockerClient()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create docker client\n%+v", err)
+	}
+	return &dockerRuntime{client: docker}, nil
+}
+
+// labelFilters converts a label map to docker filter arguments (label=key=value)
+func labelFilters(labels map[string]string) filters.Args {
+	args := filters.NewArgs()
+	for key, value := range labels {
+		args.Add("label", fmt.Sprintf("%s=%s", key, value))
+	}
+	return args
+}
+
+func (d *dockerRuntime) Ping(ctx context.Context) (string, error) {
+	// Ping pings the server and returns the value of the "Docker-Experimental", "Builder-Version", "OS-Type" & "API-Version" headers.
+	ping, err := d.client.Ping(ctx)
+	if err != nil {
+		return "", err
+	}
+	return ping.APIVersion, nil
+}
+
+func (d *dockerRuntime) HostCPUs(ctx context.Context) (int, error) {
+	info, err := d.client.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return info.NCPU, nil
+}
+
+func (d *dockerRuntime) CreateContainer(ctx context.Context, name string, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig) (string, error) {
+	// resp --> container create response. It contains information about the newly created container, such as its unique identifier (ID).
+	resp, err := d.client.ContainerCreate(ctx, config, hostConfig, networkingConfig, nil, name)
+	if err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+func (d *dockerRuntime) StartContainer(ctx context.Context, ID string) error {
+	return d.client.ContainerStart(ctx, ID, container.StartOptions{})
+}
+
+func (d *dockerRuntime) InspectContainer(ctx context.Context, ID string) (types.ContainerJSON, error) {
+	return d.client.ContainerInspect(ctx, ID)
+}
+
+func (d *dockerRuntime) RenameContainer(ctx context.Context, ID string, newName string) error {
+	return d.client.ContainerRename(ctx, ID, newName)
+}
+
+func (d *dockerRuntime) StopContainer(ctx context.Context, ID string) error {
+	return d.client.ContainerStop(ctx, ID, container.StopOptions{})
+}
+
+func (d *dockerRuntime) RemoveContainer(ctx context.Context, ID string, removeVolumes bool) error {
+	//always force delete
+	return d.client.ContainerRemove(ctx, ID, container.RemoveOptions{
+		RemoveVolumes: removeVolumes,
+		Force:         true,
+	})
+}
+
+func (d *dockerRuntime) ListContainers(ctx context.Context, all bool, labels map[string]string) ([]types.Container, error) {
+	return d.client.ContainerList(ctx, container.ListOptions{
+		All:     all,
+		Filters: labelFilters(labels),
+	})
+}
+
+func (d *dockerRuntime) GetContainerLogs(ctx context.Context, ID string, options container.LogsOptions) (io.ReadCloser, error) {
+	// ContainerLogs returns the logs generated by a container in an io.ReadCloser. It's up to the caller to close the stream.
+	return d.client.ContainerLogs(ctx, ID, options)
+}
+
+func (d *dockerRuntime) Exec(ctx context.Context, ID string, cmd []string) (string, error) {
+	// ExecConfig is a small subset of the Config struct that holds the configuration for the exec feature of docker.
+	execConfig := types.ExecConfig{
+		AttachStderr: true,
+		AttachStdout: true,
+		Cmd:          cmd,
+		// with a pseudo-TTY, stdout and stderr are not multiplexed, so the output can be read as is
+		Tty: true,
+	}
+	execResponse, err := d.client.ContainerExecCreate(ctx, ID, execConfig)
+	if err != nil {
+		return "", fmt.Errorf("couldn't create exec command\n%+v", err)
+	}
+
+	// attaching starts the exec process
+	connection, err := d.client.ContainerExecAttach(ctx, execResponse.ID, types.ExecStartCheck{Tty: true})
+	if err != nil {
+		return "", fmt.Errorf("couldn't attach to exec process\n%+v", err)
+	}
+	defer connection.Close()
+	// reading from the hijacked connection doesn't observe ctx, so it's closed when ctx is done (e.g. a hung command timed out)
+	stop := context.AfterFunc(ctx, connection.Close)
+	defer stop()
+
+	// the output ends (EOF) when the exec process exits
+	output, err := io.ReadAll(connection.Reader)
+	if ctx.Err() != nil {
+		return string(output), fmt.Errorf("couldn't read output of exec process\n%w", ctx.Err())
+	}
+	if err != nil {
+		return "", fmt.Errorf("couldn't read output of exec process\n%+v", err)
+	}
+
+	inspect, err := d.client.ContainerExecInspect(ctx, execResponse.ID)
+	if err != nil {
+		return string(output), fmt.Errorf("couldn't inspect exec process\n%+v", err)
+	}
+	if inspect.ExitCode != 0 {
+		return string(output), fmt.Errorf("command %v exited with code %d", cmd, inspect.ExitCode)
+	}
+	return string(output), nil
+}
+
+func (d *dockerRuntime) ExecAttached(ctx context.Context, ID string, cmd []string, options ExecAttachOptions) (int, error) {
+	execResponse, err := d.client.ContainerExecCreate(ctx, ID, types.ExecConfig{
+		AttachStdin:  options.Stdin != nil,
+		AttachStderr: true,
+		AttachStdout: true,
+		Cmd:          cmd,
+		Tty:          options.Tty,
+		ConsoleSize:  options.ConsoleSize,
+	})
+	if err != nil {
+		return -1, fmt.Errorf("couldn't create exec command\n%+v", err)
+	}
+
+	// attaching starts the exec process
+	connection, err := d.client.ContainerExecAttach(ctx, execResponse.ID, types.ExecStartCheck{Tty: options.Tty, ConsoleSize: options.ConsoleSize})
+	if err != nil {
+		return -1, fmt.Errorf("couldn't attach to exec process\n%+v", err)
+	}
+	defer connection.Close()
+	stop := context.AfterFunc(ctx, connection.Close)
+	defer stop()
+
+	if options.Stdin != nil {
+		go func() {
+			// closing the write side signals EOF to the command
+			io.Copy(connection.Conn, options.Stdin)
+			connection.CloseWrite()
+		}()
+	}
+	if options.Resize != nil {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case size, ok := <-options.Resize:
+					if !ok {
+						return
+					}
+					if err := d.client.ContainerExecResize(ctx, execResponse.ID, container.ResizeOptions{Height: size[0], Width: size[1]}); err != nil {
+						log.Debugf("couldn't resize the terminal of the exec process: %+v", err)
+					}
+				}
+			}
+		}()
+	}
+
+	// with a pseudo-TTY, the output is raw, otherwise stdout and stderr are multiplexed.
+	// The output ends (EOF) when the exec process exits.
+	if options.Tty {
+		_, err = io.Copy(options.Stdout, connection.Reader)
+	} else {
+		_, err = stdcopy.StdCopy(options.Stdout, options.Stderr, connection.Reader)
+	}
+	if ctx.Err() != nil {
+		return -1, fmt.Errorf("couldn't read output of exec process\n%w", ctx.Err())
+	}
+	if err != nil {
+		return -1, fmt.Errorf("couldn't read output of exec process\n%+v", err)
+	}
+
+	inspect, err := d.client.ContainerExecInspect(ctx, execResponse.ID)
+	if err != nil {
+		return -1, fmt.Errorf("couldn't inspect exec process\n%+v", err)
+	}
+	return inspect.ExitCode, nil
+}
+
+func (d *dockerRuntime) ExecDetached(ctx context.Context, ID string, cmd []string) error {
+	execResponse, err := d.client.ContainerExecCreate(ctx, ID, types.ExecConfig{
+		Cmd:    cmd,
+		Detach: true,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't create exec command\n%+v", err)
+	}
+	return d.client.ContainerExecStart(ctx, execResponse.ID, types.ExecStartCheck{Detach: true})
+}
+
+func (d *dockerRuntime) CopyFromContainer(ctx context.Context, ID string, srcPath string) (io.ReadCloser, error) {
+	// CopyFromContainer gets the content from the container and returns it as a Reader for a TAR archive to manipulate it in the host.
+	reader, _, err := d.client.CopyFromContainer(ctx, ID, srcPath)
+	return reader, err
+}
+
+func (d *dockerRuntime) CopyToContainer(ctx context.Context, ID string, dstPath string, content io.Reader) error {
+	return d.client.CopyToContainer(ctx, ID, dstPath, content, types.CopyToContainerOptions{})
+}
+
+func (d *dockerRuntime) CreateNetwork(ctx context.Context, name string, options types.NetworkCreate) (string, error) {
+	resp, err := d.client.NetworkCreate(ctx, name, options)
+	if err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+func (d *dockerRuntime) ListNetworks(ctx context.Context, labels map[string]string) ([]types.NetworkResource, error) {
+	// NetworkList returns the list of networks configured in the docker host.
+	return d.client.NetworkList(ctx, types.NetworkListOptions{Filters: labelFilters(labels)})
+}
+
+func (d *dockerRuntime) InspectNetwork(ctx context.Context, ID string) (types.NetworkResource, error) {
+	return d.client.NetworkInspect(ctx, ID, types.NetworkInspectOptions{})
+}
+
+func (d *dockerRuntime) RemoveNetwork(ctx context.Context, ID string) error {
+	return d.client.NetworkRemove(ctx, ID)
+}
+
+func (d *dockerRuntime) CreateVolume(ctx context.Context, name string, labels map[string]string) (string, error) {
+	vol, err := d.client.VolumeCreate(ctx, volume.CreateOptions{Name: name, Labels: labels})
+	if err != nil {
+		return "", err
+	}
+	return vol.Name, nil
+}
+
+func (d *dockerRuntime) ListVolumes(ctx context.Context, labels map[string]string) ([]*volume.Volume, error) {
+	resp, err := d.client.VolumeList(ctx, volume.ListOptions{Filters: labelFilters(labels)})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Volumes, nil
+}
+
+func (d *dockerRuntime) RemoveVolume(ctx context.Context, name string) error {
+	return d.client.VolumeRemove(ctx, name, true)
+}
+
+func (d *dockerRuntime) ImageExists(ctx context.Context, imageName string) (bool, error) {
+	if _, _, err := d.client.ImageInspectWithRaw(ctx, imageName); err != nil {
+		if dockerClient.IsErrNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *dockerRuntime) InspectImage(ctx context.Context, imageName string) (types.ImageInspect, error) {
+	info, _, err := d.client.ImageInspectWithRaw(ctx, imageName)
+	return info, err
+}
+
+// PullImage pulls an image using the credentials for its registry from the docker config file (if any)
+func (d *dockerRuntime) PullImage(ctx context.Context, imageName string) (io.ReadCloser, error) {
+	registryAuth, err := getRegistryAuth(imageName)
+	if err != nil {
+		log.Warnf("couldn't get registry credentials for image %s, pulling anonymously: %+v", imageName, err)
+	}
+	return d.client.ImagePull(ctx, imageName, image.PullOptions{RegistryAuth: registryAuth})
+}
+
+func (d *dockerRuntime) SaveImages(ctx context.Context, images []string) (io.ReadCloser, error) {
+	// ImageSave retrieves one or more images from the docker host as an io.ReadCloser. It's up to the caller to store the images and close the stream.
+	return d.client.ImageSave(ctx, images)
+}
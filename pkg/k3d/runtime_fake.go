@@ -0,0 +1,571 @@
+package k3d
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// FakeRuntime is an in-memory implementation of the Runtime interface.
+// It keeps track of containers and networks without talking to a docker daemon,
+// so that the cluster lifecycle logic can be unit-tested.
+type FakeRuntime struct {
+	mutex      sync.Mutex
+	nextID     int
+	containers map[string]*fakeContainer
+	networks   map[string]*types.NetworkResource
+	volumes    map[string]*volume.Volume
+
+	// Logs is returned as the log output of every container
+	Logs string
+	// ContainerLogs are the log outputs of single containers, keyed by container name, instead of Logs
+	ContainerLogs map[string]string
+	// ExecHandler is called for every (attached) exec command; by default it returns "done"
+	ExecHandler func(ID string, cmd []string) (string, error)
+	// Execs records all exec commands (attached and detached) in order
+	Execs [][]string
+	// PulledImages records all image pulls in order
+	PulledImages []string
+	// Images are the locally present images; pulled images are added
+	Images map[string]bool
+	// Files are the regular files in the containers, keyed by container ID and absolute path.
+	// CopyToContainer extracts tar archives into it, CopyFromContainer archives them.
+	Files map[string]map[string][]byte
+	// volumeFiles are the files written below the mount points of volumes, keyed by volume name and path in the volume,
+	// so that other containers mounting the volumes see them as well
+	volumeFiles map[string]map[string][]byte
+	// CPUs is the number of CPUs of the docker host
+	CPUs int
+	// ImageVolumes are the volumes declared by every image (like /var/lib/rancher/k3s by the k3s image).
+	// Containers get an anonymous volume for each of them that isn't bound otherwise.
+	ImageVolumes []string
+}
+
+// fakeContainer is a container of the FakeRuntime
+type fakeContainer struct {
+	name             string
+	state            string
+	created          time.Time
+	config           *container.Config
+	hostConfig       *container.HostConfig
+	networkingConfig *network.NetworkingConfig
+	mounts           []types.MountPoint
+	// anonymousVolumes are removed along with the container, if requested
+	anonymousVolumes []string
+}
+
+// NewFakeRuntime creates an empty in-memory runtime, whose containers log "Running kubelet"
+func NewFakeRuntime() *FakeRuntime {
+	return &FakeRuntime{
+		containers:   make(map[string]*fakeContainer),
+		networks:     make(map[string]*types.NetworkResource),
+		volumes:      make(map[string]*volume.Volume),
+		Logs:         "Running kubelet",
+		Files:        make(map[string]map[string][]byte),
+		volumeFiles:  make(map[string]map[string][]byte),
+		Images:       make(map[string]bool),
+		CPUs:         8,
+		ImageVolumes: []string{k3sDataDir},
+	}
+}
+
+// newID returns a new unique object ID. The caller must hold the mutex.
+func (f *FakeRuntime) newID() string {
+	f.nextID++
+	return fmt.Sprintf("fake-%d", f.nextID)
+}
+
+// hasLabels returns true if all given labels are set on the object
+func hasLabels(objectLabels, labels map[string]string) bool {
+	for key, value := range labels {
+		if objectLabels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *FakeRuntime) Ping(ctx context.Context) (string, error) {
+	return "fake", nil
+}
+
+func (f *FakeRuntime) HostCPUs(ctx context.Context) (int, error) {
+	return f.CPUs, nil
+}
+
+func (f *FakeRuntime) CreateContainer(ctx context.Context, name string, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, c := range f.containers {
+		if c.name == name {
+			return "", fmt.Errorf("container name [%s] is already in use", name)
+		}
+	}
+	ID := f.newID()
+	c := &fakeContainer{
+		name:             name,
+		state:            "created",
+		created:          time.Now(),
+		config:           config,
+		hostConfig:       hostConfig,
+		networkingConfig: networkingConfig,
+	}
+	// like docker, named volumes are created on first use and the volumes of the image get anonymous volumes
+	bound := map[string]bool{}
+	if hostConfig != nil {
+		for _, bind := range hostConfig.Binds {
+			parts := strings.Split(bind, ":")
+			if len(parts) < 2 {
+				continue
+			}
+			bound[parts[1]] = true
+			if path.IsAbs(parts[0]) {
+				continue
+			}
+			if _, ok := f.volumes[parts[0]]; !ok {
+				f.volumes[parts[0]] = &volume.Volume{Name: parts[0], Driver: "local", CreatedAt: time.Now().Format(time.RFC3339)}
+			}
+			c.mounts = append(c.mounts, types.MountPoint{Type: mount.TypeVolume, Name: parts[0], Destination: parts[1]})
+		}
+	}
+	for _, destination := range f.ImageVolumes {
+		if bound[destination] {
+			continue
+		}
+		volumeName := f.newID()
+		f.volumes[volumeName] = &volume.Volume{Name: volumeName, Driver: "local", CreatedAt: time.Now().Format(time.RFC3339)}
+		c.mounts = append(c.mounts, types.MountPoint{Type: mount.TypeVolume, Name: volumeName, Destination: destination})
+		c.anonymousVolumes = append(c.anonymousVolumes, volumeName)
+	}
+	f.containers[ID] = c
+	return ID, nil
+}
+
+// getContainer returns the container with the given ID. The caller must hold the mutex.
+func (f *FakeRuntime) getContainer(ID string) (*fakeContainer, error) {
+	c, ok := f.containers[ID]
+	if !ok {
+		return nil, fmt.Errorf("no such container [%s]", ID)
+	}
+	return c, nil
+}
+
+func (f *FakeRuntime) setContainerState(ID, state string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	c, err := f.getContainer(ID)
+	if err != nil {
+		return err
+	}
+	c.state = state
+	return nil
+}
+
+func (f *FakeRuntime) StartContainer(ctx context.Context, ID string) error {
+	return f.setContainerState(ID, "running")
+}
+
+func (f *FakeRuntime) StopContainer(ctx context.Context, ID string) error {
+	return f.setContainerState(ID, "exited")
+}
+
+func (f *FakeRuntime) InspectContainer(ctx context.Context, ID string) (types.ContainerJSON, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	c, err := f.getContainer(ID)
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+	networks := map[string]*network.EndpointSettings{}
+	if c.networkingConfig != nil {
+		networks = c.networkingConfig.EndpointsConfig
+	}
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         ID,
+			Name:       "/" + c.name,
+			Image:      c.config.Image,
+			State:      &types.ContainerState{Status: c.state, Running: c.state == "running"},
+			HostConfig: c.hostConfig,
+		},
+		Mounts:          c.mounts,
+		Config:          c.config,
+		NetworkSettings: &types.NetworkSettings{Networks: networks},
+	}, nil
+}
+
+func (f *FakeRuntime) RenameContainer(ctx context.Context, ID string, newName string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	c, err := f.getContainer(ID)
+	if err != nil {
+		return err
+	}
+	for _, other := range f.containers {
+		if other.name == newName {
+			return fmt.Errorf("container name [%s] is already in use", newName)
+		}
+	}
+	c.name = newName
+	return nil
+}
+
+func (f *FakeRuntime) RemoveContainer(ctx context.Context, ID string, removeVolumes bool) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	c, err := f.getContainer(ID)
+	if err != nil {
+		return err
+	}
+	if removeVolumes {
+		for _, volumeName := range c.anonymousVolumes {
+			delete(f.volumes, volumeName)
+		}
+	}
+	delete(f.containers, ID)
+	delete(f.Files, ID)
+	return nil
+}
+
+func (f *FakeRuntime) ListContainers(ctx context.Context, all bool, labels map[string]string) ([]types.Container, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	containers := []types.Container{}
+	for ID, c := range f.containers {
+		if (!all && c.state != "running") || !hasLabels(c.config.Labels, labels) {
+			continue
+		}
+		ports := []types.Port{}
+		if c.hostConfig != nil {
+			for port, bindings := range c.hostConfig.PortBindings {
+				for _, binding := range bindings {
+					publicPort, _ := strconv.Atoi(binding.HostPort)
+					ports = append(ports, types.Port{
+						IP:          binding.HostIP,
+						PrivatePort: uint16(port.Int()),
+						PublicPort:  uint16(publicPort),
+						Type:        port.Proto(),
+					})
+				}
+			}
+		}
+		containers = append(containers, types.Container{
+			ID:      ID,
+			Names:   []string{"/" + c.name},
+			Image:   c.config.Image,
+			Labels:  c.config.Labels,
+			State:   c.state,
+			Ports:   ports,
+			Created: c.created.Unix(),
+		})
+	}
+	return containers, nil
+}
+
+func (f *FakeRuntime) GetContainerLogs(ctx context.Context, ID string, options container.LogsOptions) (io.ReadCloser, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	c, err := f.getContainer(ID)
+	if err != nil {
+		return nil, err
+	}
+	output, ok := f.ContainerLogs[c.name]
+	if !ok {
+		output = f.Logs
+	}
+	// like docker for containers without TTY, stdout is multiplexed with frame headers
+	logs := &bytes.Buffer{}
+	if output == "" {
+		return io.NopCloser(logs), nil
+	}
+	if _, err := stdcopy.NewStdWriter(logs, stdcopy.Stdout).Write([]byte(output)); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(logs), nil
+}
+
+func (f *FakeRuntime) Exec(ctx context.Context, ID string, cmd []string) (string, error) {
+	f.mutex.Lock()
+	if _, err := f.getContainer(ID); err != nil {
+		f.mutex.Unlock()
+		return "", err
+	}
+	f.Execs = append(f.Execs, cmd)
+	handler := f.ExecHandler
+	f.mutex.Unlock()
+
+	if handler != nil {
+		return handler(ID, cmd)
+	}
+	return "done", nil
+}
+
+func (f *FakeRuntime) ExecAttached(ctx context.Context, ID string, cmd []string, options ExecAttachOptions) (int, error) {
+	f.mutex.Lock()
+	_, err := f.getContainer(ID)
+	f.mutex.Unlock()
+	if err != nil {
+		return -1, err
+	}
+	// the fake command writes the output of ExecHandler and exits with 1 if it fails, or with the FakeExitCode it returned
+	output, err := f.Exec(ctx, ID, cmd)
+	if _, writeErr := io.WriteString(options.Stdout, output); writeErr != nil {
+		return -1, writeErr
+	}
+	var exitCode FakeExitCode
+	if errors.As(err, &exitCode) {
+		return int(exitCode), nil
+	}
+	if err != nil {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// FakeExitCode is returned by an ExecHandler to make an attached exec command exit with this code
+type FakeExitCode int
+
+func (c FakeExitCode) Error() string {
+	return fmt.Sprintf("exit code %d", int(c))
+}
+
+func (f *FakeRuntime) ExecDetached(ctx context.Context, ID string, cmd []string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, err := f.getContainer(ID); err != nil {
+		return err
+	}
+	f.Execs = append(f.Execs, cmd)
+	return nil
+}
+
+func (f *FakeRuntime) CopyFromContainer(ctx context.Context, ID string, srcPath string) (io.ReadCloser, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	c, err := f.getContainer(ID)
+	if err != nil {
+		return nil, err
+	}
+	// the files of the mounted volumes, unless the container has its own file at the same path
+	files := map[string][]byte{}
+	for _, mount := range c.mounts {
+		for volumePath, content := range f.volumeFiles[mount.Name] {
+			files[path.Join(mount.Destination, volumePath)] = content
+		}
+	}
+	for filePath, content := range f.Files[ID] {
+		files[filePath] = content
+	}
+	// like docker, the file or directory is archived under its base name
+	srcPath = path.Clean(srcPath)
+	paths := []string{}
+	for filePath := range files {
+		if filePath == srcPath || strings.HasPrefix(filePath, srcPath+"/") {
+			paths = append(paths, filePath)
+		}
+	}
+	if len(paths) == 0 {
+		// like docker, report missing files as not found
+		return nil, errdefs.NotFound(fmt.Errorf("no such file [%s] in container [%s]", srcPath, ID))
+	}
+	sort.Strings(paths)
+
+	buffer := &bytes.Buffer{}
+	tarWriter := tar.NewWriter(buffer)
+	for _, filePath := range paths {
+		content := files[filePath]
+		name := strings.TrimPrefix(filePath, path.Dir(srcPath)+"/")
+		if err := tarWriter.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0600, Size: int64(len(content))}); err != nil {
+			return nil, err
+		}
+		if _, err := tarWriter.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(buffer), nil
+}
+
+func (f *FakeRuntime) CopyToContainer(ctx context.Context, ID string, dstPath string, content io.Reader) error {
+	// like docker, the tar archive is extracted into dstPath (only regular files are kept)
+	files := map[string][]byte{}
+	tarReader := tar.NewReader(content)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tar archive\n%+v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tarReader)
+		if err != nil {
+			return err
+		}
+		files[path.Join("/", dstPath, header.Name)] = data
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	c, err := f.getContainer(ID)
+	if err != nil {
+		return err
+	}
+	if f.Files[ID] == nil {
+		f.Files[ID] = make(map[string][]byte)
+	}
+	for filePath, data := range files {
+		f.Files[ID][filePath] = data
+		for _, mount := range c.mounts {
+			if volumePath, ok := strings.CutPrefix(filePath, mount.Destination+"/"); ok {
+				if f.volumeFiles[mount.Name] == nil {
+					f.volumeFiles[mount.Name] = make(map[string][]byte)
+				}
+				f.volumeFiles[mount.Name][volumePath] = data
+			}
+		}
+	}
+	return nil
+}
+
+func (f *FakeRuntime) CreateNetwork(ctx context.Context, name string, options types.NetworkCreate) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, n := range f.networks {
+		if n.Name == name {
+			return "", fmt.Errorf("network with name [%s] already exists", name)
+		}
+	}
+	ID := f.newID()
+	ipam := network.IPAM{Config: []network.IPAMConfig{{Subnet: "172.28.0.0/16", Gateway: "172.28.0.1"}}}
+	if options.IPAM != nil {
+		ipam.Config = append(ipam.Config, options.IPAM.Config...)
+	}
+	f.networks[ID] = &types.NetworkResource{
+		ID:         ID,
+		Name:       name,
+		EnableIPv6: options.EnableIPv6,
+		IPAM:       ipam,
+		Labels:     options.Labels,
+		Created:    time.Now(),
+	}
+	return ID, nil
+}
+
+func (f *FakeRuntime) ListNetworks(ctx context.Context, labels map[string]string) ([]types.NetworkResource, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	networks := []types.NetworkResource{}
+	for _, n := range f.networks {
+		if hasLabels(n.Labels, labels) {
+			networks = append(networks, *n)
+		}
+	}
+	return networks, nil
+}
+
+func (f *FakeRuntime) InspectNetwork(ctx context.Context, ID string) (types.NetworkResource, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	n, ok := f.networks[ID]
+	if !ok {
+		return types.NetworkResource{}, fmt.Errorf("no such network [%s]", ID)
+	}
+	return *n, nil
+}
+
+func (f *FakeRuntime) RemoveNetwork(ctx context.Context, ID string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.networks[ID]; !ok {
+		return fmt.Errorf("no such network [%s]", ID)
+	}
+	delete(f.networks, ID)
+	return nil
+}
+
+func (f *FakeRuntime) CreateVolume(ctx context.Context, name string, labels map[string]string) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.volumes[name]; ok {
+		return "", fmt.Errorf("volume name [%s] is already in use", name)
+	}
+	f.volumes[name] = &volume.Volume{Name: name, Driver: "local", Labels: labels, CreatedAt: time.Now().Format(time.RFC3339)}
+	return name, nil
+}
+
+func (f *FakeRuntime) ListVolumes(ctx context.Context, labels map[string]string) ([]*volume.Volume, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	volumes := []*volume.Volume{}
+	for _, v := range f.volumes {
+		if hasLabels(v.Labels, labels) {
+			volumes = append(volumes, v)
+		}
+	}
+	return volumes, nil
+}
+
+func (f *FakeRuntime) RemoveVolume(ctx context.Context, name string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.volumes[name]; !ok {
+		return fmt.Errorf("no such volume [%s]", name)
+	}
+	delete(f.volumes, name)
+	delete(f.volumeFiles, name)
+	return nil
+}
+
+func (f *FakeRuntime) ImageExists(ctx context.Context, image string) (bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.Images[image], nil
+}
+
+func (f *FakeRuntime) InspectImage(ctx context.Context, image string) (types.ImageInspect, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !f.Images[image] {
+		return types.ImageInspect{}, errdefs.NotFound(fmt.Errorf("no such image [%s]", image))
+	}
+	volumes := map[string]struct{}{}
+	for _, destination := range f.ImageVolumes {
+		volumes[destination] = struct{}{}
+	}
+	return types.ImageInspect{ID: image, Config: &container.Config{Env: []string{"PATH=/bin"}, Volumes: volumes}}, nil
+}
+
+func (f *FakeRuntime) PullImage(ctx context.Context, image string) (io.ReadCloser, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.PulledImages = append(f.PulledImages, image)
+	f.Images[image] = true
+	return io.NopCloser(bytes.NewBufferString(fmt.Sprintf(`{"status":"Pulling from %s"}`+"\n", image))), nil
+}
+
+func (f *FakeRuntime) SaveImages(ctx context.Context, images []string) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(nil)), nil
+}
@@ -0,0 +1,204 @@
+package k3d
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tokenBytes is the number of random bytes of a generated token (hex encoded, i.e. 64 characters)
+const tokenBytes = 32
+
+// secretMask replaces secrets in log messages
+const secretMask = "********"
+
+// secretPrefixes introduce secrets in args and env vars, e.g. --token=<secret> or K3S_TOKEN=<secret>
+var secretPrefixes = []string{"--token=", "--agent-token=", "K3S_TOKEN=", "K3S_AGENT_TOKEN=", "K3S_CLUSTER_SECRET="}
+
+// secretFlags are k3s flags whose value (the next arg) is a secret, e.g. `--token <secret>`
+var secretFlags = []string{"--token", "-t", "--agent-token"}
+
+var (
+	// showSecrets disables the masking of secrets in log messages (see SetShowSecrets)
+	showSecrets bool
+	// secrets are masked in all log messages of loggers with the SecretMaskingHook, keyed by their cluster.
+	// They're forgotten when their cluster is deleted (see forgetSecrets), so that a long-lived Client doesn't collect them.
+	secrets     = map[string][]string{}
+	secretsLock sync.RWMutex
+)
+
+// SetShowSecrets disables (true) or enables (false, default) the masking of secrets (e.g. cluster tokens) in log messages
+func SetShowSecrets(show bool) {
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+	showSecrets = show
+}
+
+// registerSecret masks a secret of a cluster in all log messages, until the cluster is deleted
+func registerSecret(cluster, secret string) {
+	if secret == "" {
+		return
+	}
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+	for _, known := range secrets[cluster] {
+		if known == secret {
+			return
+		}
+	}
+	secrets[cluster] = append(secrets[cluster], secret)
+}
+
+// forgetSecrets stops masking the secrets of a deleted cluster
+func forgetSecrets(cluster string) {
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+	delete(secrets, cluster)
+}
+
+// registerSecretValues registers the secrets of a cluster in args or env vars (see secretPrefixes and secretFlags)
+func registerSecretValues(cluster string, values []string) {
+	for i := 0; i < len(values); i++ {
+		// args and env vars may have node specifiers, e.g. --token=<secret>@server
+		value, _ := splitNodeSpecifiers(values[i], "")
+		for _, prefix := range secretPrefixes {
+			if strings.HasPrefix(value, prefix) {
+				registerSecret(cluster, strings.TrimPrefix(value, prefix))
+			}
+		}
+		if isSecretFlag(value) && i+1 < len(values) {
+			i++
+			secret, _ := splitNodeSpecifiers(values[i], "")
+			registerSecret(cluster, secret)
+		}
+	}
+}
+
+// maskSecrets replaces the registered secrets in a message, unless secrets are shown
+func maskSecrets(message string) string {
+	secretsLock.RLock()
+	defer secretsLock.RUnlock()
+	if showSecrets {
+		return message
+	}
+	for _, clusterSecrets := range secrets {
+		for _, secret := range clusterSecrets {
+			message = strings.ReplaceAll(message, secret, secretMask)
+		}
+	}
+	return message
+}
+
+// redactSecretValue replaces the secret of an arg or env var introducing one (see secretPrefixes) with secretMask,
+// keeping its node specifiers. Unlike maskSecrets, it doesn't depend on registered secrets or the SecretMaskingHook.
+// It returns false if the value doesn't contain a secret.
+func redactSecretValue(spec string) (string, bool) {
+	value, _ := splitNodeSpecifiers(spec, "")
+	for _, prefix := range secretPrefixes {
+		if strings.HasPrefix(value, prefix) {
+			return prefix + secretMask + spec[len(value):], true
+		}
+	}
+	return spec, false
+}
+
+// isSecretFlag checks if an arg is a flag whose value is given in the next arg (see secretFlags)
+func isSecretFlag(arg string) bool {
+	for _, flag := range secretFlags {
+		if arg == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// redactSecretValues redacts the secrets of args or env vars (see redactSecretValue and secretFlags) for log messages,
+// unless secrets are shown
+func redactSecretValues(values []string) []string {
+	secretsLock.RLock()
+	show := showSecrets
+	secretsLock.RUnlock()
+	if show {
+		return values
+	}
+	redacted := []string{}
+	for i := 0; i < len(values); i++ {
+		value, _ := redactSecretValue(values[i])
+		redacted = append(redacted, value)
+		if flag, _ := splitNodeSpecifiers(values[i], ""); isSecretFlag(flag) && i+1 < len(values) {
+			// the secret is the next arg, keeping its node specifiers
+			i++
+			secret, _ := splitNodeSpecifiers(values[i], "")
+			redacted = append(redacted, secretMask+values[i][len(secret):])
+		}
+	}
+	return redacted
+}
+
+// SecretMaskingHook returns a logrus hook which masks secrets (e.g. cluster tokens) in log messages and fields
+// (e.g. errors), unless they're shown (see SetShowSecrets). It has to be added to the logger explicitly,
+// e.g. `log.AddHook(k3d.SecretMaskingHook())`, so that importing the package doesn't change the logger.
+func SecretMaskingHook() log.Hook {
+	return secretMaskingHook{}
+}
+
+// secretMaskingHook masks the registered secrets in log messages and fields (see SecretMaskingHook)
+type secretMaskingHook struct{}
+
+func (secretMaskingHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (secretMaskingHook) Fire(entry *log.Entry) error {
+	entry.Message = maskSecrets(entry.Message)
+	for key, value := range entry.Data {
+		switch value := value.(type) {
+		case string:
+			entry.Data[key] = maskSecrets(value)
+		case error:
+			entry.Data[key] = maskSecrets(value.Error())
+		}
+	}
+	return nil
+}
+
+// generateToken generates a random cluster token using a cryptographically secure random number generator
+func generateToken() (string, error) {
+	token := make([]byte, tokenBytes)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("couldn't generate a token\n%+v", err)
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// GetClusterToken returns the token the nodes of a cluster use to join it, read from the server container
+// (K3S_TOKEN, or K3S_CLUSTER_SECRET for clusters created by older k3d versions)
+func (k *Client) GetClusterToken(ctx context.Context, name string) (string, error) {
+	cluster, err := k.GetCluster(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	info, err := k.rt.InspectContainer(ctx, cluster.Server.ID)
+	if err != nil {
+		return "", fmt.Errorf("couldn't inspect container %s\n%+v", cluster.Server.Name, err)
+	}
+	token := ""
+	for _, env := range info.Config.Env {
+		key, value, _ := strings.Cut(env, "=")
+		switch {
+		case key == "K3S_TOKEN":
+			return value, nil
+		case key == "K3S_CLUSTER_SECRET":
+			token = value
+		}
+	}
+	if token == "" {
+		return "", fmt.Errorf("couldn't find the token of cluster %s", name)
+	}
+	return token, nil
+}
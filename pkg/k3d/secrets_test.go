@@ -0,0 +1,104 @@
+package k3d
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestSecretMaskingHookIsOptIn(t *testing.T) {
+	registerSecretValues("test", []string{"--token=hooked"})
+	for _, hooks := range log.StandardLogger().Hooks {
+		for _, hook := range hooks {
+			if _, ok := hook.(secretMaskingHook); ok {
+				t.Fatal("importing the package added the secret masking hook to the standard logger")
+			}
+		}
+	}
+
+	buffer := &bytes.Buffer{}
+	logger := log.New()
+	logger.SetOutput(buffer)
+	logger.AddHook(SecretMaskingHook())
+	logger.WithError(errors.New("token hooked")).Info("token hooked")
+	if strings.Contains(buffer.String(), "hooked") {
+		t.Errorf("log output = %q, want the secret masked", buffer.String())
+	}
+}
+
+func TestDebugLogsDontContainSecretsWithoutHook(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	logger := log.StandardLogger()
+	output, level := logger.Out, logger.GetLevel()
+	logger.SetOutput(buffer)
+	logger.SetLevel(log.DebugLevel)
+	defer func() {
+		logger.SetOutput(output)
+		logger.SetLevel(level)
+	}()
+
+	k, _ := newTestClient(t)
+	spec := &ClusterSpec{
+		Name:       "test",
+		Token:      "clustertoken",
+		ServerArgs: []string{"--agent-token=agenttoken@server", "--token@server", "flagtoken@server"},
+		AgentArgs:  []string{"-t", "shortflagtoken"},
+		Env:        []string{"K3S_TOKEN=envtoken@all"},
+	}
+	if _, err := k.CreateCluster(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"clustertoken", "agenttoken", "flagtoken", "shortflagtoken", "envtoken"} {
+		if strings.Contains(buffer.String(), secret) {
+			t.Errorf("the debug logs contain the secret %q:\n%s", secret, buffer.String())
+		}
+	}
+}
+
+func TestRedactSecretValue(t *testing.T) {
+	for _, test := range []struct {
+		value    string
+		redacted string
+		secret   bool
+	}{
+		{value: "--token=secret", redacted: "--token=" + secretMask, secret: true},
+		{value: "K3S_TOKEN=p@ss@server", redacted: "K3S_TOKEN=" + secretMask + "@server", secret: true},
+		{value: "--node-label=token=secret", redacted: "--node-label=token=secret"},
+		{value: "FOO=bar@all", redacted: "FOO=bar@all"},
+	} {
+		if redacted, secret := redactSecretValue(test.value); redacted != test.redacted || secret != test.secret {
+			t.Errorf("redactSecretValue(%q) = %q, %t, want %q, %t", test.value, redacted, secret, test.redacted, test.secret)
+		}
+	}
+}
+
+func TestRedactSecretValues(t *testing.T) {
+	values := []string{"--token", "secret@server", "--agent-token=agent", "--node-label=a=b", "-t", "short", "--token"}
+	want := []string{"--token", secretMask + "@server", "--agent-token=" + secretMask, "--node-label=a=b", "-t", secretMask, "--token"}
+	if redacted := redactSecretValues(values); strings.Join(redacted, " ") != strings.Join(want, " ") {
+		t.Errorf("redactSecretValues(%q) = %q, want %q", values, redacted, want)
+	}
+}
+
+func TestSecretsAreForgottenWithTheirCluster(t *testing.T) {
+	k, _ := newTestClient(t)
+	spec := &ClusterSpec{Name: "forgotten", Token: "forgottentoken", ServerArgs: []string{"--token", "flagsecret"}}
+	if _, err := k.CreateCluster(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+	if masked := maskSecrets("forgottentoken flagsecret"); masked != secretMask+" "+secretMask {
+		t.Errorf("maskSecrets() = %q, want both secrets masked", masked)
+	}
+	if err := k.DeleteCluster(context.Background(), "forgotten"); err != nil {
+		t.Fatal(err)
+	}
+	secretsLock.RLock()
+	defer secretsLock.RUnlock()
+	if _, ok := secrets["forgotten"]; ok {
+		t.Error("the secrets of the deleted cluster are still registered")
+	}
+}
@@ -0,0 +1,594 @@
+package k3d
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// snapshotMetadataFile is the first entry of a snapshot archive
+	snapshotMetadataFile = "snapshot.json"
+	// snapshotFileExtension is the extension of snapshot archives (gzipped tar)
+	snapshotFileExtension = ".tar.gz"
+	// snapshotNodesDir contains one tar archive per node (rooted at /), named after the node, e.g. nodes/worker-0.tar
+	snapshotNodesDir = "nodes"
+	// snapshotClusterDir contains the files of the cluster directory (kubeconfig, spec with token)
+	snapshotClusterDir = "cluster"
+)
+
+// The directories archived per node: the datastore, certificates and token of the server, the data of
+// local-path volumes and the node password, which a node needs to rejoin the restored server under its name.
+// Container images are left out, they are pulled again.
+var (
+	serverSnapshotPaths = []string{"/var/lib/rancher/k3s/server", "/var/lib/rancher/k3s/storage", "/etc/rancher/node"}
+	workerSnapshotPaths = []string{"/var/lib/rancher/k3s/storage", "/etc/rancher/node"}
+)
+
+// Snapshot describes a saved snapshot of a cluster
+type Snapshot struct {
+	Name    string
+	Cluster string
+	Created time.Time
+	// Path is the location of the snapshot archive
+	Path string
+	Size int64
+}
+
+// snapshotMetadata is stored as snapshotMetadataFile in the snapshot archive
+type snapshotMetadata struct {
+	Name    string
+	Cluster string
+	Created time.Time
+	// Spec is the resolved spec of the cluster, including its token
+	Spec ClusterSpec
+	// Nodes are the short names of the archived nodes (e.g. server, worker-0)
+	Nodes []string
+}
+
+// RestoreOptions describe how a cluster is restored from a snapshot
+type RestoreOptions struct {
+	// Snapshot is either the path of a snapshot archive or a snapshot reference `<cluster>/<snapshot>` (see ListSnapshots)
+	Snapshot string
+	// Name of the restored cluster (default: the name of the cluster the snapshot was taken of).
+	// If it differs, the original nodes remain in the cluster as NotReady nodes.
+	Name string
+	// APIPort overrides the API port of the snapshot, format `[host:]port`
+	APIPort string
+	// Replace deletes an existing cluster with the same name before restoring
+	Replace bool
+	// Wait blocks until the server of the restored cluster is up, at most for Timeout (0 = forever).
+	// They replace the ones of the snapshot, which applied to the creation of the original cluster.
+	Wait    bool
+	Timeout time.Duration
+}
+
+// getSnapshotDir returns the directory in which the snapshots of a cluster are saved by default,
+// which is $HOME/.config/k3d/.snapshots/<cluster_name> (the dot prevents collisions with cluster names)
+func getSnapshotDir(clusterName string) (string, error) {
+	configDir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(configDir, ".snapshots", clusterName), nil
+}
+
+// nodeShortName returns the name of a node without the prefix of its cluster, e.g. worker-0 for k3d-mycluster-worker-0
+func nodeShortName(clusterName, containerName string) string {
+	return strings.TrimPrefix(containerName, fmt.Sprintf("%s-%s-", defaultContainerNamePrefix, clusterName))
+}
+
+// SaveSnapshot stops a cluster and saves the k3s state of its nodes and its cluster directory (kubeconfig, spec including
+// the token) in a snapshot archive.
+// The snapshot is named after the current time if name is empty, and it's saved to the snapshot directory of the cluster
+// unless an output path is given. The cluster is started again afterwards, if it was running.
+func (k *Client) SaveSnapshot(ctx context.Context, clusterName, name, output string) (*Snapshot, error) {
+	rt := k.rt
+	cluster, err := k.GetCluster(ctx, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := readClusterSpec(clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		name = time.Now().Format("20060102-150405")
+	}
+	if err := ValidateHostname(name); err != nil {
+		return nil, fmt.Errorf("invalid snapshot name\n%+v", err)
+	}
+	if output == "" {
+		snapshotDir, err := getSnapshotDir(clusterName)
+		if err != nil {
+			return nil, err
+		}
+		if err := createDirIfNotExists(snapshotDir); err != nil {
+			return nil, fmt.Errorf("couldn't create snapshot directory %s\n%+v", snapshotDir, err)
+		}
+		output = path.Join(snapshotDir, name+snapshotFileExtension)
+	}
+	if _, err := os.Stat(output); err == nil {
+		return nil, fmt.Errorf("snapshot %s exists already", output)
+	}
+
+	// the datastore must not change while it's archived
+	if cluster.Status == "running" {
+		if err := k.StopCluster(ctx, clusterName); err != nil {
+			return nil, err
+		}
+		defer func() {
+			// start the cluster again even if ctx was canceled
+			startCtx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+			defer cancel()
+			if err := k.StartCluster(startCtx, clusterName); err != nil {
+				log.Errorf("Couldn't start cluster %s again: %+v", clusterName, err)
+			}
+		}()
+	}
+
+	log.Infof("Saving snapshot %s of cluster [%s]", name, clusterName)
+	metadata := snapshotMetadata{
+		Name:    name,
+		Cluster: clusterName,
+		Created: time.Now(),
+		Spec:    *spec,
+	}
+	nodes := append([]Node{cluster.Server}, cluster.Workers...)
+	for _, node := range nodes {
+		metadata.Nodes = append(metadata.Nodes, nodeShortName(clusterName, node.Name))
+	}
+
+	// write to a temporary file first, so that no partial snapshot is left behind
+	tmpOutput := output + ".tmp"
+	file, err := os.OpenFile(tmpOutput, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create snapshot %s\n%+v", output, err)
+	}
+	defer os.Remove(tmpOutput)
+	defer file.Close()
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	content, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeTarEntry(tarWriter, snapshotMetadataFile, int64(len(content)), strings.NewReader(string(content))); err != nil {
+		return nil, err
+	}
+
+	for _, node := range nodes {
+		paths := workerSnapshotPaths
+		if node.Role == "server" {
+			paths = serverSnapshotPaths
+		}
+		log.Infof("...Saving node %s", node.Name)
+		if err := addNodeToSnapshot(ctx, rt, tarWriter, node, nodeShortName(clusterName, node.Name), paths); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := addClusterDirToSnapshot(tarWriter, clusterName); err != nil {
+		return nil, err
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpOutput, output); err != nil {
+		return nil, fmt.Errorf("couldn't create snapshot %s\n%+v", output, err)
+	}
+
+	info, err := os.Stat(output)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Saved snapshot %s of cluster [%s]", name, clusterName)
+	return &Snapshot{Name: name, Cluster: clusterName, Created: metadata.Created, Path: output, Size: info.Size()}, nil
+}
+
+// writeTarEntry adds a regular file to a tar archive
+func writeTarEntry(tarWriter *tar.Writer, name string, size int64, content io.Reader) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(tarWriter, content)
+	return err
+}
+
+// addNodeToSnapshot archives the given paths of a node (rooted at /) and adds the archive as nodes/<name>.tar to the snapshot.
+// Paths that don't exist in the node are skipped. The archive is buffered in a temporary file, since its size has to be known.
+func addNodeToSnapshot(ctx context.Context, rt Runtime, tarWriter *tar.Writer, node Node, name string, paths []string) error {
+	buffer, err := os.CreateTemp("", "k3d-snapshot-*.tar")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(buffer.Name())
+	defer buffer.Close()
+
+	nodeWriter := tar.NewWriter(buffer)
+	for _, srcPath := range paths {
+		reader, err := rt.CopyFromContainer(ctx, node.ID, srcPath)
+		if errdefs.IsNotFound(err) {
+			log.Debugf("Skipping %s of node %s, it doesn't exist", srcPath, node.Name)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("couldn't copy %s from node %s\n%+v", srcPath, node.Name, err)
+		}
+		// docker archives a directory under its base name, so the entries are moved to the full path
+		err = reparentTarEntries(tar.NewReader(reader), nodeWriter, strings.TrimPrefix(path.Dir(srcPath), "/"))
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("couldn't archive %s of node %s\n%+v", srcPath, node.Name, err)
+		}
+	}
+	if err := nodeWriter.Close(); err != nil {
+		return err
+	}
+
+	size, err := buffer.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := buffer.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return writeTarEntry(tarWriter, path.Join(snapshotNodesDir, name+".tar"), size, buffer)
+}
+
+// reparentTarEntries copies all entries of a tar archive to another one, prefixing their names (and hard link targets) with parent
+func reparentTarEntries(reader *tar.Reader, writer *tar.Writer, parent string) error {
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(parent, header.Name)
+		if header.Typeflag == tar.TypeDir {
+			header.Name += "/"
+		}
+		if header.Typeflag == tar.TypeLink {
+			header.Linkname = path.Join(parent, header.Linkname)
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(writer, reader); err != nil {
+			return err
+		}
+	}
+}
+
+// addClusterDirToSnapshot adds the files of the cluster directory (without subdirectories, i.e. without imported images) to the snapshot
+func addClusterDirToSnapshot(tarWriter *tar.Writer, clusterName string) error {
+	clusterDir, err := getClusterDir(clusterName)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(clusterDir)
+	if err != nil {
+		return fmt.Errorf("couldn't read cluster directory %s\n%+v", clusterDir, err)
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		file, err := os.Open(path.Join(clusterDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		info, err := file.Stat()
+		if err == nil {
+			err = writeTarEntry(tarWriter, path.Join(snapshotClusterDir, entry.Name()), info.Size(), file)
+		}
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("couldn't archive %s\n%+v", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// resolveSnapshotPath returns the path of a snapshot archive given either as a path or as `<cluster>/<snapshot>`
+func resolveSnapshotPath(snapshot string) (string, error) {
+	if _, err := os.Stat(snapshot); err == nil {
+		return snapshot, nil
+	}
+	parts := strings.Split(snapshot, "/")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("snapshot %s not found, expected a file or <cluster>/<snapshot>", snapshot)
+	}
+	snapshotDir, err := getSnapshotDir(parts[0])
+	if err != nil {
+		return "", err
+	}
+	snapshotPath := path.Join(snapshotDir, parts[1]+snapshotFileExtension)
+	if _, err := os.Stat(snapshotPath); err != nil {
+		return "", fmt.Errorf("snapshot %s not found\n%+v", snapshot, err)
+	}
+	return snapshotPath, nil
+}
+
+// RestoreSnapshot creates a new cluster from a snapshot: the cluster is created with the spec (and token) of the
+// snapshot (see restorableSpec) and the k3s state of every node is copied into it before it's started.
+// The kubeconfig of the snapshot is restored as well, since the certificates are restored along with the datastore,
+// unless the API port is overridden: then it's created anew (see GetKubeconfig).
+// The other files of the archived cluster directory aren't restored: createCluster writes the spec of the restored cluster.
+func (k *Client) RestoreSnapshot(ctx context.Context, opts RestoreOptions) (*Cluster, error) {
+	snapshotPath, err := resolveSnapshotPath(opts.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	// the node archives are extracted, since they're copied into the nodes while they are created
+	tmpDir, err := os.MkdirTemp("", "k3d-restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	metadata, nodeArchives, clusterFiles, err := extractSnapshot(snapshotPath, tmpDir)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := restorableSpec(metadata.Spec)
+	if opts.Name != "" {
+		spec.rename(opts.Name)
+	}
+	if opts.APIPort != "" {
+		spec.APIPort = opts.APIPort
+	}
+	spec.Wait = opts.Wait
+	spec.Timeout = opts.Timeout
+
+	if opts.Replace {
+		if err := k.DeleteCluster(ctx, spec.Name); err != nil && !errors.Is(err, ErrClusterNotFound) {
+			return nil, err
+		}
+	}
+
+	nodeData := map[string]string{}
+	for _, node := range metadata.Nodes {
+		archive, ok := nodeArchives[node]
+		if !ok {
+			return nil, fmt.Errorf("snapshot %s is missing the data of node %s", snapshotPath, node)
+		}
+		nodeData[fmt.Sprintf("%s-%s-%s", defaultContainerNamePrefix, spec.Name, node)] = archive
+	}
+
+	log.Infof("Restoring snapshot %s of cluster [%s] as cluster [%s]", metadata.Name, metadata.Cluster, spec.Name)
+	cluster, err := k.createCluster(ctx, &spec, nodeData)
+	if err != nil {
+		return nil, err
+	}
+	if kubeconfig, ok := clusterFiles[clusterKubeconfigFile]; ok && opts.APIPort == "" {
+		if err := restoreKubeconfig(kubeconfig, spec.Name); err != nil {
+			log.Warnf("Couldn't restore the kubeconfig of cluster %s, it's created anew: %+v", spec.Name, err)
+		}
+	}
+	return cluster, nil
+}
+
+// restoreKubeconfig copies the kubeconfig of a snapshot into the cluster directory
+func restoreKubeconfig(kubeconfig, clusterName string) error {
+	content, err := os.ReadFile(kubeconfig)
+	if err != nil {
+		return err
+	}
+	destPath, err := getClusterKubeConfigPath(clusterName)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(destPath, content, 0600)
+}
+
+// restorableSpec adapts the spec of a snapshot to the machine it's restored on, which might not have the files of the
+// machine the snapshot was taken on: manifests and helm charts are left out, since k3s keeps the applied ones in its
+// datastore, and so are volumes whose host path doesn't exist.
+func restorableSpec(spec ClusterSpec) ClusterSpec {
+	spec.Manifests = nil
+	spec.HelmCharts = nil
+	volumes := []string{}
+	for _, volume := range spec.Volumes {
+		source, _, _ := strings.Cut(volume, ":")
+		if filepath.IsAbs(source) || strings.HasPrefix(source, ".") {
+			if _, err := os.Stat(source); err != nil {
+				log.Warnf("Skipping volume %s, its host path doesn't exist: %+v", volume, err)
+				continue
+			}
+		}
+		volumes = append(volumes, volume)
+	}
+	spec.Volumes = volumes
+	return spec
+}
+
+// extractSnapshot reads the metadata of a snapshot archive and extracts its node archives and the files of its cluster
+// directory into dir. It returns the metadata, the paths of the node archives by node name and the paths of the files
+// of the cluster directory by file name (snapshots of older k3d versions might not have any).
+func extractSnapshot(snapshotPath, dir string) (*snapshotMetadata, map[string]string, map[string]string, error) {
+	file, err := os.Open(snapshotPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid snapshot %s\n%+v", snapshotPath, err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+	clusterDir := filepath.Join(dir, snapshotClusterDir)
+	if err := createDirIfNotExists(clusterDir); err != nil {
+		return nil, nil, nil, err
+	}
+
+	var metadata *snapshotMetadata
+	nodeArchives := map[string]string{}
+	clusterFiles := map[string]string{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid snapshot %s\n%+v", snapshotPath, err)
+		}
+
+		switch entryDir, name := path.Split(header.Name); {
+		case header.Name == snapshotMetadataFile:
+			metadata = &snapshotMetadata{}
+			if err := json.NewDecoder(tarReader).Decode(metadata); err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid snapshot metadata in %s\n%+v", snapshotPath, err)
+			}
+		case entryDir == snapshotNodesDir+"/" && strings.HasSuffix(name, ".tar"):
+			archivePath := filepath.Join(dir, name)
+			if err := extractTarEntry(tarReader, archivePath); err != nil {
+				return nil, nil, nil, err
+			}
+			nodeArchives[strings.TrimSuffix(name, ".tar")] = archivePath
+		case entryDir == snapshotClusterDir+"/" && name != "":
+			filePath := filepath.Join(clusterDir, name)
+			if err := extractTarEntry(tarReader, filePath); err != nil {
+				return nil, nil, nil, err
+			}
+			clusterFiles[name] = filePath
+		}
+	}
+	if metadata == nil {
+		return nil, nil, nil, fmt.Errorf("invalid snapshot %s: %s is missing", snapshotPath, snapshotMetadataFile)
+	}
+	return metadata, nodeArchives, clusterFiles, nil
+}
+
+// extractTarEntry writes the content of the current entry of a tar archive to a new file
+func extractTarEntry(reader *tar.Reader, filePath string) error {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return fmt.Errorf("couldn't extract %s\n%+v", filePath, err)
+	}
+	return file.Close()
+}
+
+// restoreNodeData returns a function which copies the data of a restored node (see RestoreSnapshot)
+// into its container before it's started, or nil if there's nothing to restore
+func (spec *clusterConfig) restoreNodeData(ctx context.Context, rt Runtime, containerName string) func(ID string) error {
+	archive, ok := spec.NodeData[containerName]
+	if !ok {
+		return nil
+	}
+	return func(ID string) error {
+		file, err := os.Open(archive)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		log.Debugf("Restoring data of node %s", containerName)
+		if err := rt.CopyToContainer(ctx, ID, "/", file); err != nil {
+			return fmt.Errorf("couldn't restore data of node %s\n%+v", containerName, err)
+		}
+		return nil
+	}
+}
+
+// ListSnapshots returns the snapshots in the snapshot directory of a cluster, oldest first.
+// The cluster itself doesn't need to exist anymore.
+func ListSnapshots(clusterName string) ([]Snapshot, error) {
+	snapshotDir, err := getSnapshotDir(clusterName)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(snapshotDir)
+	if os.IsNotExist(err) {
+		return []Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []Snapshot{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasSuffix(entry.Name(), snapshotFileExtension) {
+			continue
+		}
+		snapshotPath := path.Join(snapshotDir, entry.Name())
+		metadata, err := readSnapshotMetadata(snapshotPath)
+		if err != nil {
+			log.Warnf("Skipping snapshot %s: %+v", snapshotPath, err)
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, Snapshot{
+			Name:    metadata.Name,
+			Cluster: metadata.Cluster,
+			Created: metadata.Created,
+			Path:    snapshotPath,
+			Size:    info.Size(),
+		})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Created.Before(snapshots[j].Created) })
+	return snapshots, nil
+}
+
+// readSnapshotMetadata reads the metadata of a snapshot, which is the first entry of the archive
+func readSnapshotMetadata(snapshotPath string) (*snapshotMetadata, error) {
+	file, err := os.Open(snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	tarReader := tar.NewReader(gzipReader)
+	header, err := tarReader.Next()
+	if err != nil {
+		return nil, err
+	}
+	if header.Name != snapshotMetadataFile {
+		return nil, fmt.Errorf("%s is missing", snapshotMetadataFile)
+	}
+	metadata := &snapshotMetadata{}
+	if err := json.NewDecoder(tarReader).Decode(metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
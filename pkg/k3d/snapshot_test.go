@@ -0,0 +1,189 @@
+package k3d
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestoreSnapshotWithoutHostFiles(t *testing.T) {
+	ctx := context.Background()
+	k, rt := newTestClient(t)
+
+	hostDir := t.TempDir()
+	manifest := filepath.Join(hostDir, "app.yaml")
+	if err := os.WriteFile(manifest, []byte("kind: ConfigMap\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	spec := &ClusterSpec{
+		Name:      "test",
+		Workers:   1,
+		Manifests: []string{manifest},
+		Volumes:   []string{hostDir + ":/host", "shared:/shared"},
+	}
+	cluster, err := k.CreateCluster(ctx, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.CopyToContainer(ctx, cluster.Server.ID, "/", tarFile(t, "var/lib/rancher/k3s/server/db/state.db", []byte("state"))); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := k.SaveSnapshot(ctx, "test", "before", filepath.Join(t.TempDir(), "before.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the snapshot contains the metadata, the nodes and the cluster directory
+	file, err := os.Open(snapshot.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []string{}
+	tarReader := tar.NewReader(gzipReader)
+	for header, err := tarReader.Next(); err == nil; header, err = tarReader.Next() {
+		entries = append(entries, header.Name)
+	}
+	if want := []string{snapshotMetadataFile, "nodes/server.tar", "nodes/worker-0.tar", "cluster/spec.json"}; !reflect.DeepEqual(entries, want) {
+		t.Errorf("snapshot entries = %v, want %v", entries, want)
+	}
+
+	// restoring on another machine: the manifest and the host path of the volume don't exist there
+	if err := os.RemoveAll(hostDir); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := k.RestoreSnapshot(ctx, RestoreOptions{Snapshot: snapshot.Path, Replace: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := string(rt.Files[restored.Server.ID]["/var/lib/rancher/k3s/server/db/state.db"]); state != "state" {
+		t.Errorf("restored datastore = %q, want %q", state, "state")
+	}
+	info, err := rt.InspectContainer(ctx, restored.Server.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binds := strings.Join(info.HostConfig.Binds, " ")
+	if strings.Contains(binds, ":/host") || !strings.Contains(binds, "shared:/shared") {
+		t.Errorf("binds of the restored server = %s, want the named volume without the missing host path", binds)
+	}
+	restoredSpec, err := readClusterSpec("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restoredSpec.Manifests) != 0 {
+		t.Errorf("manifests of the restored cluster = %v, want none", restoredSpec.Manifests)
+	}
+}
+
+func TestRestoreSnapshotUnderNewName(t *testing.T) {
+	ctx := context.Background()
+	k, rt := newTestClient(t)
+
+	spec := &ClusterSpec{
+		Name:       "old",
+		Workers:    1,
+		Env:        []string{"ROLE=db@k3d-old-worker-0"},
+		NodeLabels: []string{"tier=db@k3d-old-worker-0"},
+	}
+	cluster, err := k.CreateCluster(ctx, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kubeconfig := []byte("clusters:\n- cluster:\n    server: https://127.0.0.1:6443\n")
+	if err := rt.CopyToContainer(ctx, cluster.Server.ID, "/output", tarFile(t, "kubeconfig.yaml", kubeconfig)); err != nil {
+		t.Fatal(err)
+	}
+	kubeconfigPath, err := k.GetKubeconfig(ctx, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	originalKubeconfig, err := os.ReadFile(kubeconfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := k.SaveSnapshot(ctx, "old", "seeded", filepath.Join(t.TempDir(), "seeded.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := k.RestoreSnapshot(ctx, RestoreOptions{Snapshot: snapshot.Path, Name: "new", APIPort: "6444"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.Workers) != 1 || restored.Workers[0].Name != "k3d-new-worker-0" {
+		t.Fatalf("restored workers = %+v, want k3d-new-worker-0", restored.Workers)
+	}
+	info, err := rt.InspectContainer(ctx, restored.Workers[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env := strings.Join(info.Config.Env, " "); !strings.Contains(env, "ROLE=db") {
+		t.Errorf("env of the restored worker = %s, want the env var of the renamed node specifier", env)
+	}
+	if labels := restored.Workers[0].NodeLabels(); !reflect.DeepEqual(labels, []string{"tier=db"}) {
+		t.Errorf("node labels of the restored worker = %v, want [tier=db]", labels)
+	}
+	// the API port differs, so the kubeconfig is created anew
+	if path, _ := getClusterKubeConfigPath("new"); fileExists(path) {
+		t.Error("the kubeconfig of the snapshot was restored with another API port")
+	}
+
+	// with the same API port, the kubeconfig of the snapshot is restored, since the certificates are restored as well
+	if err := k.DeleteCluster(ctx, "old"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.RestoreSnapshot(ctx, RestoreOptions{Snapshot: snapshot.Path, Name: "same"}); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := getClusterKubeConfigPath("same")
+	if content, err := os.ReadFile(path); err != nil {
+		t.Error(err)
+	} else if string(content) != string(originalKubeconfig) {
+		t.Errorf("restored kubeconfig = %q, want %q", content, originalKubeconfig)
+	}
+}
+
+func TestRestoreSnapshotWaitsAsRequested(t *testing.T) {
+	ctx := context.Background()
+	k, _ := newTestClient(t)
+	if _, err := k.CreateCluster(ctx, &ClusterSpec{Name: "test", Wait: true, Timeout: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := k.SaveSnapshot(ctx, "test", "waited", filepath.Join(t.TempDir(), "waited.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range []RestoreOptions{
+		{Snapshot: snapshot.Path, Replace: true},
+		{Snapshot: snapshot.Path, Replace: true, Wait: true, Timeout: 2 * time.Minute},
+	} {
+		if _, err := k.RestoreSnapshot(ctx, opts); err != nil {
+			t.Fatal(err)
+		}
+		spec, err := readClusterSpec("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if spec.Wait != opts.Wait || spec.Timeout != opts.Timeout {
+			t.Errorf("restored with wait %v and timeout %s, want %v and %s of the restore options", spec.Wait, spec.Timeout, opts.Wait, opts.Timeout)
+		}
+	}
+}
+
+// fileExists checks if a file exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
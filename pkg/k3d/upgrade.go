@@ -0,0 +1,352 @@
+package k3d
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultUpgradeTimeout is the time a node may take to become Ready after it was recreated
+const defaultUpgradeTimeout = 5 * time.Minute
+
+// upgradeBackupSuffix is appended to the name of a node container while it's being replaced
+const upgradeBackupSuffix = "-pre-upgrade"
+
+// k3sNodeConfigDir is the directory in which k3s keeps the node password, which a node needs to rejoin the cluster
+const k3sNodeConfigDir = "/etc/rancher/node"
+
+// k3sVersionTagRegexp matches image tags that are k3s versions, e.g. v1.29.1-k3s1
+var k3sVersionTagRegexp = regexp.MustCompile(`^v\d+\.\d+\.\d+-k3s\d+$`)
+
+// UpgradeSpec describes an in-place upgrade of a cluster to another k3s image
+type UpgradeSpec struct {
+	Name string
+	// Image is the new k3s image of all nodes
+	Image string
+	// Timeout limits the time a single node may take to become Ready (default: 5 minutes)
+	Timeout time.Duration
+	// Pull defines when the image is pulled (default: PullMissing)
+	Pull PullPolicy
+	// Verbose prints every progress update of the image pull if stderr is not a terminal, not only the status changes of the layers
+	Verbose bool
+}
+
+// upgradedNode is a node that was replaced successfully, which is rolled back if a later node fails
+type upgradedNode struct {
+	node          Node
+	previousImage string
+}
+
+// UpgradeCluster recreates the nodes of a cluster one at a time (server first, then the workers) with a new image.
+// Every node keeps its volumes (i.e. its data), node password, name, ports, environment and labels, and has to become Ready
+// before the next one is upgraded. If a node fails, it's restored and all nodes that were upgraded
+// already are rolled back to their previous image.
+func (k *Client) UpgradeCluster(ctx context.Context, spec *UpgradeSpec) error {
+	rt := k.rt
+	cluster, err := k.GetCluster(ctx, spec.Name)
+	if err != nil {
+		return err
+	}
+
+	image := resolveImage(spec.Image)
+	pullPolicy := spec.Pull
+	if pullPolicy == "" {
+		pullPolicy = PullMissing
+	}
+	if err := ensureImage(ctx, rt, image, pullPolicy, spec.Verbose); err != nil {
+		return err
+	}
+	timeout := spec.Timeout
+	if timeout == 0 {
+		timeout = defaultUpgradeTimeout
+	}
+
+	log.Infof("Upgrading cluster [%s] to %s", cluster.Name, image)
+	serverID := cluster.Server.ID
+	upgraded := []upgradedNode{}
+	for _, node := range append([]Node{cluster.Server}, cluster.Workers...) {
+		if sameImage(node.Image, image) {
+			log.Infof("...Node %s already runs %s", node.Name, image)
+			continue
+		}
+		log.Infof("...Upgrading node %s (%s -> %s)", node.Name, node.Image, image)
+		newID, err := replaceNode(ctx, rt, node, image, serverID, timeout)
+		if err != nil {
+			log.Errorf("Upgrading node %s failed, restored it with image %s: %+v", node.Name, node.Image, err)
+			if !rollbackUpgrade(rt, upgraded, serverID, timeout) {
+				return fmt.Errorf("couldn't upgrade cluster %s and couldn't roll back all nodes\n%+v", cluster.Name, err)
+			}
+			return fmt.Errorf("couldn't upgrade cluster %s, rolled back to the previous image\n%+v", cluster.Name, err)
+		}
+		if node.Role == "server" {
+			serverID = newID
+		}
+		previousImage := node.Image
+		node.ID, node.Image = newID, image
+		upgraded = append(upgraded, upgradedNode{node: node, previousImage: previousImage})
+	}
+
+	log.Infof("Upgraded cluster [%s] to %s", cluster.Name, image)
+	return nil
+}
+
+// rollbackUpgrade replaces the upgraded nodes with their previous image in reverse order (i.e. the server last).
+// It runs with its own context, since the context of the upgrade might have been canceled already.
+// Each node gets the timeout for becoming Ready plus rollbackTimeout for replacing its container.
+// It returns false if a node couldn't be rolled back.
+func rollbackUpgrade(rt Runtime, upgraded []upgradedNode, serverID string, timeout time.Duration) bool {
+	ok := true
+	for i := len(upgraded) - 1; i >= 0; i-- {
+		node := upgraded[i].node
+		log.Infof("...Rolling back node %s to %s", node.Name, upgraded[i].previousImage)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout+rollbackTimeout)
+		newID, err := replaceNode(ctx, rt, node, upgraded[i].previousImage, serverID, timeout)
+		cancel()
+		if err != nil {
+			log.Errorf("Couldn't roll back node %s, it still runs %s: %+v", node.Name, node.Image, err)
+			ok = false
+			continue
+		}
+		if node.Role == "server" {
+			serverID = newID
+		}
+	}
+	return ok
+}
+
+// replaceNode recreates the container of a node with another image and waits until the node is Ready.
+// The old container is stopped and renamed, so that the new one can take over its name, ports and volumes,
+// and is only removed once the new one is Ready. If anything fails, the new container is removed
+// and the old one is restored. serverID is the server container used for checking the node status
+// (the new container itself, if the server is replaced). The ID of the new container is returned.
+func replaceNode(ctx context.Context, rt Runtime, node Node, image, serverID string, timeout time.Duration) (string, error) {
+	info, err := rt.InspectContainer(ctx, node.ID)
+	if err != nil {
+		return "", fmt.Errorf("couldn't inspect container %s\n%+v", node.Name, err)
+	}
+	// docker adds the environment of the old image to the container, which must not override the one of the new image
+	oldImageEnv, err := imageEnv(ctx, rt, info.Config.Image)
+	if err != nil {
+		log.Warnf("The environment of the old image is passed on to node %s: %+v", node.Name, err)
+	}
+	config, hostConfig, networkingConfig := replacementContainerConfig(info, node.Name, image, oldImageEnv)
+
+	// the old container must not run alongside the new one, since they share the ports and the data volumes
+	if err := rt.StopContainer(ctx, node.ID); err != nil {
+		return "", fmt.Errorf("couldn't stop container %s\n%+v", node.Name, err)
+	}
+	newID := ""
+	restore := func() {
+		// restore with a fresh context, since ctx might have been canceled already
+		restoreCtx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+		defer cancel()
+		if newID != "" {
+			if err := rt.RemoveContainer(restoreCtx, newID, false); err != nil {
+				log.Warnf("couldn't remove new container of node %s: %+v", node.Name, err)
+			}
+		}
+		if err := rt.RenameContainer(restoreCtx, node.ID, node.Name); err != nil {
+			log.Warnf("couldn't rename container %s back: %+v", node.Name+upgradeBackupSuffix, err)
+		}
+		if err := rt.StartContainer(restoreCtx, node.ID); err != nil {
+			log.Warnf("couldn't restart container %s: %+v", node.Name, err)
+		}
+	}
+
+	if err := rt.RenameContainer(ctx, node.ID, node.Name+upgradeBackupSuffix); err != nil {
+		restore()
+		return "", fmt.Errorf("couldn't rename container %s\n%+v", node.Name, err)
+	}
+	newID, err = rt.CreateContainer(ctx, node.Name, config, hostConfig, networkingConfig)
+	if err != nil {
+		restore()
+		return "", fmt.Errorf("couldn't create container %s\n%+v", node.Name, err)
+	}
+	if err := copyNodeConfig(ctx, rt, node.ID, newID); err != nil {
+		restore()
+		return "", fmt.Errorf("couldn't copy %s into the new container of node %s\n%+v", k3sNodeConfigDir, node.Name, err)
+	}
+	if err := rt.StartContainer(ctx, newID); err != nil {
+		restore()
+		return "", fmt.Errorf("couldn't start container %s\n%+v", node.Name, err)
+	}
+
+	if node.Role == "server" {
+		serverID = newID
+		if err := waitForServer(ctx, rt, serverID, timeout); err != nil {
+			restore()
+			return "", err
+		}
+	}
+	if err := waitForNodeReady(ctx, rt, serverID, config.Hostname, image, timeout); err != nil {
+		restore()
+		return "", err
+	}
+
+	// the volumes are used by the new container now, so they must not be removed along with the old one
+	if err := rt.RemoveContainer(ctx, node.ID, false); err != nil {
+		log.Warnf("couldn't remove old container of node %s: %+v", node.Name, err)
+	}
+	return newID, nil
+}
+
+// replacementContainerConfig derives the configuration of a container replacing the inspected one with another image.
+// Anonymous volumes (e.g. /var/lib/rancher/k3s, which is declared by the k3s image) are passed on by name,
+// so that the new container uses the same data. Docker doesn't remove them along with the new container anymore,
+// so their names are recorded in the adoptedVolumesLabel (see removeAdoptedVolumes).
+// The environment variables of the old image (oldImageEnv) are left out.
+func replacementContainerConfig(info types.ContainerJSON, name, image string, oldImageEnv map[string]bool) (*container.Config, *container.HostConfig, *network.NetworkingConfig) {
+	config := *info.Config
+	config.Image = image
+	config.Labels = map[string]string{}
+	for key, value := range info.Config.Labels {
+		config.Labels[key] = value
+	}
+	// the entrypoint and the default environment are taken from the new image
+	config.Entrypoint = nil
+	config.Env = []string{}
+	for _, variable := range info.Config.Env {
+		if !oldImageEnv[variable] {
+			config.Env = append(config.Env, variable)
+		}
+	}
+
+	hostConfig := *info.HostConfig
+	binds := append([]string{}, hostConfig.Binds...)
+	boundDestinations := map[string]bool{}
+	for _, bind := range binds {
+		if parts := strings.Split(bind, ":"); len(parts) > 1 {
+			boundDestinations[parts[1]] = true
+		}
+	}
+	adopted := adoptedVolumes(config.Labels)
+	for _, m := range info.Mounts {
+		if m.Type == mount.TypeVolume && !boundDestinations[m.Destination] {
+			binds = append(binds, fmt.Sprintf("%s:%s", m.Name, m.Destination))
+			adopted = append(adopted, m.Name)
+		}
+	}
+	hostConfig.Binds = binds
+	if len(adopted) > 0 {
+		config.Labels[adoptedVolumesLabel] = strings.Join(adopted, ",")
+	}
+
+	networkingConfig := &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{},
+	}
+	if info.NetworkSettings != nil {
+		for networkName := range info.NetworkSettings.Networks {
+			networkingConfig.EndpointsConfig[networkName] = &network.EndpointSettings{
+				Aliases: []string{name},
+			}
+		}
+	}
+	return &config, &hostConfig, networkingConfig
+}
+
+// copyNodeConfig copies /etc/rancher/node from the old container of a node into the new one before it's started.
+// It contains the node password, which k3s registers when the node joins for the first time and checks whenever
+// the node joins again: a node with a new password is rejected. Nodes without the directory are skipped.
+func copyNodeConfig(ctx context.Context, rt Runtime, oldID, newID string) error {
+	reader, err := rt.CopyFromContainer(ctx, oldID, k3sNodeConfigDir)
+	if errdefs.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	// docker archives the directory under its base name, it's extracted at / since /etc/rancher might not exist yet
+	buffer := &bytes.Buffer{}
+	tarWriter := tar.NewWriter(buffer)
+	if err := reparentTarEntries(tar.NewReader(reader), tarWriter, strings.TrimPrefix(path.Dir(k3sNodeConfigDir), "/")); err != nil {
+		return err
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return rt.CopyToContainer(ctx, newID, "/", buffer)
+}
+
+// imageEnv returns the default environment variables (`KEY=value`) of a local image, which docker adds
+// to the environment of every container of the image
+func imageEnv(ctx context.Context, rt Runtime, image string) (map[string]bool, error) {
+	info, err := rt.InspectImage(ctx, image)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't inspect image %s\n%+v", image, err)
+	}
+	env := map[string]bool{}
+	if info.Config != nil {
+		for _, variable := range info.Config.Env {
+			env[variable] = true
+		}
+	}
+	return env, nil
+}
+
+// waitForNodeReady waits until the Kubernetes node reports Ready by running kubectl in the server container.
+// If the image tag is a k3s version, the node also has to run that version, so that the Ready condition
+// of the replaced node isn't mistaken for the one of the new node.
+func waitForNodeReady(ctx context.Context, rt Runtime, serverID, nodeName, image string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	expectedVersion := k3sVersionFromImage(image)
+	cmd := []string{"kubectl", "get", "node", nodeName, "-o",
+		`jsonpath={.status.conditions[?(@.type=="Ready")].status} {.status.nodeInfo.kubeletVersion}`}
+	for {
+		out, err := rt.Exec(ctx, serverID, cmd)
+		if err == nil {
+			fields := strings.Fields(out)
+			if len(fields) == 2 && fields[0] == "True" && (expectedVersion == "" || fields[1] == expectedVersion) {
+				return nil
+			}
+			log.Tracef("Node %s is not ready yet: %s", nodeName, out)
+		} else {
+			log.Tracef("Couldn't get status of node %s: %+v", nodeName, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("node %s didn't become ready within %s: %w", nodeName, timeout, ctx.Err())
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// sameImage compares two image references, e.g. rancher/k3s:v1.29.1-k3s1 and docker.io/rancher/k3s:v1.29.1-k3s1
+func sameImage(a, b string) bool {
+	namedA, errA := reference.ParseNormalizedNamed(a)
+	namedB, errB := reference.ParseNormalizedNamed(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return reference.TagNameOnly(namedA).String() == reference.TagNameOnly(namedB).String()
+}
+
+// k3sVersionFromImage returns the version that nodes running the image report, e.g. v1.29.1+k3s1
+// for rancher/k3s:v1.29.1-k3s1, or "" if the tag isn't a k3s version (e.g. latest)
+func k3sVersionFromImage(image string) string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return ""
+	}
+	tagged, ok := named.(reference.Tagged)
+	if !ok || !k3sVersionTagRegexp.MatchString(tagged.Tag()) {
+		return ""
+	}
+	return strings.Replace(tagged.Tag(), "-k3s", "+k3s", 1)
+}
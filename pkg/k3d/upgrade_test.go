@@ -0,0 +1,90 @@
+package k3d
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestUpgradeCluster(t *testing.T) {
+	ctx := context.Background()
+	k, rt := newTestClient(t)
+
+	cluster, err := k.CreateCluster(ctx, &ClusterSpec{Name: "test", Image: "rancher/k3s:v1.29.1-k3s1", Workers: 1, Env: []string{"FOO=bar"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker := cluster.Workers[0]
+	// k3s writes the node password when the node joins, docker adds the environment of the image to the container
+	if err := rt.CopyToContainer(ctx, worker.ID, "/", tarFile(t, "etc/rancher/node/password", []byte("secret"))); err != nil {
+		t.Fatal(err)
+	}
+	info, err := rt.InspectContainer(ctx, worker.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Config.Env = append(info.Config.Env, "PATH=/bin")
+
+	rt.ExecHandler = func(ID string, cmd []string) (string, error) {
+		if cmd[0] == "kubectl" {
+			return "True v1.30.0+k3s1", nil
+		}
+		return "done", nil
+	}
+	if err := k.UpgradeCluster(ctx, &UpgradeSpec{Name: "test", Image: "rancher/k3s:v1.30.0-k3s1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster, err = k.GetCluster(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upgraded := cluster.Workers[0]
+	if upgraded.ID == worker.ID || !strings.HasSuffix(upgraded.Image, "v1.30.0-k3s1") {
+		t.Fatalf("worker wasn't replaced: %+v", upgraded)
+	}
+	if password := string(rt.Files[upgraded.ID]["/etc/rancher/node/password"]); password != "secret" {
+		t.Errorf("node password = %q, want %q", password, "secret")
+	}
+	info, err = rt.InspectContainer(ctx, upgraded.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := strings.Join(info.Config.Env, " ")
+	if strings.Contains(env, "PATH=/bin") {
+		t.Errorf("the environment of the old image was passed on: %s", env)
+	}
+	if !strings.Contains(env, "FOO=bar") || !strings.Contains(env, "K3S_TOKEN=") {
+		t.Errorf("the environment of the node wasn't passed on: %s", env)
+	}
+}
+
+func TestDeleteUpgradedClusterRemovesVolumes(t *testing.T) {
+	ctx := context.Background()
+	k, rt := newTestClient(t)
+
+	// without data volumes, /var/lib/rancher/k3s is an anonymous volume of the image
+	if _, err := k.CreateCluster(ctx, &ClusterSpec{Name: "test", Image: "rancher/k3s:v1.29.1-k3s1", Workers: 1}); err != nil {
+		t.Fatal(err)
+	}
+	volumes, _ := rt.ListVolumes(ctx, nil)
+	if len(volumes) != 2 {
+		t.Fatalf("%d volumes after the creation, want 2", len(volumes))
+	}
+	rt.ExecHandler = func(ID string, cmd []string) (string, error) {
+		return "True v1.30.0+k3s1", nil
+	}
+	if err := k.UpgradeCluster(ctx, &UpgradeSpec{Name: "test", Image: "rancher/k3s:latest"}); err != nil {
+		t.Fatal(err)
+	}
+	if upgraded, _ := rt.ListVolumes(ctx, nil); len(upgraded) != 2 {
+		t.Fatalf("%d volumes after the upgrade, want the 2 volumes of the replaced containers", len(upgraded))
+	}
+
+	if err := k.DeleteCluster(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := rt.ListVolumes(ctx, nil); len(left) != 0 {
+		t.Errorf("%d volumes left after deleting the upgraded cluster", len(left))
+	}
+}
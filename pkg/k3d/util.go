@@ -0,0 +1,101 @@
+package k3d
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+type apiPort struct {
+	Host   string
+	HostIP string
+	Port   string
+}
+
+const clusterNameMaxSize int = 35
+
+// Make sure a cluster name is also a valid host name according to RFC 1123.
+// We further restrict the length of the cluster name to shorter than 'clusterNameMaxSize'
+// so that we can construct the host names based on the cluster name, and still stay
+// within the 64 characters limit.
+func CheckClusterName(name string) error {
+	if err := ValidateHostname(name); err != nil {
+		return fmt.Errorf("Invalid cluster name\n%+v", ValidateHostname(name))
+	}
+	if len(name) > clusterNameMaxSize {
+		return fmt.Errorf("Cluster name is too long (%d > %d)", len(name), clusterNameMaxSize)
+	}
+	return nil
+}
+
+// ValidateHostname ensures that a cluster name is also a valid host name according to RFC 1123.
+func ValidateHostname(name string) error {
+	// Hostname mustbe defined
+	if len(name) == 0 {
+		return fmt.Errorf("Hostname [%s] must not be empty", name)
+	}
+	if name[0] == '-' || name[len(name)-1] == '-' {
+		return fmt.Errorf("Hostname [%s] must not start or end with - (dash)", name)
+	}
+
+	for _, c := range name {
+		switch {
+		case '0' <= c && c <= '9':
+		case 'a' <= c && c <= 'z':
+		case 'A' <= c && c <= 'Z':
+		case c == '-':
+			continue
+		default:
+			return fmt.Errorf("Hostname [%s] contains characters other than 'Aa-Zz', '0-9' or '-'", name)
+
+		}
+	}
+	return nil
+}
+
+// usage: portSpec = localhost:8080, [::1]:8080 or 8080
+func parseAPIPort(portSpec string) (*apiPort, error) {
+
+	var port *apiPort
+	// a plain port number doesn't contain any colon
+	if !strings.Contains(portSpec, ":") {
+		port = &apiPort{Port: portSpec}
+	} else {
+		// SplitHostPort handles IPv6 literals in brackets, e.g. [::1]:6443
+		host, p, err := net.SplitHostPort(portSpec)
+		if err != nil {
+			return nil, fmt.Errorf("api-port format error\n%+v", err)
+		}
+		hostIP := host
+		// Make sure 'host' can be resolved to an IP address (IP literals don't need to be resolved)
+		if net.ParseIP(host) == nil {
+			// LookupHost looks up the given host using the local resolver. It returns a slice of that host's addresses.
+			addrs, err := net.LookupHost(host)
+			if err != nil {
+				return nil, err
+			}
+			hostIP = addrs[0]
+		}
+		// port: &{Host:localhost HostIp:127.0.0.1 Port:8080}
+		port = &apiPort{Host: host, HostIP: hostIP, Port: p}
+	}
+
+	// Verify 'port' is an integer and within port ranges
+	p, err := strconv.Atoi(port.Port)
+	if err != nil {
+		return nil, err
+	}
+
+	if p < 0 || p > 65535 {
+		return nil, fmt.Errorf("--api-port port value out of range")
+	}
+
+	return port, nil
+}
+
+// isIPv6 returns true if the given string is an IPv6 address literal
+func isIPv6(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.To4() == nil
+}
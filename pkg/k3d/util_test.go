@@ -0,0 +1,34 @@
+package k3d
+
+import "testing"
+
+func TestParseAPIPort(t *testing.T) {
+	tests := []struct {
+		spec string
+		want apiPort
+	}{
+		{"6443", apiPort{Port: "6443"}},
+		{"127.0.0.1:6550", apiPort{Host: "127.0.0.1", HostIP: "127.0.0.1", Port: "6550"}},
+		{"[::1]:6550", apiPort{Host: "::1", HostIP: "::1", Port: "6550"}},
+		{"[fd00::1]:0", apiPort{Host: "fd00::1", HostIP: "fd00::1", Port: "0"}},
+	}
+	for _, test := range tests {
+		port, err := parseAPIPort(test.spec)
+		if err != nil {
+			t.Errorf("parseAPIPort(%q) returned %v", test.spec, err)
+		} else if *port != test.want {
+			t.Errorf("parseAPIPort(%q) = %+v, want %+v", test.spec, *port, test.want)
+		}
+	}
+
+	// host names are resolved to an IP address
+	if port, err := parseAPIPort("localhost:6550"); err != nil || port.Host != "localhost" || port.HostIP == "" || port.Port != "6550" {
+		t.Errorf("parseAPIPort(%q) = %+v, %v, want the resolved IP of localhost", "localhost:6550", port, err)
+	}
+
+	for _, spec := range []string{"", "port", "65536", "-1", "::1:6550", "[::1]", "127.0.0.1:", "127.0.0.1:http", "[::1]:70000"} {
+		if port, err := parseAPIPort(spec); err == nil {
+			t.Errorf("parseAPIPort(%q) = %+v, want an error", spec, *port)
+		}
+	}
+}
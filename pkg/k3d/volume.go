@@ -0,0 +1,207 @@
+package k3d
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	log "github.com/sirupsen/logrus"
+)
+
+// k3sDataDir is the directory in which k3s keeps the state of a node (datastore, certificates, containerd images)
+const k3sDataDir = "/var/lib/rancher/k3s"
+
+// tokenHashLabel is the volume label keeping the SHA-256 hash of the token of the cluster the data volumes were created for.
+// k3s refuses to start on a datastore encrypted with another token, so the token is reused along with the volumes.
+// The token itself is kept in the data volume of the server (see clusterTokenFile), since labels are shown to anyone
+// with access to docker.
+const tokenHashLabel = "tokenHash"
+
+// clusterTokenFile is the file in the data volume of the server keeping the token of the cluster
+const clusterTokenFile = k3sDataDir + "/k3d/token"
+
+// adoptedVolumesLabel is the container label listing the (comma-separated) volumes a node took over from the anonymous
+// volumes of the container it replaced (see replacementContainerConfig). They are mounted by name, so docker doesn't
+// remove them along with the container.
+const adoptedVolumesLabel = "volumes"
+
+// nodeVolumeDirs are the directories of a node kept in named volumes, by the suffix of the volume name:
+// the k3s state and the node password, which the node needs to rejoin the cluster under its name
+var nodeVolumeDirs = []struct {
+	suffix string
+	dir    string
+}{
+	{suffix: "data", dir: k3sDataDir},
+	{suffix: "node", dir: k3sNodeConfigDir},
+}
+
+// nodeVolumeName returns the name of a volume of a node, e.g. k3d-mycluster-worker-0-data
+func nodeVolumeName(containerName, suffix string) string {
+	return fmt.Sprintf("%s-%s", containerName, suffix)
+}
+
+// isNodeVolumeBind checks if a bind mounts one of the volumes of a node (see nodeVolumeDirs)
+func isNodeVolumeBind(containerName, bind string) bool {
+	for _, volume := range nodeVolumeDirs {
+		if bind == fmt.Sprintf("%s:%s", nodeVolumeName(containerName, volume.suffix), volume.dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// adoptedVolumes returns the volumes listed in the adoptedVolumesLabel of a container
+func adoptedVolumes(labels map[string]string) []string {
+	if labels[adoptedVolumesLabel] == "" {
+		return []string{}
+	}
+	return strings.Split(labels[adoptedVolumesLabel], ",")
+}
+
+// removeAdoptedVolumes removes the volumes a node took over from the container it replaced.
+// It must be called after the container of the node was removed and returns the names of the removed volumes.
+func removeAdoptedVolumes(ctx context.Context, rt Runtime, node Node) []string {
+	removed := []string{}
+	for _, name := range adoptedVolumes(node.Labels) {
+		if err := rt.RemoveVolume(ctx, name); err != nil {
+			log.Warnf("couldn't remove volume %s of node %s: %+v", name, node.Name, err)
+			continue
+		}
+		removed = append(removed, name)
+	}
+	return removed
+}
+
+// ensureNodeVolumes returns the binds of the volumes of a node (e.g. `<volume>:/var/lib/rancher/k3s`, see nodeVolumeDirs).
+// Volumes are reused if they exist already (e.g. they were kept when the cluster was deleted), otherwise they're
+// created with the token of the cluster and recorded in the journal.
+func ensureNodeVolumes(ctx context.Context, rt Runtime, j *journal, clusterName, component, containerName, token string) ([]string, error) {
+	labels := map[string]string{
+		"app":       "k3d",
+		"cluster":   clusterName,
+		"component": component,
+		"node":      containerName,
+	}
+	volumes, err := rt.ListVolumes(ctx, labels)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list volumes\n%+v", err)
+	}
+	existing := map[string]bool{}
+	for _, volume := range volumes {
+		existing[volume.Name] = true
+	}
+
+	binds := []string{}
+	for _, volume := range nodeVolumeDirs {
+		name := nodeVolumeName(containerName, volume.suffix)
+		binds = append(binds, fmt.Sprintf("%s:%s", name, volume.dir))
+		if existing[name] {
+			log.Infof("Reusing volume %s", name)
+			continue
+		}
+		volumeLabels := map[string]string{tokenHashLabel: hashToken(token)}
+		for key, value := range labels {
+			volumeLabels[key] = value
+		}
+		if _, err := rt.CreateVolume(ctx, name, volumeLabels); err != nil {
+			return nil, fmt.Errorf("couldn't create volume %s\n%+v", name, err)
+		}
+		j.record(resourceVolume, name, name)
+	}
+	return binds, nil
+}
+
+// hashToken returns the SHA-256 hash of a token (hex encoded)
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// keptVolumesTokenHash returns the hash of the token the existing volumes of a cluster were created with (e.g. volumes
+// kept by DeleteOptions.KeepVolumes) and whether there are any. The hash is "" if the volumes don't record it.
+func keptVolumesTokenHash(ctx context.Context, rt Runtime, clusterName string) (string, bool, error) {
+	volumes, err := rt.ListVolumes(ctx, map[string]string{"app": "k3d", "cluster": clusterName})
+	if err != nil {
+		return "", false, fmt.Errorf("couldn't list volumes of cluster %s\n%+v", clusterName, err)
+	}
+	tokenHash := ""
+	for _, volume := range volumes {
+		volumeHash := volume.Labels[tokenHashLabel]
+		if volumeHash != "" && tokenHash != "" && volumeHash != tokenHash {
+			return "", true, fmt.Errorf("the volumes of cluster %s were created with different tokens, remove them with `k3d prune --volumes`", clusterName)
+		}
+		if volumeHash != "" {
+			tokenHash = volumeHash
+		}
+	}
+	return tokenHash, len(volumes) > 0, nil
+}
+
+// copyClusterToken writes the token of the cluster into the data volume of the server (see clusterTokenFile)
+func copyClusterToken(ctx context.Context, rt Runtime, ID, token string) error {
+	buffer := &bytes.Buffer{}
+	tarWriter := tar.NewWriter(buffer)
+	if err := writeTarEntry(tarWriter, strings.TrimPrefix(clusterTokenFile, "/"), int64(len(token)), strings.NewReader(token)); err != nil {
+		return err
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	if err := rt.CopyToContainer(ctx, ID, "/", buffer); err != nil {
+		return fmt.Errorf("couldn't copy the token into the server\n%+v", err)
+	}
+	return nil
+}
+
+// readKeptToken reads the token of a cluster from the kept data volume of its server (see clusterTokenFile).
+// The volume is mounted into a helper container of the given image, which is never started.
+func readKeptToken(ctx context.Context, rt Runtime, clusterName, image string) (string, error) {
+	serverName := GetContainerName("server", clusterName, -1)
+	helperName := fmt.Sprintf("%s-token", serverName)
+	ID, err := rt.CreateContainer(ctx, helperName, &container.Config{Image: image, Labels: map[string]string{"app": "k3d"}}, &container.HostConfig{
+		Binds: []string{fmt.Sprintf("%s:%s", nodeVolumeName(serverName, "data"), k3sDataDir)},
+	}, nil)
+	if err != nil {
+		return "", fmt.Errorf("couldn't create container %s\n%+v", helperName, err)
+	}
+	defer func() {
+		if err := rt.RemoveContainer(context.WithoutCancel(ctx), ID, true); err != nil {
+			log.Warnf("couldn't remove container %s: %+v", helperName, err)
+		}
+	}()
+
+	reader, err := rt.CopyFromContainer(ctx, ID, clusterTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("the existing volumes of cluster %s don't keep its token, use the token it was created with (--token) or remove the volumes with `k3d prune --volumes`\n%+v", clusterName, err)
+	}
+	defer reader.Close()
+	tarReader := tar.NewReader(reader)
+	if _, err := tarReader.Next(); err != nil {
+		return "", fmt.Errorf("couldn't read the token of cluster %s\n%+v", clusterName, err)
+	}
+	token, err := io.ReadAll(tarReader)
+	if err != nil {
+		return "", fmt.Errorf("couldn't read the token of cluster %s\n%+v", clusterName, err)
+	}
+	return string(token), nil
+}
+
+// deleteClusterVolumes removes the data volumes of a cluster
+func deleteClusterVolumes(ctx context.Context, rt Runtime, clusterName string) error {
+	volumes, err := rt.ListVolumes(ctx, map[string]string{"app": "k3d", "cluster": clusterName})
+	if err != nil {
+		return fmt.Errorf("couldn't list volumes of cluster %s\n%+v", clusterName, err)
+	}
+	for _, volume := range volumes {
+		if err := rt.RemoveVolume(ctx, volume.Name); err != nil {
+			log.Warnf("couldn't remove volume %s: %+v", volume.Name, err)
+		}
+	}
+	return nil
+}
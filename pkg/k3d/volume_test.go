@@ -0,0 +1,91 @@
+package k3d
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDataVolumesAreReusedWithTheirToken(t *testing.T) {
+	ctx := context.Background()
+	k, rt := newTestClient(t)
+
+	if _, err := k.CreateCluster(ctx, &ClusterSpec{Name: "test", Workers: 1, DataVolumes: true}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := k.GetClusterToken(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumes, _ := rt.ListVolumes(ctx, map[string]string{"cluster": "test"})
+	names := []string{}
+	for _, volume := range volumes {
+		names = append(names, volume.Name)
+		// the token itself isn't shown by `docker volume inspect`, only its hash
+		if volume.Labels[tokenHashLabel] != hashToken(token) {
+			t.Errorf("volume %s records token hash %q, want %q", volume.Name, volume.Labels[tokenHashLabel], hashToken(token))
+		}
+		for key, value := range volume.Labels {
+			if strings.Contains(value, token) {
+				t.Errorf("volume %s shows the token in its label %s", volume.Name, key)
+			}
+		}
+	}
+	for _, name := range []string{"k3d-test-server-data", "k3d-test-server-node", "k3d-test-worker-0-data", "k3d-test-worker-0-node"} {
+		if !strings.Contains(strings.Join(names, " "), name) {
+			t.Errorf("volume %s wasn't created, volumes: %v", name, names)
+		}
+	}
+	if err := k.DeleteClusterWithOptions(ctx, "test", DeleteOptions{KeepVolumes: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := k.CreateCluster(ctx, &ClusterSpec{Name: "test", Workers: 1, DataVolumes: true, Token: "another"}); err == nil {
+		t.Fatal("reusing the volumes with another token succeeded")
+	}
+	if _, err := k.CreateCluster(ctx, &ClusterSpec{Name: "test", Workers: 1, DataVolumes: true}); err != nil {
+		t.Fatal(err)
+	}
+	if reused, err := k.GetClusterToken(ctx, "test"); err != nil {
+		t.Error(err)
+	} else if reused != token {
+		t.Errorf("token = %q, want the token of the volumes %q", reused, token)
+	}
+	// the helper container reading the token from the volume of the server is removed
+	if containers, _ := rt.ListContainers(ctx, true, map[string]string{"app": "k3d"}); len(containers) != 2 {
+		t.Errorf("%d containers are left, want the 2 nodes", len(containers))
+	}
+}
+
+func TestDataVolumesWithoutTheirTokenFile(t *testing.T) {
+	ctx := context.Background()
+	k, rt := newTestClient(t)
+
+	// volumes whose server never got the token file (e.g. its creation failed before)
+	labels := map[string]string{"app": "k3d", "cluster": "test", "component": "server", "node": "k3d-test-server", tokenHashLabel: hashToken("secret")}
+	if _, err := rt.CreateVolume(ctx, "k3d-test-server-data", labels); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.CreateCluster(ctx, &ClusterSpec{Name: "test", DataVolumes: true}); err == nil || !strings.Contains(err.Error(), "--token") {
+		t.Fatalf("creation returned %v, want an error asking for the token", err)
+	}
+	if _, err := k.CreateCluster(ctx, &ClusterSpec{Name: "test", DataVolumes: true, Token: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDataVolumesWithoutTokenNeedAToken(t *testing.T) {
+	ctx := context.Background()
+	k, rt := newTestClient(t)
+
+	// a volume kept by a k3d version that didn't record the token
+	if _, err := rt.CreateVolume(ctx, "k3d-test-server-data", map[string]string{"app": "k3d", "cluster": "test", "component": "server", "node": "k3d-test-server"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.CreateCluster(ctx, &ClusterSpec{Name: "test", DataVolumes: true}); err == nil || !strings.Contains(err.Error(), "--token") {
+		t.Fatalf("creation returned %v, want an error asking for the token", err)
+	}
+	if _, err := k.CreateCluster(ctx, &ClusterSpec{Name: "test", DataVolumes: true, Token: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+}